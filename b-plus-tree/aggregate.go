@@ -0,0 +1,149 @@
+package bplustree
+
+import (
+	"math"
+)
+
+// Monoid aggregates key / value pairs: a summary of pairs in a sub-tree is kept in its parent, so `Aggregate`
+// combines summaries of whole sub-trees instead of scanning their pairs. `Combine` must be associative,
+// with `Identity` as its identity
+type Monoid struct {
+	Identity Data
+	Lift     func(key Data, value Data) Data  // summary of a single pair
+	Combine  func(left Data, right Data) Data // summary of pairs of `left` followed by pairs of `right`
+}
+
+// Summary of every pair in sub-tree of a node, from its pairs or summaries of its children
+func (t *BTree) summarize(node *BNode) Data {
+	summary := t.Monoid.Identity
+	if node.IsLeaf {
+		for i := uint8(0); i < node.NumKeys; i++ {
+			summary = t.Monoid.Combine(summary, t.Monoid.Lift(node.Keys[i], node.Values[i]))
+		}
+		return summary
+	}
+	for i := uint8(0); i <= node.NumKeys; i++ {
+		summary = t.Monoid.Combine(summary, node.Summaries[i])
+	}
+	return summary
+}
+
+// Set summary of child at `pos` of an internal node from current content of the child, node must be dirty
+func (t *BTree) setSummary(node *BNode, pos uint8) {
+	if t.Monoid != nil {
+		node.Summaries[pos] = t.summarize(t.Get(node.Child[pos]))
+	}
+}
+
+// Set summaries of every ancestor bottom-up, after pairs of a node under them are changed:
+//
+//	ancestorsStack: with higher index is closer to parent of the changed node, and index 0 is the root of a tree
+func (t *BTree) refreshSummaries(ancestorsStack []parentInfo) {
+	if t.Monoid == nil {
+		return
+	}
+	for i := len(ancestorsStack) - 1; i >= 0; i-- {
+		t.dirty(ancestorsStack[i].parentPtr)
+		t.setSummary(t.Get(ancestorsStack[i].parentPtr), ancestorsStack[i].childIndexInParentNode)
+	}
+}
+
+// Summary of every pair with `start` <= key < `end`, nil `start` or `end` means no bound.
+// Only sub-trees partly inside the range are visited, so it walks at most 2 paths from root.
+// Returns nil if tree has no `Monoid`
+func (t BTree) Aggregate(start Data, end Data) Data {
+	if t.Monoid == nil {
+		return nil
+	}
+	return t.aggregateNode(t.Root, start, end, nil, nil)
+}
+
+// Summary of pairs in range of a sub-tree, its keys are in [`lower`, `upper`], nil means no bound
+func (t *BTree) aggregateNode(nodePtr uint64, start Data, end Data, lower Data, upper Data) Data {
+	summary := t.Monoid.Identity
+	node := t.Get(nodePtr)
+	if node == nil {
+		return summary
+	}
+	if node.IsLeaf {
+		for i := uint8(0); i < node.NumKeys; i++ {
+			key := node.Keys[i]
+			if (start == nil || !key.lt(start)) && (end == nil || key.lt(end)) {
+				summary = t.Monoid.Combine(summary, t.Monoid.Lift(key, node.Values[i]))
+			}
+		}
+		return summary
+	}
+	for i := uint8(0); i <= node.NumKeys; i++ {
+		childLower, childUpper := lower, upper
+		if i > 0 {
+			childLower = node.Keys[i-1]
+		}
+		if i < node.NumKeys {
+			childUpper = node.Keys[i]
+		}
+		// `childUpper` is inclusive, because duplicated keys equal to a separator may stay in the left child
+		if (end != nil && childLower != nil && !childLower.lt(end)) || (start != nil && childUpper != nil && childUpper.lt(start)) {
+			continue
+		}
+		if (start == nil || (childLower != nil && !childLower.lt(start))) && (end == nil || (childUpper != nil && childUpper.lt(end))) {
+			summary = t.Monoid.Combine(summary, node.Summaries[i])
+		} else {
+			summary = t.Monoid.Combine(summary, t.aggregateNode(node.Child[i], start, end, childLower, childUpper))
+		}
+	}
+	return summary
+}
+
+// ============================= BUILT-IN MONOIDS ==================================
+// values are int64 encoded by `EncodeInt64`, a value which is not 8 bytes is counted as 0
+
+func int64Value(value Data) int64 {
+	number, err := DecodeInt64(value)
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+func int64Monoid(identity int64, lift func(value Data) int64, combine func(left int64, right int64) int64) *Monoid {
+	return &Monoid{
+		Identity: EncodeInt64(identity),
+		Lift: func(key Data, value Data) Data {
+			return EncodeInt64(lift(value))
+		},
+		Combine: func(left Data, right Data) Data {
+			return EncodeInt64(combine(int64Value(left), int64Value(right)))
+		},
+	}
+}
+
+// Sum of values
+func SumMonoid() *Monoid {
+	return int64Monoid(0, int64Value, func(left int64, right int64) int64 { return left + right })
+}
+
+// Minimum of values, `math.MaxInt64` if there is no pair
+func MinMonoid() *Monoid {
+	return int64Monoid(math.MaxInt64, int64Value, func(left int64, right int64) int64 {
+		if right < left {
+			return right
+		}
+		return left
+	})
+}
+
+// Maximum of values, `math.MinInt64` if there is no pair
+func MaxMonoid() *Monoid {
+	return int64Monoid(math.MinInt64, int64Value, func(left int64, right int64) int64 {
+		if right > left {
+			return right
+		}
+		return left
+	})
+}
+
+// Number of pairs
+func CountMonoid() *Monoid {
+	return int64Monoid(0, func(value Data) int64 { return 1 }, func(left int64, right int64) int64 { return left + right })
+}
@@ -0,0 +1,124 @@
+package bplustree
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Check `Aggregate` of every range of `keys` against combining pairs one by one, `values` holds value of each key
+func assertAggregate(t *testing.T, tree BTree, keys []uint16, values map[uint16]int64) {
+	bounds := []Data{nil}
+	for key := uint16(0); key <= 210; key += 7 {
+		bounds = append(bounds, createBigEndianData(key))
+	}
+	for _, start := range bounds {
+		for _, end := range bounds {
+			expected := tree.Monoid.Identity
+			for _, key := range keys {
+				data := createBigEndianData(key)
+				if (start == nil || !data.lt(start)) && (end == nil || data.lt(end)) {
+					expected = tree.Monoid.Combine(expected, tree.Monoid.Lift(data, EncodeInt64(values[key])))
+				}
+			}
+			assert.Equal(t, expected, tree.Aggregate(start, end), "range [%v, %v)", start, end)
+		}
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	monoids := map[string]*Monoid{"sum": SumMonoid(), "min": MinMonoid(), "max": MaxMonoid(), "count": CountMonoid()}
+	for name, monoid := range monoids {
+		for order := uint8(3); order <= 6; order++ {
+			c := newC(t, order)
+			c.tree.Monoid = monoid
+			assert.Equal(t, monoid.Identity, c.tree.Aggregate(nil, nil))
+			random := rand.New(rand.NewSource(int64(order)))
+			values := map[uint16]int64{}
+			for i := 0; i < 600; i++ {
+				key := uint16(random.Intn(200))
+				value := int64(random.Intn(2000) - 1000)
+				_, exists := values[key]
+				switch random.Intn(4) {
+				case 0:
+					if exists {
+						c.del(createBigEndianData(key))
+						delete(values, key)
+					}
+				case 1:
+					c.tree.Update(createBigEndianData(key), func(old Data, exists bool) (Data, bool) {
+						return EncodeInt64(value), true
+					})
+					values[key] = value
+				case 2:
+					batch := &Batch{}
+					for j := uint16(0); j < 5; j++ {
+						batch.Put(createBigEndianData(key+j), EncodeInt64(value+int64(j)))
+						values[key+j] = value + int64(j)
+					}
+					c.tree.ApplyBatch(batch)
+				default:
+					if !exists {
+						c.add(createBigEndianData(key), EncodeInt64(value))
+						values[key] = value
+					}
+				}
+			}
+			assert.Empty(t, c.tree.Validate(), "%s order %d", name, order)
+			keys := []uint16{}
+			for key := range values {
+				keys = append(keys, key)
+			}
+			sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+			assertAggregate(t, c.tree, keys, values)
+		}
+	}
+}
+
+func TestAggregateWithoutMonoid(t *testing.T) {
+	c := newC(t, 4)
+	c.add(createBigEndianData(1), EncodeInt64(1))
+	assert.Nil(t, c.tree.Aggregate(nil, nil))
+}
+
+func TestAggregateBulkLoad(t *testing.T) {
+	c := newC(t, 4)
+	c.tree.Monoid = SumMonoid()
+	keys := []Data{}
+	values := []Data{}
+	expected := map[uint16]int64{}
+	ordered := []uint16{}
+	for key := uint16(0); key < 200; key += 2 {
+		keys = append(keys, createBigEndianData(key))
+		values = append(values, EncodeInt64(int64(key)))
+		expected[key] = int64(key)
+		ordered = append(ordered, key)
+	}
+	assert.Nil(t, c.tree.BulkLoad(keys, values))
+	assert.Empty(t, c.tree.Validate())
+	assert.Equal(t, EncodeInt64(9900), c.tree.Aggregate(nil, nil))
+	assertAggregate(t, c.tree, ordered, expected)
+}
+
+func TestAggregatePersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	tree.Monoid = MaxMonoid()
+	for i := uint16(0); i < 300; i++ {
+		tree.Insert(createBigEndianData(i), EncodeInt64(int64(i%97)))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	tree = pager.Tree()
+	tree.Monoid = MaxMonoid()
+	assert.Empty(t, tree.Validate())
+	assert.Equal(t, EncodeInt64(96), tree.Aggregate(nil, nil))
+	assert.Equal(t, EncodeInt64(50), tree.Aggregate(createBigEndianData(200), createBigEndianData(245)))
+	assert.Nil(t, pager.Close())
+}
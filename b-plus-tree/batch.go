@@ -0,0 +1,184 @@
+package bplustree
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Batch accumulates puts and deletes, which are applied to a tree together by `ApplyBatch`.
+// A later write of a key in the batch replaces an earlier one
+type Batch struct {
+	writes []batchWrite
+}
+
+type batchWrite struct {
+	key     Data
+	value   Data
+	deleted bool
+}
+
+// Put `value` of `key`, it replaces the current value if `key` exists in tree
+func (b *Batch) Put(key Data, value Data) {
+	b.writes = append(b.writes, batchWrite{key: key, value: value})
+}
+
+func (b *Batch) Delete(key Data) {
+	b.writes = append(b.writes, batchWrite{key: key, deleted: true})
+}
+
+// Number of writes in the batch
+func (b *Batch) Len() int {
+	return len(b.writes)
+}
+
+// Remove every write, so the batch can be reused
+func (b *Batch) Reset() {
+	b.writes = b.writes[:0]
+}
+
+// Writes in ascending order of keys, only the last write of each key is kept
+func (b *Batch) sorted() []batchWrite {
+	writes := append([]batchWrite(nil), b.writes...)
+	sort.SliceStable(writes, func(i, j int) bool { return writes[i].key.lt(writes[j].key) })
+	unique := writes[:0]
+	for i, write := range writes {
+		if i+1 < len(writes) && writes[i+1].key.eq(write.key) {
+			continue
+		}
+		unique = append(unique, write)
+	}
+	return unique
+}
+
+// Descend from root to the leaf which `key` is routed to.
+// Returns:
+//
+//	uint64: pointer of the leaf
+//	*BNode: the leaf, nil if tree is empty
+//	Data: every key less than it, and not less than `key`, is routed to the leaf. nil means no bound
+//	[]parentInfo: ancestors of the leaf, index 0 is the root of a tree
+func (t *BTree) leafOf(key Data) (uint64, *BNode, Data, []parentInfo) {
+	ptr := t.Root
+	cursor := t.Get(ptr)
+	var upper Data
+	ancestorsStack := []parentInfo{}
+	for cursor != nil && !cursor.IsLeaf {
+		// same routing as `Search`: `key` equal to a separator belongs to the right child
+		var pos uint8
+		for pos < cursor.NumKeys && !key.lt(cursor.Keys[pos]) {
+			pos += 1
+		}
+		if pos < cursor.NumKeys {
+			upper = cursor.Keys[pos]
+		}
+		ancestorsStack = append(ancestorsStack, parentInfo{parentPtr: ptr, childIndexInParentNode: pos})
+		ptr = cursor.Child[pos]
+		cursor = t.Get(ptr)
+	}
+	return ptr, cursor, upper, ancestorsStack
+}
+
+// Apply writes of `batch` in ascending order of keys. Writes to the same leaf are done without descending from root
+// again, unless the leaf has to be split or rebalanced, and key counts and summaries of its ancestors are updated once.
+// The batch is not durable by itself: with a `Pager`, its writes are flushed together by the next `Commit`,
+// so a crash keeps all or none of them. `DB.ApplyBatch` does both
+func (t *BTree) ApplyBatch(batch *Batch) {
+	var leafPtr uint64
+	var leaf *BNode
+	var upper Data
+	var ancestorsStack []parentInfo
+	var delta int64 // change of key count of `leaf`, added to its ancestors before leaving it
+	written := false
+	leave := func() {
+		t.adjustCounts(ancestorsStack, delta)
+		if written {
+			t.refreshSummaries(ancestorsStack)
+		}
+		ancestorsStack, delta, written = nil, 0, false
+	}
+	for _, write := range batch.sorted() {
+		if leaf == nil || (upper != nil && !write.key.lt(upper)) {
+			leave()
+			leafPtr, leaf, upper, ancestorsStack = t.leafOf(write.key)
+		}
+		if changed, ok := t.writeToLeaf(leafPtr, leaf, write); ok {
+			delta += changed
+			written = true
+			continue
+		}
+		// tree may be restructured, so descend again for the next write
+		leave()
+		if write.deleted {
+			t.Delete(write.key)
+		} else {
+			t.Insert(write.key, write.value)
+		}
+		leaf = nil
+	}
+	leave()
+}
+
+// Apply `write` to a leaf without changing its ancestors.
+// Returns:
+//
+//	int64: change of number of keys in leaf
+//	bool: false if it can not, because the leaf is missing, full, or underflows
+func (t *BTree) writeToLeaf(leafPtr uint64, leaf *BNode, write batchWrite) (int64, bool) {
+	if leaf == nil {
+		return 0, false
+	}
+	var pos uint8
+	for pos < leaf.NumKeys && leaf.Keys[pos].lt(write.key) {
+		pos += 1
+	}
+	found := pos < leaf.NumKeys && leaf.Keys[pos].eq(write.key)
+	switch {
+	case !write.deleted && found:
+		t.dirty(leafPtr)
+		leaf.Values[pos] = write.value
+	case !write.deleted:
+		if leaf.NumKeys >= t.Order-1 {
+			return 0, false
+		}
+		t.dirty(leafPtr)
+		leaf.insertToLeafNode(write.key, write.value)
+		return 1, true
+	case found:
+		// an ancestor may have the smallest key of leaf as separator
+		if pos == 0 || (leafPtr != t.Root && leaf.NumKeys <= t.MinKey) {
+			return 0, false
+		}
+		t.dirty(leafPtr)
+		copy(leaf.Keys[pos:], leaf.Keys[pos+1:leaf.NumKeys])
+		copy(leaf.Values[pos:], leaf.Values[pos+1:leaf.NumKeys])
+		leaf.NumKeys -= 1
+		leaf.Keys[leaf.NumKeys] = nil
+		leaf.Values[leaf.NumKeys] = nil
+		return -1, true
+	}
+	return 0, true
+}
+
+// Apply `batch` in the transaction, it is rejected as a whole if a key is empty or the tree stores buckets
+func (tx *Tx) ApplyBatch(batch *Batch) error {
+	if err := tx.pairsCheck(); err != nil {
+		return err
+	}
+	for _, write := range batch.writes {
+		if len(write.key) == 0 {
+			return fmt.Errorf("empty key is reserved for buckets")
+		}
+	}
+	tx.tree.ApplyBatch(batch)
+	return nil
+}
+
+// Apply `batch` in a writable transaction and commit it, so either every write of the batch is durable or none
+func (db *DB) ApplyBatch(batch *Batch) error {
+	tx := db.Begin(true)
+	if err := tx.ApplyBatch(batch); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
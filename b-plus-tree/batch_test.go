@@ -0,0 +1,141 @@
+package bplustree
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchSorted(t *testing.T) {
+	batch := &Batch{}
+	batch.Put(Data{3}, Data{1})
+	batch.Delete(Data{1})
+	batch.Put(Data{3}, Data{2})
+	batch.Put(Data{1}, Data{3})
+	batch.Delete(Data{2})
+	assert.Equal(t, 5, batch.Len())
+	assert.Equal(t, []batchWrite{
+		{key: Data{1}, value: Data{3}},
+		{key: Data{2}, deleted: true},
+		{key: Data{3}, value: Data{2}},
+	}, batch.sorted())
+	batch.Reset()
+	assert.Equal(t, 0, batch.Len())
+}
+
+func TestApplyBatch(t *testing.T) {
+	for _, order := range []uint8{3, 4, 5, 8} {
+		c := newC(t, order)
+		expected := map[uint16]Data{}
+		r := rand.New(rand.NewSource(int64(order)))
+		for round := 0; round < 20; round++ {
+			batch := &Batch{}
+			for i := 0; i < 50; i++ {
+				key := uint16(r.Intn(300))
+				if r.Intn(3) == 0 {
+					batch.Delete(createBigEndianData(key))
+					delete(expected, key)
+				} else {
+					value := createData(uint16(r.Intn(1000)))
+					batch.Put(createBigEndianData(key), value)
+					expected[key] = value
+				}
+			}
+			c.tree.ApplyBatch(batch)
+			assert.Empty(t, c.tree.Validate())
+
+			total := 0
+			c.tree.Scan(nil, nil, func(key Data, value Data) bool {
+				total += 1
+				assert.EqualValues(t, expected[uint16(key[0])<<8|uint16(key[1])], value)
+				return true
+			})
+			assert.Equal(t, len(expected), total)
+		}
+	}
+}
+
+func TestApplyBatchSameLeaf(t *testing.T) {
+	c := newC(t, 16)
+	for i := uint16(0); i < 1000; i += 10 {
+		c.add(createBigEndianData(i), createData(i))
+	}
+	gets := 0
+	get := c.tree.Get
+	c.tree.Get = func(ptr uint64) *BNode {
+		gets += 1
+		return get(ptr)
+	}
+
+	// neighbouring keys share a leaf, which is reached once, then key counts of its ancestors are updated once
+	batch := &Batch{}
+	for i := uint16(501); i < 505; i++ {
+		batch.Put(createBigEndianData(i), createData(i))
+	}
+	c.tree.ApplyBatch(batch)
+	height := 0
+	for node := get(c.tree.Root); node != nil; node = get(node.Child[0]) {
+		height += 1
+		if node.IsLeaf {
+			break
+		}
+	}
+	assert.Equal(t, height+height-1, gets)
+	assert.Empty(t, c.tree.Validate())
+	for i := uint16(501); i < 505; i++ {
+		val, found := c.tree.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, createData(i), val)
+	}
+}
+
+func TestDBApplyBatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	batch := &Batch{}
+	for i := uint16(0); i < 200; i++ {
+		batch.Put(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, db.ApplyBatch(batch))
+	batch.Reset()
+	for i := uint16(0); i < 200; i += 2 {
+		batch.Delete(createBigEndianData(i))
+	}
+	batch.Put(createBigEndianData(1), createData(1000))
+	assert.Nil(t, db.ApplyBatch(batch))
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx := db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(1, 199, 2), txKeys(tx))
+	val, _ := tx.Search(createBigEndianData(1))
+	assert.EqualValues(t, createData(1000), val)
+	assert.Equal(t, ErrTxReadOnly, tx.ApplyBatch(batch))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func TestTxApplyBatchBuckets(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "db"))
+	tx := db.Begin(true)
+	batch := &Batch{}
+	batch.Put(createBigEndianData(1), createData(1))
+	batch.Put(Data{}, Data{BUCKET_ROOT})
+	assert.NotNil(t, tx.ApplyBatch(batch))
+	assert.Equal(t, uint64(0), tx.tree.Len())
+
+	// once the tree stores buckets, its marker and sub-buckets are not written by a batch
+	_, err := tx.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	batch.Reset()
+	batch.Put(Data("fake"), encodeNestedBucket(12345))
+	batch.Delete(Data("tenants"))
+	assert.Equal(t, ErrTxBuckets, tx.ApplyBatch(batch))
+	assert.NotNil(t, tx.Bucket(Data("tenants")))
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, ErrTxBuckets, db.ApplyBatch(batch))
+	assert.Nil(t, db.Close())
+}
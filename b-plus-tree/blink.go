@@ -0,0 +1,266 @@
+package bplustree
+
+import (
+	"sync"
+)
+
+// BLinkTree wraps a `BTree` to be safe for concurrent use as a B-link tree of Lehman and Yao.
+// Every node has a `HighKey` and a right link `Next` to its sibling in the same level, so a reader which lands
+// on a node that was split under it moves right instead of restarting from root. Readers hold one latch at a time,
+// a writer holds at most the node it changes, and its parent or right sibling while moving to them.
+// Nodes are never merged: `Delete` only removes the key from its leaf, so leaves may underflow or become empty.
+// After a leaf is changed, a writer sets key count and summary of each node in its parent bottom-up, latching the
+// parent before it releases the node, so ancestors count every write once it returns.
+// `Get`, `New` and `Del` of the wrapped tree must be safe for concurrent use.
+type BLinkTree struct {
+	rootLatch sync.RWMutex // protects `tree.Root` and `height`
+	latches   latchTable
+	tree      BTree
+	height    int // number of levels, 0 if tree is empty
+}
+
+func NewBLinkTree(tree BTree) *BLinkTree {
+	b := &BLinkTree{tree: tree}
+	for node := tree.Get(tree.Root); node != nil; node = tree.Get(node.Child[0]) {
+		b.height += 1
+		if node.IsLeaf {
+			break
+		}
+	}
+	return b
+}
+
+func lockLatch(latch *sync.RWMutex, write bool) {
+	if write {
+		latch.Lock()
+	} else {
+		latch.RLock()
+	}
+}
+
+func unlockLatch(latch *sync.RWMutex, write bool) {
+	if write {
+		latch.Unlock()
+	} else {
+		latch.RUnlock()
+	}
+}
+
+// Latch node at `ptr`, then follow right links until the node whose range contains `key`.
+// Returns:
+//
+//	uint64: pointer of the node, its latch is held
+//	*BNode: the node
+func (b *BLinkTree) latchCovering(ptr uint64, key Data, write bool) (uint64, *BNode) {
+	latch := b.latches.get(ptr)
+	lockLatch(latch, write)
+	node := b.tree.Get(ptr)
+	for node.HighKey != nil && !key.lt(node.HighKey) {
+		ptr = node.Next
+		nextLatch := b.latches.get(ptr)
+		lockLatch(nextLatch, write)
+		unlockLatch(latch, write)
+		latch = nextLatch
+		node = b.tree.Get(ptr)
+	}
+	return ptr, node
+}
+
+// Descend from root toward `key` through internal nodes at or above `level`, leaves are level 0.
+// Returns:
+//
+//	[]uint64: pointers of internal nodes visited in each level, from root
+//	uint64: pointer of the child in `level` - 1 which `key` is routed to, 0 if tree is empty
+func (b *BLinkTree) ancestors(key Data, level int) ([]uint64, uint64) {
+	b.rootLatch.RLock()
+	cursorPtr, height := b.tree.Root, b.height
+	b.rootLatch.RUnlock()
+	stack := []uint64{}
+	for h := height - 1; h >= level; h-- {
+		ptr, node := b.latchCovering(cursorPtr, key, false)
+		stack = append(stack, ptr)
+		// same routing as `Search`: `key` equal to a separator belongs to the right child
+		var i uint8
+		for i < node.NumKeys && !key.lt(node.Keys[i]) {
+			i += 1
+		}
+		cursorPtr = node.Child[i]
+		b.latches.get(ptr).RUnlock()
+	}
+	return stack, cursorPtr
+}
+
+// Descend from root to the leaf which may contain `key`, latch of the leaf is held in `write` mode.
+// Returns:
+//
+//	[]uint64: pointers of internal nodes visited in each level, from root
+//	uint64: pointer of the leaf, 0 if tree is empty
+//	*BNode: the leaf
+func (b *BLinkTree) descend(key Data, write bool) ([]uint64, uint64, *BNode) {
+	stack, leafPtr := b.ancestors(key, 1)
+	if leafPtr == 0 {
+		return nil, 0, nil
+	}
+	leafPtr, leaf := b.latchCovering(leafPtr, key, write)
+	return stack, leafPtr, leaf
+}
+
+func (b *BLinkTree) Search(key Data) (Data, bool) {
+	_, leafPtr, leaf := b.descend(key, false)
+	if leafPtr == 0 {
+		return nil, false
+	}
+	defer b.latches.get(leafPtr).RUnlock()
+	for pos := uint8(0); pos < leaf.NumKeys; pos++ {
+		if leaf.Keys[pos].eq(key) {
+			return leaf.Values[pos], true
+		}
+	}
+	return nil, false
+}
+
+func (b *BLinkTree) Insert(key Data, value Data) {
+	t := &b.tree
+	stack, leafPtr, leaf := b.descend(key, true)
+	for leafPtr == 0 {
+		b.rootLatch.Lock()
+		if t.Root == 0 { // first insert into tree
+			t.Insert(key, value)
+			b.height = 1
+			b.rootLatch.Unlock()
+			return
+		}
+		b.rootLatch.Unlock()
+		stack, leafPtr, leaf = b.descend(key, true)
+	}
+
+	if leaf.NumKeys < t.Order-1 {
+		t.dirty(leafPtr)
+		leaf.insertToLeafNode(key, value)
+		b.refreshAncestors(stack, key, leafPtr, 0)
+		return
+	}
+	b.insertSeparator(stack, key, leafPtr, t.splitFullLeafAndInsert(leafPtr, key, value))
+}
+
+// Latch parent of `childPtr` at `parentPtr`, it may be split too, so move right until the node which points to it.
+// Returns:
+//
+//	uint64: pointer of the parent, its write latch is held
+//	*BNode: the parent
+//	uint8: position of `childPtr` in children of the parent
+func (b *BLinkTree) latchParent(parentPtr uint64, childPtr uint64) (uint64, *BNode, uint8) {
+	latch := b.latches.get(parentPtr)
+	latch.Lock()
+	parent := b.tree.Get(parentPtr)
+	pos := childPosition(parent, childPtr)
+	for pos > parent.NumKeys {
+		parentPtr = parent.Next
+		nextLatch := b.latches.get(parentPtr)
+		nextLatch.Lock()
+		latch.Unlock()
+		latch = nextLatch
+		parent = b.tree.Get(parentPtr)
+		pos = childPosition(parent, childPtr)
+	}
+	return parentPtr, parent, pos
+}
+
+// Set key count and summary of a changed node in its parent, then of the parent in its parent, and so on to root:
+//
+//	stack: pointers of internal nodes visited in each level above `nodePtr` when descending
+//	key: a key in range of `nodePtr`, to find its ancestors again if tree grew
+//	nodePtr: pointer of the changed node, its write latch is held and released here
+//	level: level of `nodePtr`, leaves are level 0
+func (b *BLinkTree) refreshAncestors(stack []uint64, key Data, nodePtr uint64, level int) {
+	t := &b.tree
+	for ; ; level++ {
+		if len(stack) == 0 {
+			b.rootLatch.RLock()
+			isRoot := t.Root == nodePtr
+			b.rootLatch.RUnlock()
+			if isRoot {
+				b.latches.get(nodePtr).Unlock()
+				return
+			}
+			stack, _ = b.ancestors(key, level+1)
+		}
+		parentPtr, parent, pos := b.latchParent(stack[len(stack)-1], nodePtr)
+		stack = stack[:len(stack)-1]
+		t.dirty(parentPtr)
+		parent.Counts[pos] = t.Get(nodePtr).count()
+		t.setSummary(parent, pos)
+		b.latches.get(nodePtr).Unlock()
+		nodePtr = parentPtr
+	}
+}
+
+// Link a node split by `splitNode` into its parent, then split parents which are full, bottom-up:
+//
+//	stack: pointers of internal nodes visited in each level when descending to leaf
+//	key: the inserted key
+//	nodePtr: pointer of the node which was split, its write latch is held and released here
+//	splitNode: node with 1 separator, `nodePtr` and its new right sibling as children
+func (b *BLinkTree) insertSeparator(stack []uint64, key Data, nodePtr uint64, splitNode *BNode) {
+	t := &b.tree
+	for level := 1; ; level++ {
+		if len(stack) == 0 {
+			b.rootLatch.Lock()
+			if t.Root == nodePtr { // root was split
+				t.Root = t.New(splitNode)
+				b.height += 1
+				b.rootLatch.Unlock()
+				b.latches.get(nodePtr).Unlock()
+				return
+			}
+			b.rootLatch.Unlock()
+			// tree grew after descending, find ancestors again, they are above `nodePtr` so it is safe to latch them
+			stack, _ = b.ancestors(splitNode.Keys[0], level)
+		}
+
+		parentPtr, parent, pos := b.latchParent(stack[len(stack)-1], nodePtr)
+		stack = stack[:len(stack)-1]
+		b.latches.get(nodePtr).Unlock()
+
+		if parent.NumKeys < t.Order-1 {
+			t.dirty(parentPtr)
+			parent.insertSplitNode(pos, splitNode)
+			b.refreshAncestors(stack, key, parentPtr, level)
+			return
+		}
+		splitNode = t.mergeWithFullNodeAndSplit(parentPtr, pos, t.New(splitNode))
+		nodePtr = parentPtr
+	}
+}
+
+// Position of `childPtr` in children of an internal node, `NumKeys` + 1 if it is not a child
+func childPosition(node *BNode, childPtr uint64) uint8 {
+	var pos uint8
+	for pos <= node.NumKeys && node.Child[pos] != childPtr {
+		pos += 1
+	}
+	return pos
+}
+
+// Delete a `key` from its leaf, the leaf is not merged or rebalanced even if it becomes empty
+func (b *BLinkTree) Delete(key Data) bool {
+	stack, leafPtr, leaf := b.descend(key, true)
+	if leafPtr == 0 {
+		return false
+	}
+	for pos := uint8(0); pos < leaf.NumKeys; pos++ {
+		if !leaf.Keys[pos].eq(key) {
+			continue
+		}
+		b.tree.dirty(leafPtr)
+		copy(leaf.Keys[pos:], leaf.Keys[pos+1:leaf.NumKeys])
+		copy(leaf.Values[pos:], leaf.Values[pos+1:leaf.NumKeys])
+		leaf.NumKeys -= 1
+		leaf.Keys[leaf.NumKeys] = nil
+		leaf.Values[leaf.NumKeys] = nil
+		b.refreshAncestors(stack, key, leafPtr, 0)
+		return true
+	}
+	b.latches.get(leafPtr).Unlock()
+	return false
+}
@@ -0,0 +1,96 @@
+package bplustree
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBLinkTreeMoveRight(t *testing.T) {
+	tree := newSyncTree(3)
+	for key := uint16(1); key <= 20; key++ {
+		tree.Insert(createBigEndianData(key), createData(key))
+	}
+	b := NewBLinkTree(tree)
+
+	// a reader which starts at the first leaf moves right along the leaf chain
+	leafPtr := b.tree.Root
+	for !b.tree.Get(leafPtr).IsLeaf {
+		leafPtr = b.tree.Get(leafPtr).Child[0]
+	}
+	ptr, leaf := b.latchCovering(leafPtr, createBigEndianData(20), false)
+	b.latches.get(ptr).RUnlock()
+	assert.Equal(t, uint64(0), leaf.Next)
+	assert.Nil(t, leaf.HighKey)
+	assert.EqualValues(t, createBigEndianData(20), leaf.Keys[leaf.NumKeys-1])
+
+	for key := uint16(1); key <= 20; key++ {
+		val, found := b.Search(createBigEndianData(key))
+		assert.True(t, found)
+		assert.EqualValues(t, createData(key), val)
+	}
+}
+
+func TestBLinkTree(t *testing.T) {
+	for _, monoid := range []*Monoid{nil, CountMonoid()} {
+		for _, order := range []uint8{3, 4, 7} {
+			tree := newSyncTree(order)
+			tree.Monoid = monoid
+			testBLinkTree(t, NewBLinkTree(tree))
+		}
+	}
+}
+
+func testBLinkTree(t *testing.T, b *BLinkTree) {
+	_, found := b.Search(createBigEndianData(1))
+	assert.False(t, found)
+	assert.False(t, b.Delete(createBigEndianData(1)))
+
+	const workers = 32
+	const keysPerWorker = 100
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			// every worker owns keys `worker`, `worker` + workers, ...
+			owned := map[uint16]bool{}
+			for i := 0; i < keysPerWorker*4; i++ {
+				key := uint16(worker + workers*random.Intn(keysPerWorker))
+				data := createBigEndianData(key)
+				if random.Intn(3) == 0 {
+					val, found := b.Search(data)
+					assert.Equal(t, owned[key], found)
+					if found {
+						assert.EqualValues(t, val, createData(key))
+					}
+				} else if owned[key] && random.Intn(2) == 0 {
+					assert.True(t, b.Delete(data))
+					delete(owned, key)
+				} else if !owned[key] {
+					b.Insert(data, createData(key))
+					owned[key] = true
+				}
+			}
+			for key := range owned {
+				val, found := b.Search(createBigEndianData(key))
+				assert.True(t, found)
+				assert.EqualValues(t, val, createData(key))
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	// leaves are never merged after delete
+	for _, violation := range b.tree.Validate() {
+		assert.Equal(t, VIOLATION_UNDERFLOW, violation.Kind, violation.Error())
+	}
+	assertCountSummaries(t, b.tree, b.tree.Root)
+}
+
+func BenchmarkBLinkTreeInsert(b *testing.B) {
+	benchmarkWriters(b, func() concurrentWriter { return NewBLinkTree(newSyncTree(64)) })
+}
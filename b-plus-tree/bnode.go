@@ -2,11 +2,13 @@ package bplustree
 
 func newNode(order uint8) *BNode {
 	return &BNode{
-		Keys:   make([]Data, order-1),
-		Values: nil,
-		Child:  make([]uint64, order),
-		IsLeaf: false,
-		Next:   0,
+		Keys:      make([]Data, order-1),
+		Values:    nil,
+		Child:     make([]uint64, order),
+		Counts:    make([]uint64, order),
+		Summaries: make([]Data, order),
+		IsLeaf:    false,
+		Next:      0,
 	}
 }
 
@@ -21,12 +23,15 @@ func newLeaf(order uint8) *BNode {
 }
 
 type BNode struct {
-	Keys    []Data
-	Values  []Data
-	Child   []uint64 // pointers to child nodes
-	Next    uint64   // pointer to next leaf node, if this is leaf
-	NumKeys uint8    // total keys inside this node
-	IsLeaf  bool
+	Keys      []Data
+	Values    []Data
+	Child     []uint64 // pointers to child nodes
+	Counts    []uint64 // number of keys in sub-tree of each child, internal only
+	Summaries []Data   // summary of pairs in sub-tree of each child by `Monoid` of tree, internal only
+	Next      uint64   // pointer to right sibling node in the same level, the right link of B-link tree
+	HighKey   Data     // upper bound of keys in this node and its sub-tree, nil if unbounded or unknown
+	NumKeys   uint8    // total keys inside this node
+	IsLeaf    bool
 }
 
 // Insert a `key` to an internal node:
@@ -40,6 +45,12 @@ func (node *BNode) insertToInternalNode(key Data, insertPos uint8, left uint64,
 	for i := node.NumKeys; i > insertPos; i-- {
 		node.Keys[i] = node.Keys[i-1]
 		node.Child[i+1] = node.Child[i]
+		if node.Counts != nil {
+			node.Counts[i+1] = node.Counts[i]
+		}
+		if node.Summaries != nil {
+			node.Summaries[i+1] = node.Summaries[i]
+		}
 	}
 	// to insert `key` at `insertPos`
 	node.Keys[insertPos] = key
@@ -48,6 +59,28 @@ func (node *BNode) insertToInternalNode(key Data, insertPos uint8, left uint64,
 	node.Child[insertPos+1] = right
 }
 
+// Insert separator and children of `splitNode`, which is created by a split of child at `insertPos`, with their
+// key counts and summaries
+func (node *BNode) insertSplitNode(insertPos uint8, splitNode *BNode) {
+	node.insertToInternalNode(splitNode.Keys[0], insertPos, splitNode.Child[0], splitNode.Child[1])
+	node.Counts[insertPos] = splitNode.Counts[0]
+	node.Counts[insertPos+1] = splitNode.Counts[1]
+	node.Summaries[insertPos] = splitNode.Summaries[0]
+	node.Summaries[insertPos+1] = splitNode.Summaries[1]
+}
+
+// Number of keys in sub-tree of the node
+func (node *BNode) count() uint64 {
+	if node.IsLeaf {
+		return uint64(node.NumKeys)
+	}
+	var total uint64
+	for i := uint8(0); i <= node.NumKeys; i++ {
+		total += node.Counts[i]
+	}
+	return total
+}
+
 // Insert a `key`/`value` pair to a leaf node:
 //
 //	key:
@@ -12,6 +12,19 @@ type BTree struct {
 	Get func(uint64) *BNode // reference pointer to a node
 	New func(*BNode) uint64 // allocate node with new pointer
 	Del func(uint64)        // deallocate a node
+	// optional callback, called before a node is changed in place, so its old content can be kept
+	Dirty func(uint64)
+	// optional aggregate, its summary of every sub-tree is kept in internal nodes for `Aggregate`
+	Monoid *Monoid
+	// optional callback, every value of a leaf which is split or merged is replaced by its result
+	Fold func(Data) Data
+}
+
+// Notify `Dirty` callback that node at `ptr` is going to be changed
+func (t *BTree) dirty(ptr uint64) {
+	if t.Dirty != nil {
+		t.Dirty(ptr)
+	}
 }
 
 // ============================= SEARCH OPERATION ==================================
@@ -41,6 +54,76 @@ func (t BTree) Search(key Data) (Data, bool) {
 	return nil, false
 }
 
+// ============================= SCAN OPERATION ==================================
+
+// Call `fn` for every key / value pair with `start` <= key < `end` in ascending order, until `fn` returns false.
+// nil `start` or `end` means no bound
+func (t BTree) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	for it := t.Iterator(start, end); it.Next(); {
+		if !fn(it.Key(), it.Value()) {
+			return
+		}
+	}
+}
+
+// Iterator walks key / value pairs of a tree in ascending order of keys, the tree must not be changed meanwhile
+type Iterator struct {
+	tree    BTree
+	leafPtr uint64
+	leaf    *BNode
+	pos     int // position of current pair in `leaf`, -1 before the first pair of `leaf`
+	start   Data
+	end     Data
+}
+
+// Create an iterator over key / value pairs with `start` <= key < `end`, nil `start` or `end` means no bound.
+// Call `Next` before reading the first pair
+func (t BTree) Iterator(start Data, end Data) *Iterator {
+	cursorPtr := t.Root
+	cursor := t.Get(cursorPtr)
+	for cursor != nil && !cursor.IsLeaf {
+		// keys equal to a separator may stay in its left child, so go to the left most child which can contain `start`
+		var pos uint8
+		for start != nil && pos < cursor.NumKeys && cursor.Keys[pos].lt(start) {
+			pos += 1
+		}
+		cursorPtr = cursor.Child[pos]
+		cursor = t.Get(cursorPtr)
+	}
+	return &Iterator{tree: t, leafPtr: cursorPtr, leaf: cursor, pos: -1, start: start, end: end}
+}
+
+// Move to the next pair, returns false when there is no more pair
+func (it *Iterator) Next() bool {
+	for it.leaf != nil {
+		it.pos += 1
+		if it.pos >= int(it.leaf.NumKeys) {
+			it.leafPtr = it.leaf.Next
+			it.leaf = it.tree.Get(it.leafPtr)
+			it.pos = -1
+			continue
+		}
+		key := it.leaf.Keys[it.pos]
+		if it.start != nil && key.lt(it.start) {
+			continue
+		}
+		if it.end != nil && !key.lt(it.end) {
+			it.leaf = nil
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (it *Iterator) Key() Data {
+	return it.leaf.Keys[it.pos]
+}
+
+func (it *Iterator) Value() Data {
+	return it.leaf.Values[it.pos]
+}
+
 // ============================= INSERT OPERATION ==================================
 
 // Insert key / value pairs into tree:
@@ -73,26 +156,32 @@ func (t *BTree) recursiveInsert(cursor uint64, key Data, value Data) *BNode {
 	node := t.Get(cursor)
 	if node.IsLeaf {
 		if node.NumKeys < t.Order-1 {
+			t.dirty(cursor)
 			node.insertToLeafNode(key, value)
 			return nil
 		}
 		return t.splitFullLeafAndInsert(cursor, key, value)
 	}
 
+	// same routing as `Search`: `key` equal to a separator belongs to the right child
 	var i uint8
 	for i < node.NumKeys {
-		if key.gt(node.Keys[i]) {
+		if !key.lt(node.Keys[i]) {
 			i += 1
 		} else {
 			break
 		}
 	}
 	insertedNode := t.recursiveInsert(node.Child[i], key, value)
-	if insertedNode == nil {
+	if insertedNode == nil { // `key` is inserted into sub-tree of child `i`
+		t.dirty(cursor)
+		node.Counts[i] += 1
+		t.setSummary(node, i)
 		return nil
 	}
 	if node.NumKeys < t.Order-1 { // merge with current internal node
-		node.insertToInternalNode(insertedNode.Keys[0], i, insertedNode.Child[0], insertedNode.Child[1])
+		t.dirty(cursor)
+		node.insertSplitNode(i, insertedNode)
 		return nil
 	} else { // create a parent internal node for `insertedNode` and `node`
 		insertedPtr := t.New(insertedNode)
@@ -106,6 +195,7 @@ func (t *BTree) recursiveInsert(cursor uint64, key Data, value Data) *BNode {
 //
 //	*BNode: parent internal node
 func (t *BTree) splitFullLeafAndInsert(leafPtr uint64, key Data, value Data) *BNode {
+	t.dirty(leafPtr)
 	leafNode := t.Get(leafPtr)
 	// determine position to insert `key` into, to make sure ascending order
 	var insertPos uint8
@@ -122,6 +212,7 @@ func (t *BTree) splitFullLeafAndInsert(leafPtr uint64, key Data, value Data) *BN
 	tempValues[insertPos] = value
 	copy(tempValues[:insertPos], leafNode.Values[:insertPos])
 	copy(tempValues[insertPos+1:], leafNode.Values[insertPos:])
+	t.foldValues(tempValues)
 
 	// create and allocate new leaf as right child, so `leafNode` will be left children
 	rightNode := newLeaf(t.Order)
@@ -138,18 +229,27 @@ func (t *BTree) splitFullLeafAndInsert(leafPtr uint64, key Data, value Data) *BN
 		leafNode.Values[i] = nil
 	}
 
+	// promote a truncated separator instead of the whole smallest key of right child
+	separator := shortestSeparator(tempKeys[splitPos-1], tempKeys[splitPos])
+
 	if leafNode.Next != 0 {
 		rightNode.Next = leafNode.Next
 	}
 	leafNode.Next = rightPtr
+	rightNode.HighKey = leafNode.HighKey
+	leafNode.HighKey = separator
 	leafNode.NumKeys = splitPos
 	rightNode.NumKeys = t.Order - splitPos
 
 	parent := newNode(t.Order)
-	parent.Keys[0] = tempKeys[splitPos]
+	parent.Keys[0] = separator
 	parent.NumKeys = 1
 	parent.Child[0] = leafPtr
 	parent.Child[1] = rightPtr
+	parent.Counts[0] = uint64(leafNode.NumKeys)
+	parent.Counts[1] = uint64(rightNode.NumKeys)
+	t.setSummary(parent, 0)
+	t.setSummary(parent, 1)
 	return parent
 }
 
@@ -159,6 +259,8 @@ func (t *BTree) splitFullLeafAndInsert(leafPtr uint64, key Data, value Data) *BN
 //	*BNode: parent internal node after merge and split
 func (t *BTree) mergeWithFullNodeAndSplit(fullNodePtr uint64, insertPos uint8, insertedPtr uint64) *BNode {
 	// full node will be left, and inserted node will be right
+	t.dirty(fullNodePtr)
+	t.dirty(insertedPtr)
 	leftNode := t.Get(fullNodePtr)
 	rightNode := t.Get(insertedPtr)
 
@@ -175,33 +277,62 @@ func (t *BTree) mergeWithFullNodeAndSplit(fullNodePtr uint64, insertPos uint8, i
 	if insertPos+2 < t.Order+1 {
 		copy(tempChilds[insertPos+2:], leftNode.Child[insertPos+1:])
 	}
+	// `tempCounts` is a buffer to store key counts of `tempChilds`
+	tempCounts := make([]uint64, t.Order+1)
+	copy(tempCounts[:insertPos], leftNode.Counts[:insertPos])
+	tempCounts[insertPos] = rightNode.Counts[0]
+	tempCounts[insertPos+1] = rightNode.Counts[1]
+	if insertPos+2 < t.Order+1 {
+		copy(tempCounts[insertPos+2:], leftNode.Counts[insertPos+1:])
+	}
+	// `tempSummaries` is a buffer to store summaries of `tempChilds`
+	tempSummaries := make([]Data, t.Order+1)
+	copy(tempSummaries[:insertPos], leftNode.Summaries[:insertPos])
+	tempSummaries[insertPos] = rightNode.Summaries[0]
+	tempSummaries[insertPos+1] = rightNode.Summaries[1]
+	if insertPos+2 < t.Order+1 {
+		copy(tempSummaries[insertPos+2:], leftNode.Summaries[insertPos+1:])
+	}
 
 	// determine position to split `tempKeys` and `tempChilds`
 	splitPos := uint8(math.Floor(float64(t.Order) / 2.0))
 	// keys and childrens after `splitPos` will be copied to right
 	copy(rightNode.Keys, tempKeys[splitPos+1:])
 	copy(rightNode.Child, tempChilds[splitPos+1:])
+	copy(rightNode.Counts, tempCounts[splitPos+1:])
+	copy(rightNode.Summaries, tempSummaries[splitPos+1:])
 	// keys and children before `splitPos` will be copied to left
 	copy(leftNode.Keys, tempKeys[:splitPos])
 	copy(leftNode.Child, tempChilds[:splitPos+1])
+	copy(leftNode.Counts, tempCounts[:splitPos+1])
+	copy(leftNode.Summaries, tempSummaries[:splitPos+1])
 	for i := splitPos; i < t.Order; i++ { // reset current keys and childrens in left child
 		if i < t.Order-1 {
 			leftNode.Keys[i] = nil
 		}
 		if i > splitPos {
 			leftNode.Child[i] = 0
+			leftNode.Counts[i] = 0
+			leftNode.Summaries[i] = nil
 		}
 	}
 
 	rightNode.NumKeys = leftNode.NumKeys - splitPos
 	leftNode.NumKeys = splitPos
+	rightNode.Next = leftNode.Next
 	leftNode.Next = insertedPtr
+	rightNode.HighKey = leftNode.HighKey
+	leftNode.HighKey = tempKeys[splitPos]
 
 	parent := newNode(t.Order)
 	parent.Keys[0] = tempKeys[splitPos]
 	parent.NumKeys = 1
 	parent.Child[0] = fullNodePtr
 	parent.Child[1] = insertedPtr
+	parent.Counts[0] = leftNode.count()
+	parent.Counts[1] = rightNode.count()
+	t.setSummary(parent, 0)
+	t.setSummary(parent, 1)
 	return parent
 }
 
@@ -260,51 +391,86 @@ func (t *BTree) doDelete(cursorPointer uint64, key Data, ancestorsStack []parent
 		}
 	} else if cursor.IsLeaf && cmp == 0 {
 		// found a leaf contain `key`, delete `key` here
-		for j := pos; j < cursor.NumKeys-1; j++ {
-			cursor.Keys[j] = cursor.Keys[j+1]
-			cursor.Values[j] = cursor.Values[j+1]
-		}
-		cursor.Keys[cursor.NumKeys-1] = nil
-		cursor.Values[cursor.NumKeys-1] = nil
-		cursor.NumKeys -= 1
-
-		totalAncestor := len(ancestorsStack)
-		if pos == 0 && totalAncestor > 0 {
-			// special case: if delete smallest data in leaf, we need to replace every smallest data in parent stack
-			var nextSmallest Data
-			ancestorIndex := totalAncestor - 1 // initial with direct parent
-			ancestorInfo := ancestorsStack[ancestorIndex]
-			ancestorNode := t.Get(ancestorInfo.parentPtr)
-			childIndexInParentNode := ancestorInfo.childIndexInParentNode
-			if cursor.NumKeys == 0 { // delete `key` means delete whole cursor node
-				if childIndexInParentNode == ancestorNode.NumKeys {
-					// cursor is the last child -> we've just delete maximum value of `ancestorNode` -> delete it in ancestor by nil value
-					nextSmallest = nil
-				} else {
-					// new smallest is minimum value of next child in parent
-					nextSmallest = t.Get(ancestorNode.Child[childIndexInParentNode+1]).Keys[0]
-				}
-			} else { // cursor still have keys -> easy to assign new smallest
-				nextSmallest = cursor.Keys[0]
+		t.deleteFromLeaf(cursorPointer, pos, ancestorsStack)
+		return true
+	}
+	return false
+}
+
+// Delete key at `pos` of a leaf, then replace it in ancestors if it is used as separator, and rebuild tree:
+//
+//	cursorPointer: pointer of the leaf
+//	pos: position of key in leaf
+//	ancestorsStack: with higher index is closer to parent of `cursorPointer`, and index 0 is the root of a tree
+func (t *BTree) deleteFromLeaf(cursorPointer uint64, pos uint8, ancestorsStack []parentInfo) {
+	cursor := t.Get(cursorPointer)
+	key := cursor.Keys[pos]
+	t.adjustCounts(ancestorsStack, -1)
+	t.dirty(cursorPointer)
+	for j := pos; j < cursor.NumKeys-1; j++ {
+		cursor.Keys[j] = cursor.Keys[j+1]
+		cursor.Values[j] = cursor.Values[j+1]
+	}
+	cursor.Keys[cursor.NumKeys-1] = nil
+	cursor.Values[cursor.NumKeys-1] = nil
+	cursor.NumKeys -= 1
+
+	totalAncestor := len(ancestorsStack)
+	if pos == 0 && totalAncestor > 0 {
+		// special case: if delete smallest data in leaf, we need to replace every smallest data in parent stack
+		var nextSmallest Data
+		ancestorIndex := totalAncestor - 1 // initial with direct parent
+		ancestorInfo := ancestorsStack[ancestorIndex]
+		ancestorNode := t.Get(ancestorInfo.parentPtr)
+		childIndexInParentNode := ancestorInfo.childIndexInParentNode
+		if cursor.NumKeys == 0 { // delete `key` means delete whole cursor node
+			if childIndexInParentNode == ancestorNode.NumKeys {
+				// cursor is the last child -> we've just delete maximum value of `ancestorNode` -> delete it in ancestor by nil value
+				nextSmallest = nil
+			} else {
+				// new smallest is minimum value of next child in parent
+				nextSmallest = t.Get(ancestorNode.Child[childIndexInParentNode+1]).Keys[0]
+			}
+		} else { // cursor still have keys -> easy to assign new smallest
+			nextSmallest = cursor.Keys[0]
+		}
+		// update `nextSmallest` to ancestors. A separator equal to `key` is only replaced while the leaf is the left
+		// most one under it, then `nextSmallest` is the smallest remaining key on its right. Otherwise duplicates of
+		// `key` stay between the separator and the leaf, so the separator is kept. A separator which is not equal to
+		// `key`, e.g. a truncated one, is kept too, because it is still less than or equal to every remaining key on
+		// its right
+		leftMost := true // whether the leaf is the left most one under child of `ancestorNode`
+		for {
+			if leftMost && childIndexInParentNode > 0 && ancestorNode.Keys[childIndexInParentNode-1].eq(key) {
+				t.dirty(ancestorInfo.parentPtr)
+				ancestorNode.Keys[childIndexInParentNode-1] = nextSmallest
+				t.setHighKeyOfRightMost(ancestorNode.Child[childIndexInParentNode-1], nextSmallest)
 			}
-			// update `nextSmallest` to ancestors
-			for {
-				if childIndexInParentNode > 0 && ancestorNode.Keys[childIndexInParentNode-1].eq(key) {
-					ancestorNode.Keys[childIndexInParentNode-1] = nextSmallest
-				}
-				ancestorIndex -= 1
-				if ancestorIndex < 0 {
-					break
-				}
-				ancestorInfo = ancestorsStack[ancestorIndex] // update in grand, grand parents, and so on...
-				childIndexInParentNode = ancestorInfo.childIndexInParentNode
-				ancestorNode = t.Get(ancestorInfo.parentPtr)
+			leftMost = leftMost && childIndexInParentNode == 0
+			ancestorIndex -= 1
+			if ancestorIndex < 0 {
+				break
 			}
+			ancestorInfo = ancestorsStack[ancestorIndex] // update in grand, grand parents, and so on...
+			childIndexInParentNode = ancestorInfo.childIndexInParentNode
+			ancestorNode = t.Get(ancestorInfo.parentPtr)
 		}
-		t.repairAfterDelete(cursorPointer, ancestorsStack)
-		return true
 	}
-	return false
+	t.repairAfterDelete(cursorPointer, ancestorsStack)
+}
+
+// Add `delta` to key count of the path in every ancestor, after keys are inserted into or deleted from a leaf:
+//
+//	ancestorsStack: with higher index is closer to parent of the leaf, and index 0 is the root of a tree
+//	delta: number of inserted keys, negative for deleted keys
+func (t *BTree) adjustCounts(ancestorsStack []parentInfo, delta int64) {
+	if delta == 0 {
+		return
+	}
+	for _, ancestor := range ancestorsStack {
+		t.dirty(ancestor.parentPtr)
+		t.Get(ancestor.parentPtr).Counts[ancestor.childIndexInParentNode] += uint64(delta)
+	}
 }
 
 // repair sub-tree after delete in this
@@ -314,13 +480,18 @@ func (t *BTree) doDelete(cursorPointer uint64, key Data, ancestorsStack []parent
 func (t *BTree) repairAfterDelete(cursorPointer uint64, ancestorsStack []parentInfo) {
 	cursor := t.Get(cursorPointer)
 	if cursor.NumKeys >= t.MinKey {
+		// ancestors are not restructured, only their summaries are changed
+		t.refreshSummaries(ancestorsStack)
 		return
 	}
 	totalAncestor := len(ancestorsStack)
 	if totalAncestor == 0 {
 		if cursor.NumKeys == 0 {
 			if len(cursor.Child) > 0 {
+				// root has only 1 child left, which becomes new root
+				oldRoot := t.Root
 				t.Root = cursor.Child[0]
+				t.Del(oldRoot)
 			} else {
 				// just delete the last `key` of tree, so delete root
 				t.Del(t.Root)
@@ -336,19 +507,24 @@ func (t *BTree) repairAfterDelete(cursorPointer uint64, ancestorsStack []parentI
 			return
 		}
 		var leftIdx, rightIdx uint8
+		var left, right *BNode // only adjacent siblings can be used
 		if childIndexInParent > 0 {
 			leftIdx = childIndexInParent - 1
+			left = t.Get(parentNode.Child[leftIdx])
 		}
 		if childIndexInParent < parentNode.NumKeys {
 			rightIdx = childIndexInParent + 1
+			right = t.Get(parentNode.Child[rightIdx])
 		}
 
-		if l := t.Get(parentNode.Child[leftIdx]); l != nil && l.NumKeys > t.MinKey {
+		if left != nil && left.NumKeys > t.MinKey {
 			// steal from left
 			t.stealFromLeft(cursorPointer, parentPointer, childIndexInParent)
-		} else if r := t.Get(parentNode.Child[rightIdx]); r != nil && r.NumKeys > t.MinKey {
+			t.refreshSummaries(ancestorsStack[:totalAncestor-1])
+		} else if right != nil && right.NumKeys > t.MinKey {
 			// steal from right
 			t.stealFromRight(cursorPointer, parentPointer, childIndexInParent)
+			t.refreshSummaries(ancestorsStack[:totalAncestor-1])
 		} else if childIndexInParent == 0 {
 			// merge with right sibling
 			t.mergeRight(cursorPointer, parentNode.Child[rightIdx], parentPointer, childIndexInParent)
@@ -369,31 +545,50 @@ func (t *BTree) repairAfterDelete(cursorPointer uint64, ancestorsStack []parentI
 func (t *BTree) stealFromLeft(rightPtr uint64, parentPtr uint64, indexInParent uint8) {
 	right := t.Get(rightPtr)
 	parent := t.Get(parentPtr)
+	leftPtr := parent.Child[indexInParent-1]
+	left := t.Get(leftPtr)
+	t.dirty(leftPtr)
+	t.dirty(rightPtr)
+	t.dirty(parentPtr)
 	right.NumKeys += 1
 	for i := right.NumKeys - 1; i > 0; i-- {
 		right.Keys[i] = right.Keys[i-1]
+		if right.IsLeaf {
+			right.Values[i] = right.Values[i-1]
+		}
 	}
-	leftPtr := parent.Child[indexInParent-1]
-	left := t.Get(leftPtr)
 	if right.IsLeaf {
 		right.Keys[0] = left.Keys[left.NumKeys-1]
 		right.Values[0] = left.Values[left.NumKeys-1]
-		parent.Keys[indexInParent-1] = left.Keys[left.NumKeys-1]
+		parent.Keys[indexInParent-1] = shortestSeparator(left.Keys[left.NumKeys-2], left.Keys[left.NumKeys-1])
 	} else {
 		right.Keys[0] = parent.Keys[indexInParent-1]
 		parent.Keys[indexInParent-1] = left.Keys[left.NumKeys-1]
 	}
+	left.HighKey = parent.Keys[indexInParent-1]
+	moved := uint64(1) // number of keys moved from left to right
 	if !right.IsLeaf {
 		for i := right.NumKeys; i > 0; i-- {
 			right.Child[i] = right.Child[i-1]
+			right.Counts[i] = right.Counts[i-1]
+			right.Summaries[i] = right.Summaries[i-1]
 		}
+		moved = left.Counts[left.NumKeys]
 		right.Child[0] = left.Child[left.NumKeys]
+		right.Counts[0] = moved
+		right.Summaries[0] = left.Summaries[left.NumKeys]
 		left.Child[left.NumKeys] = 0
+		left.Counts[left.NumKeys] = 0
+		left.Summaries[left.NumKeys] = nil
 	} else {
 		left.Values[left.NumKeys-1] = nil
 	}
+	parent.Counts[indexInParent-1] -= moved
+	parent.Counts[indexInParent] += moved
 	left.Keys[left.NumKeys-1] = nil
 	left.NumKeys -= 1
+	t.setSummary(parent, indexInParent-1)
+	t.setSummary(parent, indexInParent)
 }
 
 // Args:
@@ -406,27 +601,39 @@ func (t *BTree) stealFromRight(leftPtr uint64, parentPtr uint64, indexInParent u
 	parent := t.Get(parentPtr)
 	rightPtr := parent.Child[indexInParent+1]
 	right := t.Get(rightPtr)
+	t.dirty(leftPtr)
+	t.dirty(rightPtr)
+	t.dirty(parentPtr)
 	left.NumKeys += 1
 
 	if left.IsLeaf {
 		left.Keys[left.NumKeys-1] = right.Keys[0]
 		left.Values[left.NumKeys-1] = right.Values[0]
-		parent.Keys[indexInParent] = right.Keys[1]
+		parent.Keys[indexInParent] = shortestSeparator(right.Keys[0], right.Keys[1])
 	} else {
 		left.Keys[left.NumKeys-1] = parent.Keys[indexInParent]
 		parent.Keys[indexInParent] = right.Keys[0]
 	}
+	left.HighKey = parent.Keys[indexInParent]
 
+	moved := uint64(1) // number of keys moved from right to left
 	if !left.IsLeaf {
+		moved = right.Counts[0]
 		left.Child[left.NumKeys] = right.Child[0]
+		left.Counts[left.NumKeys] = moved
+		left.Summaries[left.NumKeys] = right.Summaries[0]
 		for i := uint8(1); i < right.NumKeys+1; i++ {
 			right.Child[i-1] = right.Child[i]
+			right.Counts[i-1] = right.Counts[i]
+			right.Summaries[i-1] = right.Summaries[i]
 		}
 	}
+	parent.Counts[indexInParent] += moved
+	parent.Counts[indexInParent+1] -= moved
 	for i := uint8(1); i < right.NumKeys; i++ {
 		right.Keys[i-1] = right.Keys[i]
 		if right.IsLeaf {
-			right.Values[i-1] = right.Keys[i]
+			right.Values[i-1] = right.Values[i]
 		}
 	}
 	right.Keys[right.NumKeys-1] = nil
@@ -434,8 +641,12 @@ func (t *BTree) stealFromRight(leftPtr uint64, parentPtr uint64, indexInParent u
 		right.Values[right.NumKeys-1] = nil
 	} else {
 		right.Child[right.NumKeys] = 0
+		right.Counts[right.NumKeys] = 0
+		right.Summaries[right.NumKeys] = nil
 	}
 	right.NumKeys -= 1
+	t.setSummary(parent, indexInParent)
+	t.setSummary(parent, indexInParent+1)
 }
 
 // Merge 2 adjacency nodes, both has less than 1/2 keys so it can be merged. After merge, right node will be deleted:
@@ -448,11 +659,15 @@ func (t *BTree) mergeRight(leftPtr uint64, rightPtr uint64, parentPtr uint64, le
 	left := t.Get(leftPtr)
 	parent := t.Get(parentPtr)
 	right := t.Get(rightPtr)
+	t.dirty(leftPtr)
+	t.dirty(parentPtr)
 
 	// append keys, values, childrens to left node
 	if !left.IsLeaf {
 		left.Keys[left.NumKeys] = parent.Keys[leftIndexInParent]
 		left.Child[left.NumKeys+1] = right.Child[0]
+		left.Counts[left.NumKeys+1] = right.Counts[0]
+		left.Summaries[left.NumKeys+1] = right.Summaries[0]
 	}
 	for i := uint8(0); i < right.NumKeys; i++ {
 		insertIndex := left.NumKeys + i
@@ -461,6 +676,8 @@ func (t *BTree) mergeRight(leftPtr uint64, rightPtr uint64, parentPtr uint64, le
 		} else {
 			insertIndex += 1 // +1 here because: we steal 1 key from parent above and index need to be after that
 			left.Child[insertIndex+1] = right.Child[i+1]
+			left.Counts[insertIndex+1] = right.Counts[i+1]
+			left.Summaries[insertIndex+1] = right.Summaries[i+1]
 		}
 		left.Keys[insertIndex] = right.Keys[i]
 	}
@@ -468,15 +685,158 @@ func (t *BTree) mergeRight(leftPtr uint64, rightPtr uint64, parentPtr uint64, le
 		left.NumKeys += right.NumKeys + 1 // +1 here because: we steal 1 key from parent above
 	} else {
 		left.NumKeys += right.NumKeys
-		left.Next = right.Next
 	}
+	if left.IsLeaf {
+		t.foldValues(left.Values[:left.NumKeys])
+	}
+	left.Next = right.Next
+	left.HighKey = right.HighKey
 	// remove keys, childrens with index of right node (leftIndexInParent + 1) in parent node
+	parent.Counts[leftIndexInParent] += parent.Counts[leftIndexInParent+1]
 	for i := leftIndexInParent + 1; i < parent.NumKeys; i++ {
 		parent.Child[i] = parent.Child[i+1]
+		parent.Counts[i] = parent.Counts[i+1]
+		parent.Summaries[i] = parent.Summaries[i+1]
 		parent.Keys[i-1] = parent.Keys[i]
 	}
 	parent.Keys[parent.NumKeys-1] = nil
 	parent.Child[parent.NumKeys] = 0
+	parent.Counts[parent.NumKeys] = 0
+	parent.Summaries[parent.NumKeys] = nil
 	parent.NumKeys -= 1
+	t.setSummary(parent, leftIndexInParent)
 	t.Del(rightPtr)
 }
+
+// Replace `values` of a leaf being rewritten by results of `Fold`
+func (t *BTree) foldValues(values []Data) {
+	if t.Fold == nil {
+		return
+	}
+	for i := range values {
+		values[i] = t.Fold(values[i])
+	}
+}
+
+// Set `HighKey` of a node and of every right most node in its sub-tree, after its upper bound is changed
+func (t *BTree) setHighKeyOfRightMost(nodePtr uint64, highKey Data) {
+	for node := t.Get(nodePtr); node != nil; node = t.Get(nodePtr) {
+		t.dirty(nodePtr)
+		node.HighKey = highKey
+		if node.IsLeaf {
+			return
+		}
+		nodePtr = node.Child[node.NumKeys]
+	}
+}
+
+// ============================= UPDATE OPERATION ==================================
+
+// Read-modify-write `key` in a single descent: `fn` gets the current value of `key`, and whether it exists.
+// It returns the new value, and whether `key` should exist after, so returning false deletes it
+func (t *BTree) Update(key Data, fn func(old Data, exists bool) (Data, bool)) {
+	leafPtr := t.Root
+	leaf := t.Get(leafPtr)
+	if leaf == nil {
+		if value, keep := fn(nil, false); keep {
+			t.Insert(key, value)
+		}
+		return
+	}
+	ancestorsStack := []parentInfo{}
+	for !leaf.IsLeaf {
+		// same routing as `Search`: `key` equal to a separator belongs to the right child
+		var pos uint8
+		for pos < leaf.NumKeys && !key.lt(leaf.Keys[pos]) {
+			pos += 1
+		}
+		ancestorsStack = append(ancestorsStack, parentInfo{parentPtr: leafPtr, childIndexInParentNode: pos})
+		leafPtr = leaf.Child[pos]
+		leaf = t.Get(leafPtr)
+	}
+
+	var pos uint8
+	for pos < leaf.NumKeys && leaf.Keys[pos].lt(key) {
+		pos += 1
+	}
+	exists := pos < leaf.NumKeys && leaf.Keys[pos].eq(key)
+	var old Data
+	if exists {
+		old = leaf.Values[pos]
+	}
+	value, keep := fn(old, exists)
+	switch {
+	case keep && exists:
+		t.dirty(leafPtr)
+		leaf.Values[pos] = value
+		t.refreshSummaries(ancestorsStack)
+	case keep && leaf.NumKeys < t.Order-1:
+		t.dirty(leafPtr)
+		leaf.insertToLeafNode(key, value)
+		t.adjustCounts(ancestorsStack, 1)
+		t.refreshSummaries(ancestorsStack)
+	case keep:
+		t.insertIntoAncestors(t.splitFullLeafAndInsert(leafPtr, key, value), ancestorsStack)
+	case exists:
+		t.deleteFromLeaf(leafPtr, pos, ancestorsStack)
+	}
+}
+
+// Link `splitNode`, which has 1 separator and 2 children, into ancestors bottom-up, and split ancestors which are full:
+//
+//	splitNode: node created by a split, its left child is a child of the last ancestor
+//	ancestorsStack: with higher index is closer to parent of the split node, and index 0 is the root of a tree
+func (t *BTree) insertIntoAncestors(splitNode *BNode, ancestorsStack []parentInfo) {
+	for i := len(ancestorsStack) - 1; i >= 0; i-- {
+		parentPtr, pos := ancestorsStack[i].parentPtr, ancestorsStack[i].childIndexInParentNode
+		parent := t.Get(parentPtr)
+		if parent.NumKeys < t.Order-1 {
+			t.dirty(parentPtr)
+			parent.insertSplitNode(pos, splitNode)
+			t.adjustCounts(ancestorsStack[:i], 1)
+			t.refreshSummaries(ancestorsStack[:i])
+			return
+		}
+		splitNode = t.mergeWithFullNodeAndSplit(parentPtr, pos, t.New(splitNode))
+	}
+	t.Root = t.New(splitNode)
+}
+
+// Set `key` to `newValue` if its current value equals `expected`, nil `expected` means `key` must not exist.
+// Returns:
+//
+//	bool: whether value is swapped
+func (t *BTree) CompareAndSwap(key Data, expected Data, newValue Data) bool {
+	swapped := false
+	t.Update(key, func(old Data, exists bool) (Data, bool) {
+		if matchExpected(old, exists, expected) {
+			swapped = true
+			return newValue, true
+		}
+		return old, exists
+	})
+	return swapped
+}
+
+// Delete `key` if its current value equals `expected`.
+// Returns:
+//
+//	bool: whether `key` is deleted
+func (t *BTree) DeleteIfEquals(key Data, expected Data) bool {
+	deleted := false
+	t.Update(key, func(old Data, exists bool) (Data, bool) {
+		if exists && old.eq(expected) {
+			deleted = true
+			return nil, false
+		}
+		return old, exists
+	})
+	return deleted
+}
+
+func matchExpected(old Data, exists bool, expected Data) bool {
+	if expected == nil {
+		return !exists
+	}
+	return exists && old.eq(expected)
+}
@@ -1,7 +1,9 @@
 package bplustree
 
 import (
+	"bytes"
 	"encoding/binary"
+	"math/rand"
 	"testing"
 	"unsafe"
 
@@ -9,13 +11,15 @@ import (
 )
 
 type C struct {
+	t     *testing.T
 	tree  BTree
 	pages map[uint64]*BNode
 }
 
-func newC(order uint8) *C {
+func newC(t *testing.T, order uint8) *C {
 	pages := map[uint64]*BNode{}
 	return &C{
+		t: t,
 		tree: BTree{
 			Root:   0,
 			Order:  order,
@@ -42,10 +46,13 @@ func newC(order uint8) *C {
 
 func (c *C) add(key []byte, val []byte) {
 	c.tree.Insert(key, val)
+	assert.Empty(c.t, c.tree.Validate())
 }
 
-func (c *C) del(key []byte) {
-	c.tree.Delete(key)
+func (c *C) del(key []byte) bool {
+	deleted := c.tree.Delete(key)
+	assert.Empty(c.t, c.tree.Validate())
+	return deleted
 }
 
 // func (c *C) PrintNode(nodePointer uint64) {
@@ -117,7 +124,12 @@ func assertNodeKeys(t *testing.T, c *C, expectedKeys []int) {
 	for {
 		cursor := nodeQueue[0]
 		for idx := uint8(0); idx < cursor.NumKeys; idx++ {
-			assert.EqualValues(t, cursor.Keys[idx], createData(uint16(expectedKeys[expectedIdx])))
+			if cursor.IsLeaf {
+				assert.EqualValues(t, cursor.Keys[idx], createData(uint16(expectedKeys[expectedIdx])))
+			} else {
+				// separators in internal nodes may be truncated
+				assert.True(t, bytes.HasPrefix(createData(uint16(expectedKeys[expectedIdx])), cursor.Keys[idx]))
+			}
 			expectedIdx += 1
 		}
 		if cursor.Child != nil {
@@ -154,7 +166,7 @@ func assertLeafs(t *testing.T, c *C, expectedLeafs [][2]int) {
 }
 
 func TestCase1(t *testing.T) {
-	c := newC(3)
+	c := newC(t, 3)
 	insertDatas := [][2]int{
 		{5, 5}, {15, 15}, {25, 25}, {35, 35}, {45, 45}, {20, 20}, {30, 30}, {55, 55}, {40, 40},
 	}
@@ -176,8 +188,9 @@ func TestCase1(t *testing.T) {
 	for _, deleteData := range deleteDatas {
 		c.del(createData(uint16(deleteData)))
 	}
+	// truncated separator 25 is still valid after deleting key 25, so it is kept
 	assertNodeKeys(t, c, []int{
-		20, 30, 15, 20, 30,
+		20, 25, 15, 20, 30,
 	})
 	assertLeafs(t, c, [][2]int{
 		{15, 15}, {20, 20}, {30, 30},
@@ -185,7 +198,7 @@ func TestCase1(t *testing.T) {
 }
 
 func TestCase2(t *testing.T) {
-	c := newC(4)
+	c := newC(t, 4)
 	insertDatas := [][2]int{
 		{20, 20}, {15, 15}, {10, 10}, {15, 151}, {25, 25}, {28, 28}, {18, 18}, {21, 21}, {20, 201}, {28, 281}, {20, 202},
 	}
@@ -218,7 +231,7 @@ func TestCase2(t *testing.T) {
 
 func TestCase3(t *testing.T) {
 
-	c := newC(4)
+	c := newC(t, 4)
 
 	insertDatas := [][2]int{
 		{20, 3456}, {15, 45}, {10, 734},
@@ -251,7 +264,7 @@ func TestCase3(t *testing.T) {
 }
 
 func TestSearch(t *testing.T) {
-	c := newC(4)
+	c := newC(t, 4)
 	val, found := c.tree.Search(createData(uint16(15)))
 	assert.False(t, found)
 	assert.Nil(t, val)
@@ -285,3 +298,239 @@ func TestSearch(t *testing.T) {
 	assert.False(t, found)
 	assert.Nil(t, val)
 }
+
+func TestTruncatedSeparators(t *testing.T) {
+	c := newC(t, 4)
+	keys := []string{
+		"tenant/2026-10-17/alpha", "tenant/2026-10-17/beta", "tenant/2026-10-17/gamma",
+		"tenant/2026-10-18/alpha", "tenant/2026-10-18/beta", "tenant/2026-10-19/alpha",
+		"tenant/2026-10-19/delta", "tenant/2026-10-20/alpha", "tenant/2026-10-21/alpha",
+	}
+	for _, key := range keys {
+		c.add(Data(key), Data(key))
+	}
+	for _, key := range keys {
+		val, found := c.tree.Search(Data(key))
+		assert.True(t, found)
+		assert.EqualValues(t, val, Data(key))
+	}
+
+	// every separator in internal nodes is shorter than full keys
+	nodes := []uint64{c.tree.Root}
+	for len(nodes) > 0 {
+		node := c.tree.Get(nodes[0])
+		nodes = nodes[1:]
+		if node.IsLeaf {
+			continue
+		}
+		for i := uint8(0); i < node.NumKeys; i++ {
+			assert.Less(t, len(node.Keys[i]), len(keys[0]))
+		}
+		nodes = append(nodes, node.Child[:node.NumKeys+1]...)
+	}
+
+	// a key equal to a truncated separator is routed the same way by insert, search and delete
+	separator := c.tree.Get(c.tree.Root).Keys[0]
+	c.add(separator, Data("separator"))
+	val, found := c.tree.Search(separator)
+	assert.True(t, found)
+	assert.EqualValues(t, val, Data("separator"))
+	assert.True(t, c.del(separator))
+	_, found = c.tree.Search(separator)
+	assert.False(t, found)
+
+	// delete smallest keys of leaves, which are right next to separators
+	for _, key := range keys[:6] {
+		assert.True(t, c.del(Data(key)))
+		_, found = c.tree.Search(Data(key))
+		assert.False(t, found)
+	}
+	for _, key := range keys[6:] {
+		val, found := c.tree.Search(Data(key))
+		assert.True(t, found)
+		assert.EqualValues(t, val, Data(key))
+	}
+}
+
+// Deletes in random order steal from and merge with every kind of sibling, values must move with their keys
+// and every page is freed when the tree is empty
+func TestDeleteRebalance(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		for key := uint16(0); key < 300; key++ {
+			data := make(Data, 2)
+			binary.BigEndian.PutUint16(data, key)
+			c.add(data, append(Data{'v'}, data...))
+		}
+		random := rand.New(rand.NewSource(int64(order)))
+		deleted := map[uint16]bool{}
+		for _, i := range random.Perm(300) {
+			data := make(Data, 2)
+			binary.BigEndian.PutUint16(data, uint16(i))
+			assert.True(t, c.tree.Delete(data))
+			deleted[uint16(i)] = true
+			if len(deleted)%50 != 0 {
+				continue
+			}
+			for key := uint16(0); key < 300; key++ {
+				binary.BigEndian.PutUint16(data, key)
+				val, found := c.tree.Search(data)
+				assert.Equal(t, !deleted[key], found)
+				if found {
+					assert.EqualValues(t, append(Data{'v'}, data...), val)
+				}
+			}
+		}
+		assert.Empty(t, c.pages)
+	}
+}
+
+func TestRandomInsertDelete(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		random := rand.New(rand.NewSource(int64(order)))
+		expected := map[uint16]bool{}
+		for i := 0; i < 2000; i++ {
+			key := uint16(random.Intn(300))
+			data := make(Data, 2)
+			binary.BigEndian.PutUint16(data, key)
+			if expected[key] {
+				assert.True(t, c.del(data))
+				delete(expected, key)
+			} else {
+				c.add(data, append(Data{'v'}, data...))
+				expected[key] = true
+			}
+		}
+		for key := uint16(0); key < 300; key++ {
+			data := make(Data, 2)
+			binary.BigEndian.PutUint16(data, key)
+			val, found := c.tree.Search(data)
+			assert.Equal(t, expected[key], found)
+			if found {
+				assert.EqualValues(t, val, append(Data{'v'}, data...))
+			}
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		random := rand.New(rand.NewSource(int64(order)))
+		expected := map[uint16]uint16{}
+		for i := 0; i < 2000; i++ {
+			key := uint16(random.Intn(300))
+			// add 1 to counter of `key`, delete it when it reaches 3
+			c.tree.Update(createBigEndianData(key), func(old Data, exists bool) (Data, bool) {
+				count, ok := expected[key]
+				assert.Equal(t, ok, exists)
+				if exists {
+					assert.EqualValues(t, createData(count), old)
+				}
+				if count == 2 {
+					delete(expected, key)
+					return nil, false
+				}
+				expected[key] = count + 1
+				return createData(count + 1), true
+			})
+			assert.Empty(t, c.tree.Validate())
+		}
+		for key := uint16(0); key < 300; key++ {
+			val, found := c.tree.Search(createBigEndianData(key))
+			count, ok := expected[key]
+			assert.Equal(t, ok, found)
+			if found {
+				assert.EqualValues(t, createData(count), val)
+			}
+		}
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	c := newC(t, 3)
+	key := createBigEndianData(1)
+	assert.False(t, c.tree.CompareAndSwap(key, createData(1), createData(2)))
+	assert.True(t, c.tree.CompareAndSwap(key, nil, createData(1)))
+	assert.False(t, c.tree.CompareAndSwap(key, nil, createData(2)))
+	assert.False(t, c.tree.CompareAndSwap(key, createData(2), createData(3)))
+	assert.True(t, c.tree.CompareAndSwap(key, createData(1), createData(2)))
+	val, _ := c.tree.Search(key)
+	assert.EqualValues(t, createData(2), val)
+
+	assert.False(t, c.tree.DeleteIfEquals(key, createData(1)))
+	assert.False(t, c.tree.DeleteIfEquals(createBigEndianData(2), createData(2)))
+	assert.True(t, c.tree.DeleteIfEquals(key, createData(2)))
+	_, found := c.tree.Search(key)
+	assert.False(t, found)
+
+	// a value never changes unless it is swapped
+	missing := false
+	c.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		missing = !exists
+		return old, exists
+	})
+	assert.True(t, missing)
+	assert.Equal(t, uint64(0), c.tree.Root)
+}
+
+func TestScan(t *testing.T) {
+	c := newC(t, 4)
+	scanned := [][2]int{}
+	scan := func(key Data, value Data) bool {
+		scanned = append(scanned, [2]int{int(binary.BigEndian.Uint16(key)), int(binary.LittleEndian.Uint16(value))})
+		return true
+	}
+	c.tree.Scan(nil, nil, scan)
+	assert.Empty(t, scanned)
+
+	for _, key := range []uint16{30, 10, 50, 20, 40, 60, 20, 70} {
+		c.add(createBigEndianData(key), createData(key))
+	}
+
+	c.tree.Scan(nil, nil, scan)
+	assert.Equal(t, scanned, [][2]int{{10, 10}, {20, 20}, {20, 20}, {30, 30}, {40, 40}, {50, 50}, {60, 60}, {70, 70}})
+
+	scanned = nil
+	c.tree.Scan(createBigEndianData(20), createBigEndianData(50), scan)
+	assert.Equal(t, scanned, [][2]int{{20, 20}, {20, 20}, {30, 30}, {40, 40}})
+
+	scanned = nil
+	c.tree.Scan(createBigEndianData(45), nil, scan)
+	assert.Equal(t, scanned, [][2]int{{50, 50}, {60, 60}, {70, 70}})
+
+	scanned = nil
+	c.tree.Scan(nil, createBigEndianData(20), scan)
+	assert.Equal(t, scanned, [][2]int{{10, 10}})
+
+	// stop early
+	scanned = nil
+	c.tree.Scan(nil, nil, func(key Data, value Data) bool {
+		scan(key, value)
+		return len(scanned) < 3
+	})
+	assert.Len(t, scanned, 3)
+}
+
+// A separator equal to a deleted key is only replaced when the leaf is the left most one under it, duplicates of
+// the key left of the leaf must stay on the right of a replaced separator
+func TestDeleteDuplicatedSeparator(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		for i := uint16(0); i < 20; i++ {
+			c.add(createBigEndianData(i), createData(i))
+		}
+		duplicated := createBigEndianData(10)
+		for i := 0; i < 30; i++ {
+			c.add(duplicated, createData(uint16(i)))
+		}
+		for i := 0; i < 31; i++ {
+			assert.True(t, c.del(duplicated))
+			_, found := c.tree.Search(duplicated)
+			assert.Equal(t, i < 30, found)
+		}
+		assert.False(t, c.del(duplicated))
+		assert.Equal(t, uint64(19), c.tree.Len())
+	}
+}
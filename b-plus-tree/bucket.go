@@ -0,0 +1,435 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+*
+Every value of a bucket's tree starts with a kind byte:
+
+| BUCKET_VALUE  | value
+| BUCKET_NESTED | root 8B
+| BUCKET_INLINE | klen 2B | vlen 2B | key | value | ... | klen 2B | vlen 2B | key | value
+| BUCKET_ROOT
+
+BUCKET_NESTED: a sub-bucket with its own tree, which shares pages and order of the tree of root bucket
+BUCKET_INLINE: pairs of a small sub-bucket in ascending order of keys, it is stored in the value instead of a page.
+A sub-bucket is inline until it has more than BUCKET_MAX_INLINE_SIZE bytes, or it has a sub-bucket
+BUCKET_ROOT: value of the empty key in tree of root bucket, it marks a tree whose every value is written through
+buckets. A tree is marked by the first write of its root bucket, which fails if the tree has pairs already, so a
+value written in another way is never read as a sub-bucket
+*
+*/
+
+const (
+	BUCKET_VALUE           byte = 0
+	BUCKET_NESTED          byte = 1
+	BUCKET_INLINE          byte = 2
+	BUCKET_ROOT            byte = 3
+	BUCKET_MAX_INLINE_SIZE      = 256
+)
+
+type bucketPair struct {
+	key   Data
+	value Data
+}
+
+// Bucket is a keyspace which can contain sub-buckets. The root bucket is a `BTree`, a sub-bucket is stored
+// as a special value of its parent. A handle of a sub-bucket is valid until its parent is changed other than
+// through the handle, so a transaction drops handles on `RollbackTo`
+type Bucket struct {
+	tree     *BTree       // tree of a bucket which is not inline
+	root     uint64       // root stored in value of parent, to know when it has to be written again
+	isInline bool         // pairs are in `inline` instead of `tree`
+	inline   []bucketPair // pairs of an inline bucket, in ascending order of keys
+	parent   *Bucket      // nil for root bucket
+	name     Data         // key in parent
+	children map[string]*Bucket
+	marked   bool // tree of root bucket is known to have the marker pair
+}
+
+// Root bucket stored in `tree`. Its first write marks an empty tree with a pair of the empty key, and fails if the
+// tree has pairs which are not written through buckets. Sub-buckets are only read from a marked tree.
+// Dropping the tree does not free pages of its sub-buckets, so delete them with `DeleteBucket` first
+func OpenBucket(tree *BTree) *Bucket {
+	return &Bucket{tree: tree, root: tree.Root, children: map[string]*Bucket{}}
+}
+
+// Whether tree of root bucket has the marker pair
+func (b *Bucket) isMarked() bool {
+	if !b.marked {
+		value, found := b.tree.Search(Data{})
+		b.marked = found && isBucketMarker(Data{}, value)
+	}
+	return b.marked
+}
+
+// Mark tree of root bucket before its first write, it fails if the tree has other pairs
+func (b *Bucket) mark() error {
+	if b.isMarked() {
+		return nil
+	}
+	if b.tree.Cursor(nil, nil).Next() {
+		return fmt.Errorf("tree has pairs which are not written through buckets")
+	}
+	b.tree.Insert(Data{}, Data{BUCKET_ROOT})
+	b.marked = true
+	return nil
+}
+
+// The empty key of root bucket is its marker
+func (b *Bucket) keyCheck(key Data) error {
+	if b.parent == nil && len(key) == 0 {
+		return fmt.Errorf("empty key is reserved in root bucket")
+	}
+	return nil
+}
+
+func encodeInlineBucket(pairs []bucketPair) Data {
+	value := Data{BUCKET_INLINE}
+	for _, pair := range pairs {
+		value = binary.LittleEndian.AppendUint16(value, uint16(len(pair.key)))
+		value = binary.LittleEndian.AppendUint16(value, uint16(len(pair.value)))
+		value = append(append(value, pair.key...), pair.value...)
+	}
+	return value
+}
+
+func decodeInlineBucket(value Data) ([]bucketPair, error) {
+	pairs := []bucketPair{}
+	for offset := 1; offset < len(value); {
+		if offset+4 > len(value) {
+			return nil, fmt.Errorf("inline bucket is truncated at %d", offset)
+		}
+		klen := int(binary.LittleEndian.Uint16(value[offset:]))
+		vlen := int(binary.LittleEndian.Uint16(value[offset+2:]))
+		offset += 4
+		if offset+klen+vlen > len(value) {
+			return nil, fmt.Errorf("inline bucket is truncated at %d", offset)
+		}
+		pairs = append(pairs, bucketPair{key: value[offset : offset+klen], value: value[offset+klen : offset+klen+vlen]})
+		offset += klen + vlen
+	}
+	return pairs, nil
+}
+
+func isBucketMarker(key Data, value Data) bool {
+	return len(key) == 0 && len(value) == 1 && value[0] == BUCKET_ROOT
+}
+
+func isNestedBucket(value Data) bool {
+	return len(value) == 1+8 && value[0] == BUCKET_NESTED
+}
+
+func encodeNestedBucket(root uint64) Data {
+	return binary.LittleEndian.AppendUint64(Data{BUCKET_NESTED}, root)
+}
+
+// Tree of a sub-bucket, with callbacks and order of tree of this bucket
+func (b *Bucket) newTree(root uint64) *BTree {
+	return &BTree{
+		Root:   root,
+		Order:  b.tree.Order,
+		MinKey: b.tree.MinKey,
+		Get:    b.tree.Get,
+		New:    b.tree.New,
+		Del:    b.tree.Del,
+		Dirty:  b.tree.Dirty,
+	}
+}
+
+// A tree without `New` callback can not be changed, as tree of a read-only transaction
+func (b *Bucket) writeCheck() error {
+	if b.tree.New == nil {
+		return ErrTxReadOnly
+	}
+	if b.parent == nil {
+		return b.mark()
+	}
+	return nil
+}
+
+// Position of `key` in pairs of an inline bucket, and whether it exists
+func (b *Bucket) inlinePos(key Data) (int, bool) {
+	pos := 0
+	for pos < len(b.inline) && b.inline[pos].key.lt(key) {
+		pos += 1
+	}
+	return pos, pos < len(b.inline) && b.inline[pos].key.eq(key)
+}
+
+// Write value of this bucket into its parent if it is changed, then parents of the parent, and so on
+func (b *Bucket) writeBack() {
+	if b.parent == nil {
+		return
+	}
+	if b.isInline {
+		b.store(encodeInlineBucket(b.inline))
+	} else if b.tree.Root != b.root {
+		b.store(encodeNestedBucket(b.tree.Root))
+	}
+}
+
+// Replace value of this bucket in its parent
+func (b *Bucket) store(value Data) {
+	b.root = b.tree.Root
+	b.parent.tree.Update(b.name, func(old Data, exists bool) (Data, bool) { return value, true })
+	b.parent.writeBack()
+}
+
+// Move pairs of an inline bucket into a tree of its own
+func (b *Bucket) spill() {
+	if !b.isInline {
+		return
+	}
+	for _, pair := range b.inline {
+		b.tree.Insert(pair.key, append(Data{BUCKET_VALUE}, pair.value...))
+	}
+	b.isInline = false
+	b.inline = nil
+	if b.parent != nil {
+		// root may still be 0 if there is no pair, so it is stored even if it is not changed
+		b.store(encodeNestedBucket(b.tree.Root))
+	}
+}
+
+func (b *Bucket) Get(key Data) (Data, bool) {
+	if b.isInline {
+		pos, found := b.inlinePos(key)
+		if !found {
+			return nil, false
+		}
+		return b.inline[pos].value, true
+	}
+	stored, found := b.tree.Search(key)
+	if !found || len(stored) == 0 || stored[0] != BUCKET_VALUE {
+		return nil, false
+	}
+	return stored[1:], true
+}
+
+// Set `value` of `key`, it fails if `key` is a sub-bucket
+func (b *Bucket) Put(key Data, value Data) error {
+	if err := b.writeCheck(); err != nil {
+		return err
+	}
+	if len(key) > BTREE_MAX_KEY_SIZE || len(value)+1 > BTREE_MAX_VAL_SIZE {
+		return fmt.Errorf("key has bytes = %d and value has bytes = %d larger than maximum %d and %d",
+			len(key), len(value), BTREE_MAX_KEY_SIZE, BTREE_MAX_VAL_SIZE-1)
+	}
+	if err := b.keyCheck(key); err != nil {
+		return err
+	}
+	if b.isInline {
+		// pairs are kept until the bucket is written, so they must not share buffers of the caller
+		pos, found := b.inlinePos(key)
+		if found {
+			b.inline[pos].value = append(Data{}, value...)
+		} else {
+			pair := bucketPair{key: append(Data{}, key...), value: append(Data{}, value...)}
+			b.inline = append(b.inline[:pos], append([]bucketPair{pair}, b.inline[pos:]...)...)
+		}
+		if len(encodeInlineBucket(b.inline)) > BUCKET_MAX_INLINE_SIZE {
+			b.spill()
+		}
+		b.writeBack()
+		return nil
+	}
+	var err error
+	b.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		if exists && (len(old) == 0 || old[0] != BUCKET_VALUE) {
+			err = fmt.Errorf("key %q is a bucket", key)
+			return old, true
+		}
+		return append(Data{BUCKET_VALUE}, value...), true
+	})
+	b.writeBack()
+	return err
+}
+
+// Delete value of `key`, it fails if `key` is a sub-bucket
+func (b *Bucket) Delete(key Data) (bool, error) {
+	if err := b.writeCheck(); err != nil {
+		return false, err
+	}
+	if err := b.keyCheck(key); err != nil {
+		return false, err
+	}
+	if b.isInline {
+		pos, found := b.inlinePos(key)
+		if found {
+			b.inline = append(b.inline[:pos], b.inline[pos+1:]...)
+			b.writeBack()
+		}
+		return found, nil
+	}
+	if _, isBucket := b.children[string(key)]; isBucket || b.Bucket(key) != nil {
+		return false, fmt.Errorf("key %q is a bucket", key)
+	}
+	deleted := b.tree.Delete(key)
+	b.writeBack()
+	return deleted, nil
+}
+
+// Sub-bucket of `name`, nil if it does not exist or `name` is a value
+func (b *Bucket) Bucket(name Data) *Bucket {
+	if child, ok := b.children[string(name)]; ok {
+		return child
+	}
+	if b.isInline || b.parent == nil && !b.isMarked() {
+		return nil
+	}
+	stored, found := b.tree.Search(name)
+	if !found || len(stored) == 0 {
+		return nil
+	}
+	child := &Bucket{parent: b, name: name, children: map[string]*Bucket{}}
+	switch stored[0] {
+	case BUCKET_NESTED:
+		if len(stored) != 1+8 {
+			return nil
+		}
+		child.root = binary.LittleEndian.Uint64(stored[1:])
+		child.tree = b.newTree(child.root)
+	case BUCKET_INLINE:
+		pairs, err := decodeInlineBucket(stored)
+		if err != nil {
+			return nil
+		}
+		child.tree = b.newTree(0)
+		child.isInline = true
+		child.inline = pairs
+	default:
+		return nil
+	}
+	b.children[string(name)] = child
+	return child
+}
+
+// Create an empty sub-bucket of `name`, it fails if `name` exists
+func (b *Bucket) CreateBucket(name Data) (*Bucket, error) {
+	if err := b.writeCheck(); err != nil {
+		return nil, err
+	}
+	if len(name) > BTREE_MAX_KEY_SIZE {
+		return nil, fmt.Errorf("name has bytes = %d larger than maximum %d", len(name), BTREE_MAX_KEY_SIZE)
+	}
+	if err := b.keyCheck(name); err != nil {
+		return nil, err
+	}
+	// an inline bucket has no sub-bucket
+	b.spill()
+	if _, found := b.tree.Search(name); found {
+		return nil, fmt.Errorf("key %q already exists", name)
+	}
+	child := &Bucket{tree: b.newTree(0), isInline: true, parent: b, name: name, children: map[string]*Bucket{}}
+	b.tree.Insert(name, encodeInlineBucket(nil))
+	b.children[string(name)] = child
+	b.writeBack()
+	return child, nil
+}
+
+func (b *Bucket) CreateBucketIfNotExists(name Data) (*Bucket, error) {
+	if child := b.Bucket(name); child != nil {
+		return child, nil
+	}
+	return b.CreateBucket(name)
+}
+
+// Delete sub-bucket of `name` with every pair and sub-bucket in it, its pages are returned by `Del` callback
+func (b *Bucket) DeleteBucket(name Data) error {
+	if err := b.writeCheck(); err != nil {
+		return err
+	}
+	child := b.Bucket(name)
+	if child == nil {
+		return fmt.Errorf("bucket %q does not exist", name)
+	}
+	if !child.isInline {
+		b.freeTree(child.tree.Root)
+	}
+	delete(b.children, string(name))
+	b.tree.Delete(name)
+	b.writeBack()
+	return nil
+}
+
+// Delete every page of a bucket's sub-tree at `ptr`, with pages of sub-buckets in its leaves
+func (b *Bucket) freeTree(ptr uint64) {
+	node := b.tree.Get(ptr)
+	if node == nil {
+		return
+	}
+	if node.IsLeaf {
+		for i := uint8(0); i < node.NumKeys; i++ {
+			if value := node.Values[i]; isNestedBucket(value) {
+				b.freeTree(binary.LittleEndian.Uint64(value[1:]))
+			}
+		}
+	} else {
+		for i := uint8(0); i <= node.NumKeys; i++ {
+			b.freeTree(node.Child[i])
+		}
+	}
+	b.tree.Del(ptr)
+}
+
+// Call `fn` for every pair in ascending order of keys until it returns false, value of a sub-bucket is nil
+func (b *Bucket) ForEach(fn func(key Data, value Data) bool) {
+	if b.isInline {
+		for _, pair := range b.inline {
+			if !fn(pair.key, pair.value) {
+				return
+			}
+		}
+		return
+	}
+	b.tree.Scan(nil, nil, func(key Data, stored Data) bool {
+		if b.parent == nil && len(key) == 0 {
+			return true
+		}
+		if len(stored) == 0 || stored[0] != BUCKET_VALUE {
+			return fn(key, nil)
+		}
+		return fn(key, stored[1:])
+	})
+}
+
+// Root bucket of a transaction is its tree, handles are kept until the transaction ends or rolls back
+func (tx *Tx) rootBucket() *Bucket {
+	if tx.buckets == nil {
+		tx.buckets = OpenBucket(&tx.tree)
+	}
+	return tx.buckets
+}
+
+// Bucket of `name` in root bucket of the transaction, nil if it does not exist
+func (tx *Tx) Bucket(name Data) *Bucket {
+	if tx.done {
+		return nil
+	}
+	return tx.rootBucket().Bucket(name)
+}
+
+func (tx *Tx) CreateBucket(name Data) (*Bucket, error) {
+	if err := tx.writeCheck(); err != nil {
+		return nil, err
+	}
+	return tx.rootBucket().CreateBucket(name)
+}
+
+func (tx *Tx) CreateBucketIfNotExists(name Data) (*Bucket, error) {
+	if err := tx.writeCheck(); err != nil {
+		return nil, err
+	}
+	return tx.rootBucket().CreateBucketIfNotExists(name)
+}
+
+func (tx *Tx) DeleteBucket(name Data) error {
+	if err := tx.writeCheck(); err != nil {
+		return err
+	}
+	return tx.rootBucket().DeleteBucket(name)
+}
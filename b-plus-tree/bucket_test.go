@@ -0,0 +1,213 @@
+package bplustree
+
+import (
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Kind of value of sub-bucket `name` stored in tree of `parent`
+func storedKind(parent *Bucket, name string) byte {
+	stored, _ := parent.tree.Search(Data(name))
+	return stored[0]
+}
+
+func TestBucketInlineAndSpill(t *testing.T) {
+	c := newC(t, 4)
+	root := OpenBucket(&c.tree)
+	tenants, err := root.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	_, err = root.CreateBucket(Data("tenants"))
+	assert.NotNil(t, err)
+	assert.Same(t, tenants, root.Bucket(Data("tenants")))
+
+	small, err := tenants.CreateBucket(Data("small"))
+	assert.Nil(t, err)
+	assert.Nil(t, small.Put(Data("a"), Data("1")))
+	assert.Nil(t, small.Put(Data("b"), Data("2")))
+	assert.Equal(t, BUCKET_INLINE, storedKind(tenants, "small"))
+	assert.Equal(t, BUCKET_NESTED, storedKind(root, "tenants"))
+
+	// a sub-bucket moves to its own pages when it grows
+	big, err := tenants.CreateBucket(Data("big"))
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, big.Put(Data(fmt.Sprintf("key-%03d", i)), Data(fmt.Sprintf("value-%d", i))))
+	}
+	assert.Equal(t, BUCKET_NESTED, storedKind(tenants, "big"))
+	assert.Empty(t, big.tree.Validate())
+
+	// handles opened again read the same content from parent values
+	reopened := OpenBucket(&c.tree).Bucket(Data("tenants"))
+	value, found := reopened.Bucket(Data("small")).Get(Data("b"))
+	assert.True(t, found)
+	assert.EqualValues(t, "2", value)
+	value, found = reopened.Bucket(Data("big")).Get(Data("key-042"))
+	assert.True(t, found)
+	assert.EqualValues(t, "value-42", value)
+	assert.Nil(t, reopened.Bucket(Data("missing")))
+
+	keys := []string{}
+	tenants.ForEach(func(key Data, value Data) bool {
+		assert.Nil(t, value)
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Equal(t, []string{"big", "small"}, keys)
+
+	// values and buckets do not replace each other
+	assert.NotNil(t, tenants.Put(Data("big"), Data("x")))
+	_, err = tenants.Delete(Data("small"))
+	assert.NotNil(t, err)
+	assert.Nil(t, tenants.Put(Data("plain"), Data("x")))
+	assert.Nil(t, tenants.Bucket(Data("plain")))
+	_, err = tenants.CreateBucket(Data("plain"))
+	assert.NotNil(t, err)
+
+	deleted, err := small.Delete(Data("a"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	_, found = OpenBucket(&c.tree).Bucket(Data("tenants")).Bucket(Data("small")).Get(Data("a"))
+	assert.False(t, found)
+}
+
+func TestBucketDelete(t *testing.T) {
+	c := newC(t, 4)
+	root := OpenBucket(&c.tree)
+	assert.Nil(t, root.Put(Data("config"), Data("x")))
+	pages := len(c.pages)
+
+	tenants, err := root.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	for tenant := 0; tenant < 5; tenant++ {
+		bucket, err := tenants.CreateBucket(Data(fmt.Sprintf("tenant-%d", tenant)))
+		assert.Nil(t, err)
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, bucket.Put(Data(fmt.Sprintf("key-%03d", i)), Data("value")))
+		}
+		_, err = bucket.CreateBucketIfNotExists(Data("nested"))
+		assert.Nil(t, err)
+		nested, err := bucket.CreateBucketIfNotExists(Data("nested"))
+		assert.Nil(t, err)
+		for i := 0; i < 50; i++ {
+			assert.Nil(t, nested.Put(Data(fmt.Sprintf("key-%03d", i)), Data("value")))
+		}
+	}
+
+	// dropping a tenant drops its nested buckets
+	before := len(c.pages)
+	assert.Nil(t, tenants.DeleteBucket(Data("tenant-3")))
+	assert.NotNil(t, tenants.DeleteBucket(Data("tenant-3")))
+	assert.Less(t, len(c.pages), before)
+	assert.Nil(t, tenants.Bucket(Data("tenant-3")))
+
+	// dropping every tenant returns every page
+	assert.Nil(t, root.DeleteBucket(Data("tenants")))
+	assert.Equal(t, pages, len(c.pages))
+	value, found := root.Get(Data("config"))
+	assert.True(t, found)
+	assert.EqualValues(t, "x", value)
+}
+
+func TestTxBucket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	tenants, err := tx.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	for tenant := 0; tenant < 3; tenant++ {
+		bucket, err := tenants.CreateBucket(Data(fmt.Sprintf("tenant-%d", tenant)))
+		assert.Nil(t, err)
+		for i := 0; i < 10*tenant; i++ {
+			assert.Nil(t, bucket.Put(Data(fmt.Sprintf("key-%03d", i)), Data("value")))
+		}
+	}
+	assert.Nil(t, tx.Commit())
+
+	// changes of a rolled back transaction are discarded, with pages of a deleted bucket
+	tx = db.Begin(true)
+	assert.Nil(t, tx.Bucket(Data("tenants")).DeleteBucket(Data("tenant-2")))
+	_, err = tx.CreateBucket(Data("other"))
+	assert.Nil(t, err)
+	tx.Rollback()
+
+	tx = db.Begin(true)
+	assert.Nil(t, tx.Bucket(Data("other")))
+	assert.Nil(t, tx.Bucket(Data("tenants")).Bucket(Data("tenant-1")).Put(Data("new"), Data("v")))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	tenants = tx.Bucket(Data("tenants"))
+	value, found := tenants.Bucket(Data("tenant-2")).Get(Data("key-019"))
+	assert.True(t, found)
+	assert.EqualValues(t, "value", value)
+	value, found = tenants.Bucket(Data("tenant-1")).Get(Data("new"))
+	assert.True(t, found)
+	assert.EqualValues(t, "v", value)
+	assert.Equal(t, ErrTxReadOnly, tenants.Bucket(Data("tenant-0")).Put(Data("a"), Data("b")))
+	_, err = tx.CreateBucket(Data("x"))
+	assert.Equal(t, ErrTxReadOnly, err)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func TestBucketInlineCopiesValue(t *testing.T) {
+	c := newC(t, 4)
+	small, err := OpenBucket(&c.tree).CreateBucket(Data("small"))
+	assert.Nil(t, err)
+	key, value := Data("key"), Data("value")
+	assert.Nil(t, small.Put(key, value))
+	assert.True(t, small.isInline)
+	copy(key, "xxx")
+	copy(value, "xxxxx")
+	stored, found := small.Get(Data("key"))
+	assert.True(t, found)
+	assert.EqualValues(t, "value", stored)
+	assert.Nil(t, small.Put(Data("key"), value))
+	copy(value, "yyyyy")
+	stored, _ = small.Get(Data("key"))
+	assert.EqualValues(t, "xxxxx", stored)
+}
+
+func TestTxBucketRejectsPairs(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "db"))
+	tx := db.Begin(true)
+	// a pair whose value looks like a nested bucket is not read as a bucket
+	assert.Nil(t, tx.Insert(Data("fake"), encodeNestedBucket(12345)))
+	assert.Nil(t, tx.Bucket(Data("fake")))
+	assert.NotNil(t, tx.DeleteBucket(Data("fake")))
+	_, err := tx.CreateBucket(Data("tenants"))
+	assert.NotNil(t, err)
+	_, err = tx.Delete(Data("fake"))
+	assert.Nil(t, err)
+
+	// once the tree stores buckets, pairs are only written through buckets
+	_, err = tx.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	assert.Equal(t, ErrTxBuckets, tx.Insert(Data("fake"), encodeNestedBucket(12345)))
+	_, err = tx.Delete(Data("tenants"))
+	assert.Equal(t, ErrTxBuckets, err)
+	assert.NotNil(t, tx.Insert(Data{}, Data("x")))
+	assert.NotNil(t, tx.rootBucket().Put(Data{}, Data("x")))
+	_, err = tx.rootBucket().Delete(Data{})
+	assert.NotNil(t, err)
+	assert.Nil(t, tx.rootBucket().Put(Data("config"), Data("x")))
+	keys := []string{}
+	tx.rootBucket().ForEach(func(key Data, value Data) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	assert.Equal(t, []string{"config", "tenants"}, keys)
+	assert.Nil(t, tx.Commit())
+
+	tx = db.Begin(true)
+	assert.Equal(t, ErrTxBuckets, tx.Insert(Data("fake"), Data("x")))
+	assert.NotNil(t, tx.Bucket(Data("tenants")))
+	tx.Rollback()
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,104 @@
+package bplustree
+
+import (
+	"fmt"
+)
+
+// Sizes of `total` items split into as few groups of at most `capacity` items as possible, sizes are balanced
+// so every group has at least half of `capacity`
+func balancedGroups(total int, capacity int) []int {
+	numGroups := (total + capacity - 1) / capacity
+	sizes := make([]int, numGroups)
+	for i := range sizes {
+		sizes[i] = total / numGroups
+		if i < total%numGroups {
+			sizes[i] += 1
+		}
+	}
+	return sizes
+}
+
+// Build tree bottom-up from `keys` / `values` pairs sorted in ascending order of keys, tree must be empty:
+//
+//	keys:
+//	values: value of each key in `keys`
+func (t *BTree) BulkLoad(keys []Data, values []Data) error {
+	if t.Get(t.Root) != nil {
+		return fmt.Errorf("bulk load into a non-empty tree")
+	}
+	if len(keys) != len(values) {
+		return fmt.Errorf("%d keys and %d values", len(keys), len(values))
+	}
+	for i := 1; i < len(keys); i++ {
+		if keys[i].lt(keys[i-1]) {
+			return fmt.Errorf("key %d is less than key %d", i, i-1)
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	// fill leaves from left to right
+	var level []uint64      // pointers of nodes in current level
+	var counts []uint64     // counts[i] is number of keys in sub-tree of level[i]
+	var summaries []Data    // summaries[i] is summary of sub-tree of level[i], if tree has `Monoid`
+	var separators []Data   // separators[i] is between level[i] and level[i+1]
+	var previousLeaf *BNode // to link `Next` pointer and set `HighKey`
+	offset := 0
+	for _, size := range balancedGroups(len(keys), int(t.Order-1)) {
+		leaf := newLeaf(t.Order)
+		copy(leaf.Keys, keys[offset:offset+size])
+		copy(leaf.Values, values[offset:offset+size])
+		leaf.NumKeys = uint8(size)
+		leafPtr := t.New(leaf)
+		if previousLeaf != nil {
+			previousLeaf.Next = leafPtr
+			separators = append(separators, shortestSeparator(keys[offset-1], keys[offset]))
+			previousLeaf.HighKey = separators[len(separators)-1]
+		}
+		level = append(level, leafPtr)
+		counts = append(counts, uint64(size))
+		if t.Monoid != nil {
+			summaries = append(summaries, t.summarize(leaf))
+		}
+		previousLeaf = leaf
+		offset += size
+	}
+
+	// build internal nodes level by level, until there is only root
+	for len(level) > 1 {
+		var upperLevel []uint64
+		var upperCounts []uint64
+		var upperSummaries []Data
+		var upperSeparators []Data
+		var previousNode *BNode
+		offset = 0
+		for _, size := range balancedGroups(len(level), int(t.Order)) {
+			node := newNode(t.Order)
+			copy(node.Child, level[offset:offset+size])
+			copy(node.Counts, counts[offset:offset+size])
+			copy(node.Keys, separators[offset:offset+size-1])
+			node.NumKeys = uint8(size - 1)
+			if t.Monoid != nil {
+				copy(node.Summaries, summaries[offset:offset+size])
+				upperSummaries = append(upperSummaries, t.summarize(node))
+			}
+			nodePtr := t.New(node)
+			if previousNode != nil {
+				previousNode.Next = nodePtr
+				previousNode.HighKey = separators[offset-1]
+				upperSeparators = append(upperSeparators, separators[offset-1])
+			}
+			upperLevel = append(upperLevel, nodePtr)
+			upperCounts = append(upperCounts, node.count())
+			previousNode = node
+			offset += size
+		}
+		level = upperLevel
+		counts = upperCounts
+		summaries = upperSummaries
+		separators = upperSeparators
+	}
+	t.Root = level[0]
+	return nil
+}
@@ -0,0 +1,56 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBalancedGroups(t *testing.T) {
+	assert.Equal(t, balancedGroups(3, 3), []int{3})
+	assert.Equal(t, balancedGroups(4, 3), []int{2, 2})
+	assert.Equal(t, balancedGroups(7, 3), []int{3, 2, 2})
+	assert.Equal(t, balancedGroups(1, 4), []int{1})
+}
+
+func TestBulkLoad(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		for _, total := range []int{1, 2, 3, 7, 50, 333} {
+			c := newC(t, order)
+			keys := make([]Data, total)
+			values := make([]Data, total)
+			for i := 0; i < total; i++ {
+				keys[i] = make(Data, 2)
+				binary.BigEndian.PutUint16(keys[i], uint16(i*3))
+				values[i] = createData(uint16(i))
+			}
+			assert.Nil(t, c.tree.BulkLoad(keys, values))
+			assert.Empty(t, c.tree.Validate())
+
+			for i := 0; i < total; i++ {
+				val, found := c.tree.Search(keys[i])
+				assert.True(t, found)
+				assert.EqualValues(t, val, values[i])
+			}
+
+			// tree is usable after bulk load
+			extra := make(Data, 2)
+			binary.BigEndian.PutUint16(extra, 1)
+			c.add(extra, extra)
+			assert.True(t, c.del(keys[0]))
+		}
+	}
+}
+
+func TestBulkLoadErrors(t *testing.T) {
+	c := newC(t, 4)
+	assert.Nil(t, c.tree.BulkLoad(nil, nil))
+	assert.Nil(t, c.tree.Get(c.tree.Root))
+
+	assert.NotNil(t, c.tree.BulkLoad([]Data{createData(1)}, nil))
+	assert.NotNil(t, c.tree.BulkLoad([]Data{{2}, {1}}, []Data{{2}, {1}}))
+
+	c.add(createData(1), createData(1))
+	assert.NotNil(t, c.tree.BulkLoad([]Data{{1}}, []Data{{1}}))
+}
@@ -0,0 +1,162 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+const (
+	CATALOG_MAX_NAME_SIZE = 64
+	CATALOG_COUNT_SIZE    = 2
+	CATALOG_ENTRY_SIZE    = 1 + 1 + 8 // nlen, order, root, without name
+)
+
+// A named tree of a `Pager`, stored in the catalog of its meta page
+type catalogEntry struct {
+	order uint8
+	root  uint64 // root pointer of last commit
+	tree  *BTree // tree returned by `CreateTree` or `OpenTree`, nil if it is not opened since open of pager
+}
+
+func decodeCatalog(section []byte) (map[string]*catalogEntry, error) {
+	catalog := map[string]*catalogEntry{}
+	count := int(binary.LittleEndian.Uint16(section[0:2]))
+	offset := CATALOG_COUNT_SIZE
+	for i := 0; i < count; i++ {
+		if offset+1 > len(section) {
+			return nil, fmt.Errorf("catalog entry %d is out of page", i)
+		}
+		nlen := int(section[offset])
+		if offset+CATALOG_ENTRY_SIZE+nlen > len(section) {
+			return nil, fmt.Errorf("catalog entry %d has name bytes = %d out of page", i, nlen)
+		}
+		name := string(section[offset+1 : offset+1+nlen])
+		offset += 1 + nlen
+		order := section[offset]
+		if order < 3 || order > ORDER {
+			return nil, fmt.Errorf("tree %q has order %d out of [3, %d]", name, order, ORDER)
+		}
+		catalog[name] = &catalogEntry{order: order, root: binary.LittleEndian.Uint64(section[offset+1 : offset+9])}
+		offset += 1 + 8
+	}
+	return catalog, nil
+}
+
+// Write catalog into `section` of meta page, with current root of every opened tree
+func (p *Pager) encodeCatalog(section []byte) error {
+	if size := p.catalogSize(); size > len(section) {
+		return fmt.Errorf("catalog has bytes = %d larger than maximum %d", size, len(section))
+	}
+	binary.LittleEndian.PutUint16(section[0:2], uint16(len(p.catalog)))
+	offset := CATALOG_COUNT_SIZE
+	for _, name := range p.ListTrees() {
+		entry := p.catalog[name]
+		root := entry.root
+		if entry.tree != nil {
+			root = entry.tree.Root
+		}
+		section[offset] = uint8(len(name))
+		copy(section[offset+1:], name)
+		offset += 1 + len(name)
+		section[offset] = entry.order
+		binary.LittleEndian.PutUint64(section[offset+1:offset+9], root)
+		offset += 1 + 8
+	}
+	return nil
+}
+
+func (p *Pager) catalogSize() int {
+	size := CATALOG_COUNT_SIZE
+	for name := range p.catalog {
+		size += CATALOG_ENTRY_SIZE + len(name)
+	}
+	return size
+}
+
+func (p *Pager) namedTree(entry *catalogEntry) *BTree {
+	if entry.tree == nil {
+		entry.tree = &BTree{
+			Root:   entry.root,
+			Order:  entry.order,
+			MinKey: (entry.order+1)/2 - 1,
+			Get:    p.Get,
+			New:    p.New,
+			Del:    p.Del,
+			Dirty:  p.Dirty,
+		}
+	}
+	return entry.tree
+}
+
+// Create an empty tree of `name`, whose nodes share pages and free list of this pager. Its root is stored
+// in the catalog by every `Commit`, so the tree exists in file after next commit.
+// `order` must not be larger than `ORDER`, because a page has slots of `ORDER`
+func (p *Pager) CreateTree(name string, order uint8) (*BTree, error) {
+	if _, exists := p.catalog[name]; exists {
+		return nil, fmt.Errorf("tree %q already exists", name)
+	}
+	if len(name) == 0 || len(name) > CATALOG_MAX_NAME_SIZE {
+		return nil, fmt.Errorf("tree name has bytes = %d out of [1, %d]", len(name), CATALOG_MAX_NAME_SIZE)
+	}
+	if order < 3 || order > ORDER {
+		return nil, fmt.Errorf("order %d is out of [3, %d]", order, ORDER)
+	}
+	if size := p.catalogSize() + CATALOG_ENTRY_SIZE + len(name); size > BTREE_PAGE_SIZE-PAGE_CHECKSUM_SIZE-32 {
+		return nil, fmt.Errorf("catalog is full")
+	}
+	entry := &catalogEntry{order: order}
+	p.catalog[name] = entry
+	return p.namedTree(entry), nil
+}
+
+// Tree of `name`, every call returns the same tree until it is dropped
+func (p *Pager) OpenTree(name string) (*BTree, error) {
+	entry, exists := p.catalog[name]
+	if !exists {
+		return nil, fmt.Errorf("tree %q does not exist", name)
+	}
+	return p.namedTree(entry), nil
+}
+
+// Remove tree of `name` from catalog, and return every page of it to free list. A tree returned by `OpenTree`
+// before becomes empty
+func (p *Pager) DropTree(name string) error {
+	entry, exists := p.catalog[name]
+	if !exists {
+		return fmt.Errorf("tree %q does not exist", name)
+	}
+	tree := p.namedTree(entry)
+	p.freeSubtree(tree.Root)
+	tree.Root = 0
+	delete(p.catalog, name)
+	return nil
+}
+
+// Return every page of sub-tree at `ptr` to free list
+func (p *Pager) freeSubtree(ptr uint64) {
+	node := p.Get(ptr)
+	if node == nil {
+		return
+	}
+	if !node.IsLeaf {
+		for i := uint8(0); i <= node.NumKeys; i++ {
+			p.freeSubtree(node.Child[i])
+		}
+	}
+	p.Del(ptr)
+}
+
+// Names of trees in catalog, in ascending order
+func (p *Pager) ListTrees() []string {
+	return sortedNames(p.catalog)
+}
+
+func sortedNames(catalog map[string]*catalogEntry) []string {
+	names := make([]string, 0, len(catalog))
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,115 @@
+package bplustree
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Keys of a tree as uint16
+func treeKeys(tree *BTree) []uint16 {
+	keys := []uint16{}
+	tree.Scan(nil, nil, func(key Data, value Data) bool {
+		keys = append(keys, uint16(key[0])<<8|uint16(key[1]))
+		return true
+	})
+	return keys
+}
+
+func TestCatalog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pager := openTestPager(t, path, nil)
+	mainTree := pager.Tree()
+	users, err := pager.CreateTree("users", 3)
+	assert.Nil(t, err)
+	orders, err := pager.CreateTree("orders", ORDER)
+	assert.Nil(t, err)
+	_, err = pager.CreateTree("users", 3)
+	assert.NotNil(t, err)
+	_, err = pager.CreateTree("big", ORDER+1)
+	assert.NotNil(t, err)
+	_, err = pager.CreateTree(strings.Repeat("x", CATALOG_MAX_NAME_SIZE+1), 3)
+	assert.NotNil(t, err)
+	_, err = pager.OpenTree("missing")
+	assert.NotNil(t, err)
+
+	for i := uint16(0); i < 100; i++ {
+		mainTree.Insert(createBigEndianData(i), createData(i))
+		users.Insert(createBigEndianData(i+1000), createData(i))
+		if i%2 == 0 {
+			orders.Insert(createBigEndianData(i+2000), createData(i))
+		}
+	}
+	opened, err := pager.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Same(t, users, opened)
+	assert.Nil(t, pager.Commit(mainTree.Root))
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	assert.Equal(t, []string{"orders", "users"}, pager.ListTrees())
+	users, err = pager.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), users.Order)
+	assert.Empty(t, users.Validate())
+	assert.Equal(t, keyRange(1000, 1099, 1), treeKeys(users))
+	orders, err = pager.OpenTree("orders")
+	assert.Nil(t, err)
+	assert.Equal(t, keyRange(2000, 2098, 2), treeKeys(orders))
+	assert.Equal(t, keyRange(0, 99, 1), treeKeys(pager.Tree()))
+
+	// a tree of smaller order keeps working after its nodes are loaded from pages of `ORDER` slots
+	for i := uint16(0); i < 100; i += 3 {
+		users.Delete(createBigEndianData(i + 1000))
+	}
+	assert.Empty(t, users.Validate())
+	assert.Nil(t, pager.Commit(pager.Tree().Root))
+	assert.Nil(t, pager.Close())
+}
+
+func TestCatalogDropTree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	pager := openTestPager(t, path, nil)
+	tree, err := pager.CreateTree("tenant", ORDER)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 300; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(0))
+	pageCount := pager.pageCount
+
+	assert.Nil(t, pager.DropTree("tenant"))
+	assert.NotNil(t, pager.DropTree("tenant"))
+	assert.Equal(t, uint64(0), tree.Root)
+	assert.Equal(t, int(pageCount-1), len(pager.free))
+	assert.Nil(t, pager.Commit(0))
+	assert.Nil(t, pager.Close())
+
+	// pages of the dropped tree are reused by another one
+	pager = openTestPager(t, path, nil)
+	assert.Empty(t, pager.ListTrees())
+	assert.Equal(t, int(pageCount-1), len(pager.free))
+	tree, err = pager.CreateTree("tenant", ORDER)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 300; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Equal(t, pageCount, pager.pageCount)
+	assert.Nil(t, pager.Close())
+}
+
+func TestCatalogFull(t *testing.T) {
+	pager := openTestPager(t, filepath.Join(t.TempDir(), "db"), nil)
+	var err error
+	created := 0
+	for ; err == nil; created++ {
+		_, err = pager.CreateTree(fmt.Sprintf("%064d", created), ORDER)
+	}
+	assert.Equal(t, (BTREE_PAGE_SIZE-PAGE_CHECKSUM_SIZE-32-CATALOG_COUNT_SIZE)/(CATALOG_ENTRY_SIZE+CATALOG_MAX_NAME_SIZE), created-1)
+	// the largest catalog still fits in meta page
+	assert.Nil(t, pager.Commit(0))
+	assert.Nil(t, pager.Close())
+}
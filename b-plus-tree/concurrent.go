@@ -0,0 +1,150 @@
+package bplustree
+
+import (
+	"sync"
+)
+
+// ConcurrentBTree wraps a `BTree` to be safe for concurrent use: many readers run in parallel, writers are serialized.
+// `Get` of the wrapped tree must be safe to call from concurrent readers.
+type ConcurrentBTree struct {
+	mu       sync.RWMutex
+	tree     BTree
+	versions *versionStore // old content of pages for snapshots
+}
+
+func NewConcurrentBTree(tree BTree) *ConcurrentBTree {
+	versions := newVersionStore(tree.Get, tree.Del)
+	newPage, dirty := tree.New, tree.Dirty
+	tree.New = func(node *BNode) uint64 {
+		ptr := newPage(node)
+		versions.created(ptr)
+		return ptr
+	}
+	tree.Del = versions.delete
+	tree.Dirty = func(ptr uint64) {
+		if dirty != nil {
+			dirty(ptr)
+		}
+		versions.dirty(ptr)
+	}
+	return &ConcurrentBTree{tree: tree, versions: versions}
+}
+
+func (c *ConcurrentBTree) Search(key Data) (Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Search(key)
+}
+
+// Scan holds read lock until it returns, so `fn` must not write to this tree
+func (c *ConcurrentBTree) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.tree.Scan(start, end, fn)
+}
+
+func (c *ConcurrentBTree) Insert(key Data, value Data) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tree.Insert(key, value)
+}
+
+func (c *ConcurrentBTree) Delete(key Data) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tree.Delete(key)
+}
+
+// Validate structure of tree while holding read lock
+func (c *ConcurrentBTree) Validate() []Violation {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Validate()
+}
+
+// Read-modify-write `key` while holding write lock, so no writer changes it in between. `fn` must not use this tree
+func (c *ConcurrentBTree) Update(key Data, fn func(old Data, exists bool) (Data, bool)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions.dirtyPath(c.tree.Root, key)
+	c.tree.Update(key, fn)
+}
+
+func (c *ConcurrentBTree) CompareAndSwap(key Data, expected Data, newValue Data) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.versions.dirtyPath(c.tree.Root, key)
+	return c.tree.CompareAndSwap(key, expected, newValue)
+}
+
+func (c *ConcurrentBTree) DeleteIfEquals(key Data, expected Data) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.tree.DeleteIfEquals(key, expected)
+}
+
+func (c *ConcurrentBTree) Len() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Len()
+}
+
+func (c *ConcurrentBTree) Rank(key Data) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Rank(key)
+}
+
+func (c *ConcurrentBTree) Select(i uint64) (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Select(i)
+}
+
+func (c *ConcurrentBTree) CountRange(start Data, end Data) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.CountRange(start, end)
+}
+
+func (c *ConcurrentBTree) Aggregate(start Data, end Data) Data {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Aggregate(start, end)
+}
+
+func (c *ConcurrentBTree) First() (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.First()
+}
+
+func (c *ConcurrentBTree) Last() (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Last()
+}
+
+func (c *ConcurrentBTree) Floor(key Data) (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Floor(key)
+}
+
+func (c *ConcurrentBTree) Ceiling(key Data) (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Ceiling(key)
+}
+
+func (c *ConcurrentBTree) Lower(key Data) (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Lower(key)
+}
+
+func (c *ConcurrentBTree) Higher(key Data) (Data, Data, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tree.Higher(key)
+}
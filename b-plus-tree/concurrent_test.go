@@ -0,0 +1,114 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConcurrentBTree(t *testing.T) {
+	c := NewConcurrentBTree(newC(t, 4).tree)
+
+	const workers = 32
+	const keysPerWorker = 200
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			// every worker owns keys `worker`, `worker` + workers, ...
+			owned := map[uint16]bool{}
+			for i := 0; i < keysPerWorker*3; i++ {
+				key := uint16(worker + workers*random.Intn(keysPerWorker))
+				data := createBigEndianData(key)
+				switch random.Intn(4) {
+				case 0:
+					if owned[key] {
+						assert.True(t, c.Delete(data))
+						delete(owned, key)
+					} else {
+						c.Insert(data, createData(key))
+						owned[key] = true
+					}
+				case 1:
+					val, found := c.Search(data)
+					assert.Equal(t, owned[key], found)
+					if found {
+						assert.EqualValues(t, val, createData(key))
+					}
+				case 2:
+					var previous Data
+					c.Scan(data, nil, func(key Data, value Data) bool {
+						assert.False(t, key.lt(data))
+						if previous != nil {
+							assert.False(t, key.lt(previous))
+						}
+						previous = key
+						return !key.gt(createBigEndianData(uint16(worker + workers*keysPerWorker/2)))
+					})
+				default:
+					c.Insert(data, createData(key))
+					_, found := c.Search(data)
+					assert.True(t, found)
+					assert.True(t, c.Delete(data))
+				}
+			}
+			for key := range owned {
+				val, found := c.Search(createBigEndianData(key))
+				assert.True(t, found)
+				assert.EqualValues(t, val, createData(key))
+			}
+		}(worker)
+	}
+	wg.Wait()
+	assert.Empty(t, c.Validate())
+}
+
+func TestConcurrentCompareAndSwap(t *testing.T) {
+	c := NewConcurrentBTree(newC(t, 4).tree)
+	const workers = 16
+	const increments = 200
+	counter := createBigEndianData(0)
+	lock := createBigEndianData(1)
+	holders := 0
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			owner := createData(uint16(worker))
+			for i := 0; i < increments; i++ {
+				// counter with optimistic retry
+				for {
+					old, found := c.Search(counter)
+					value := make(Data, 8)
+					if found {
+						binary.BigEndian.PutUint64(value, binary.BigEndian.Uint64(old)+1)
+					} else {
+						old = nil
+						binary.BigEndian.PutUint64(value, 1)
+					}
+					if c.CompareAndSwap(counter, old, value) {
+						break
+					}
+				}
+				// lock which is held by at most 1 worker
+				if c.CompareAndSwap(lock, nil, owner) {
+					holders += 1
+					assert.Equal(t, 1, holders)
+					holders -= 1
+					assert.True(t, c.DeleteIfEquals(lock, owner))
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+	val, _ := c.Search(counter)
+	assert.Equal(t, uint64(workers*increments), binary.BigEndian.Uint64(val))
+	_, found := c.Search(lock)
+	assert.False(t, found)
+}
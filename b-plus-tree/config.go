@@ -7,6 +7,8 @@ const (
 	BTREE_PAGE_SIZE    = 4096
 	BTREE_MAX_KEY_SIZE = 347
 	BTREE_MAX_VAL_SIZE = 1000
+	// maximum size of a summary of `Monoid` kept in internal nodes
+	BTREE_MAX_SUMMARY_SIZE = 64
 )
 
 type Data []byte
@@ -29,3 +31,16 @@ func (d Data) lt(other Data) bool {
 func (d Data) gt(other Data) bool {
 	return bytes.Compare(d, other) > 0
 }
+
+// Shortest `separator` which still separates `left` and `right`: left < separator <= right.
+// It is a prefix of `right`, so it is `right` itself when `left` is equal to it.
+func shortestSeparator(left Data, right Data) Data {
+	n := 0
+	for n < len(left) && n < len(right) && left[n] == right[n] {
+		n += 1
+	}
+	if n >= len(right) {
+		return right
+	}
+	return right[:n+1]
+}
@@ -7,7 +7,10 @@ import (
 )
 
 func TestConst(t *testing.T) {
-	assert.LessOrEqual(t, 2+8+ORDER*8+(ORDER-1)*(2+2+BTREE_MAX_KEY_SIZE+BTREE_MAX_VAL_SIZE), BTREE_PAGE_SIZE)
+	// leaf
+	assert.LessOrEqual(t, PAGE_HEADER_SIZE+(ORDER-1)*(PAGE_ENTRY_SIZE+BTREE_MAX_KEY_SIZE+BTREE_MAX_VAL_SIZE)+PAGE_CHECKSUM_SIZE, BTREE_PAGE_SIZE)
+	// internal node
+	assert.LessOrEqual(t, PAGE_HEADER_SIZE+PAGE_CHILD_SIZE+PAGE_COUNT_SIZE+PAGE_SUMMARY_SIZE+(ORDER-1)*(PAGE_ENTRY_SIZE+BTREE_MAX_KEY_SIZE)+PAGE_CHECKSUM_SIZE, BTREE_PAGE_SIZE)
 }
 
 func TestCompareValue(t *testing.T) {
@@ -44,3 +47,14 @@ func TestGt(t *testing.T) {
 	assert.False(t, d.gt([]byte{0, 10}))
 	assert.False(t, d.gt([]byte{0, 11}))
 }
+
+func TestShortestSeparator(t *testing.T) {
+	assert.EqualValues(t, shortestSeparator(Data("abc"), Data("abe")), Data("abe"))
+	assert.EqualValues(t, shortestSeparator(Data("abc"), Data("abex")), Data("abe"))
+	assert.EqualValues(t, shortestSeparator(Data("ab"), Data("abc")), Data("abc"))
+	assert.EqualValues(t, shortestSeparator(Data("ab"), Data("abcd")), Data("abc"))
+	assert.EqualValues(t, shortestSeparator(Data("tenant/a/1"), Data("tenant/b/1")), Data("tenant/b"))
+	assert.EqualValues(t, shortestSeparator(Data{}, Data("b")), Data("b"))
+	// duplicated keys can not be separated by a shorter key
+	assert.EqualValues(t, shortestSeparator(Data("abc"), Data("abc")), Data("abc"))
+}
@@ -0,0 +1,331 @@
+package bplustree
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+)
+
+// Latch of every page, created on first use
+type latchTable struct {
+	mu      sync.Mutex
+	latches map[uint64]*sync.RWMutex
+}
+
+func (l *latchTable) get(ptr uint64) *sync.RWMutex {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.latches == nil {
+		l.latches = map[uint64]*sync.RWMutex{}
+	}
+	latch, ok := l.latches[ptr]
+	if !ok {
+		latch = &sync.RWMutex{}
+		l.latches[ptr] = latch
+	}
+	return latch
+}
+
+// CrabbingBTree wraps a `BTree` to be safe for concurrent use with a latch per node.
+// Readers descend from root by latch crabbing. A writer first latches nodes of its path shared and only its leaf
+// exclusive, so writers to different leaves run in parallel, even through root. If the leaf would split or underflow,
+// it descends again with exclusive latches from the lowest node which will not, and root pointer too if root would.
+// Latches of ancestors above that node are held shared until the key is added to or removed from their key counts,
+// which are changed atomically, and their summaries are set bottom-up under a summary latch of each node.
+// A delete of a missing key changes nothing, it only latches shared above its leaf.
+// `Get`, `New`, `Del` and `Dirty` of the wrapped tree must be safe for concurrent use.
+type CrabbingBTree struct {
+	rootLatch sync.RWMutex // protects `tree.Root`
+	latches   latchTable
+	summaries latchTable // protects summaries of nodes latched shared
+	tree      BTree
+}
+
+func NewCrabbingBTree(tree BTree) *CrabbingBTree {
+	return &CrabbingBTree{tree: tree}
+}
+
+// Latch held by a writer, shared or exclusive
+type heldLatch struct {
+	latch     *sync.RWMutex
+	exclusive bool
+}
+
+// Latches held by a writer, released all together when it is done
+type heldLatches []heldLatch
+
+// Lock `latch` exclusive or shared, unless it is already held
+func (h *heldLatches) lock(latch *sync.RWMutex, exclusive bool) {
+	for _, held := range *h {
+		if held.latch == latch {
+			return
+		}
+	}
+	if exclusive {
+		latch.Lock()
+	} else {
+		latch.RLock()
+	}
+	*h = append(*h, heldLatch{latch: latch, exclusive: exclusive})
+}
+
+// Unlock the latch which is locked the last
+func (h *heldLatches) unlockLast() {
+	last := (*h)[len(*h)-1]
+	if last.exclusive {
+		last.latch.Unlock()
+	} else {
+		last.latch.RUnlock()
+	}
+	*h = (*h)[:len(*h)-1]
+}
+
+func (h *heldLatches) unlockAll() {
+	for len(*h) > 0 {
+		h.unlockLast()
+	}
+}
+
+// Latch node at `ptr` of `level` on the path of a writer, exclusive from `depth`. A leaf above `depth` is latched
+// again exclusive, no one splits or merges it meanwhile because its parent is latched.
+// Returns:
+//
+//	*BNode: the node
+//	int: `depth`, or level of the leaf if it is above
+func (c *CrabbingBTree) latchPath(held *heldLatches, ptr uint64, level int, depth int) (*BNode, int) {
+	latch := c.latches.get(ptr)
+	held.lock(latch, level >= depth)
+	node := c.tree.Get(ptr)
+	if node.IsLeaf && level < depth {
+		held.unlockLast()
+		held.lock(latch, true)
+		node, depth = c.tree.Get(ptr), level
+	}
+	return node, depth
+}
+
+// Add `delta` to key counts of ancestors above a changed node, and set their summaries bottom-up.
+// Other writers may latch them shared too, so counts are added atomically, and a summary is set while holding
+// summary latches of the ancestor and of its child if the child is an ancestor too, in that order
+func (c *CrabbingBTree) adjustAncestors(ancestors []parentInfo, delta int64) {
+	t := &c.tree
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		ptr, pos := ancestors[i].parentPtr, ancestors[i].childIndexInParentNode
+		node := t.Get(ptr)
+		t.dirty(ptr)
+		atomic.AddUint64(&node.Counts[pos], uint64(delta))
+		if t.Monoid == nil {
+			continue
+		}
+		latch := c.summaries.get(ptr)
+		latch.Lock()
+		if i+1 < len(ancestors) {
+			childLatch := c.summaries.get(ancestors[i+1].parentPtr)
+			childLatch.Lock()
+			t.setSummary(node, pos)
+			childLatch.Unlock()
+		} else {
+			t.setSummary(node, pos)
+		}
+		latch.Unlock()
+	}
+}
+
+func (c *CrabbingBTree) Search(key Data) (Data, bool) {
+	c.rootLatch.RLock()
+	cursorPtr := c.tree.Root
+	if cursorPtr == 0 {
+		c.rootLatch.RUnlock()
+		return nil, false
+	}
+	latch := c.latches.get(cursorPtr)
+	latch.RLock()
+	c.rootLatch.RUnlock()
+	cursor := c.tree.Get(cursorPtr)
+	for !cursor.IsLeaf {
+		var pos uint8
+		for pos = 0; pos < cursor.NumKeys; pos++ {
+			if cursor.Keys[pos].gt(key) {
+				break
+			}
+		}
+		cursorPtr = cursor.Child[pos]
+		childLatch := c.latches.get(cursorPtr)
+		childLatch.RLock()
+		latch.RUnlock()
+		latch = childLatch
+		cursor = c.tree.Get(cursorPtr)
+	}
+	defer latch.RUnlock()
+	for pos := uint8(0); pos < cursor.NumKeys; pos++ {
+		if cursor.Keys[pos].eq(key) {
+			return cursor.Values[pos], true
+		}
+	}
+	return nil, false
+}
+
+func (c *CrabbingBTree) Insert(key Data, value Data) {
+	// the first pass latches only the leaf exclusive
+	for depth, done := math.MaxInt, false; !done; {
+		done, depth = c.tryInsert(key, value, depth)
+	}
+}
+
+// Insert with shared latches above `depth` and exclusive latches from it, -1 latches root pointer exclusive too.
+// Returns:
+//
+//	bool: whether `key` is inserted, it is not if a node above `depth` may split
+//	int: depth of the next pass
+func (c *CrabbingBTree) tryInsert(key Data, value Data, depth int) (bool, int) {
+	t := &c.tree
+	held := heldLatches{}
+	defer held.unlockAll()
+	held.lock(&c.rootLatch, depth < 0)
+	if t.Get(t.Root) == nil {
+		if depth >= 0 {
+			return false, -1
+		}
+		t.Insert(key, value)
+		return true, depth
+	}
+
+	// descend to leaf, `safe` is level of the lowest node which will not split
+	ptrs, path := []uint64{}, []parentInfo{}
+	ptr, safe := t.Root, -1
+	for level := 0; ; level++ {
+		var node *BNode
+		node, depth = c.latchPath(&held, ptr, level, depth)
+		ptrs = append(ptrs, ptr)
+		if node.NumKeys < t.Order-1 {
+			safe = level
+		}
+		if node.IsLeaf {
+			break
+		}
+		var i uint8
+		for i < node.NumKeys && !key.lt(node.Keys[i]) {
+			i += 1
+		}
+		path = append(path, parentInfo{parentPtr: ptr, childIndexInParentNode: i})
+		ptr = node.Child[i]
+	}
+	if depth >= 0 && safe < depth {
+		if safe > depth-1 {
+			safe = depth - 1
+		}
+		return false, safe
+	}
+
+	// only a split of root returns a new node, while root pointer is latched exclusive
+	start := 0
+	if safe > 0 {
+		start = safe
+	}
+	if insertedNode := t.recursiveInsert(ptrs[start], key, value); insertedNode != nil {
+		t.Root = t.New(insertedNode)
+	}
+	c.adjustAncestors(path[:start], 1)
+	return true, depth
+}
+
+// A node is safe for delete if it will not underflow, root is safe if it keeps at least 1 key
+func (c *CrabbingBTree) safeForDelete(ptr uint64, node *BNode, rootPtr uint64) bool {
+	if ptr == rootPtr {
+		return node.NumKeys > 1
+	}
+	return node.NumKeys > c.tree.MinKey
+}
+
+func (c *CrabbingBTree) Delete(key Data) bool {
+	// the first pass latches only the leaf exclusive
+	for depth, found, done := math.MaxInt, false, false; ; {
+		if found, done, depth = c.tryDelete(key, depth); done {
+			return found
+		}
+	}
+}
+
+// Delete with shared latches above `depth` and exclusive latches from it, -1 latches root pointer exclusive too.
+// Returns:
+//
+//	bool: whether `key` is deleted
+//	bool: false if it is found, but a node above `depth` may underflow
+//	int: depth of the next pass
+func (c *CrabbingBTree) tryDelete(key Data, depth int) (bool, bool, int) {
+	t := &c.tree
+	held := heldLatches{}
+	defer held.unlockAll()
+	held.lock(&c.rootLatch, depth < 0)
+	rootPtr := t.Root
+	if t.Get(rootPtr) == nil {
+		return false, true, depth
+	}
+
+	// descend to leaf, `safe` is level of the lowest node which will not underflow
+	ptrs, path := []uint64{}, []parentInfo{}
+	ptr, safe := rootPtr, -1
+	var parent, node *BNode
+	for level := 0; ; level++ {
+		node, depth = c.latchPath(&held, ptr, level, depth)
+		ptrs = append(ptrs, ptr)
+		if c.safeForDelete(ptr, node, rootPtr) {
+			safe = level
+		} else if level > 0 && level > depth {
+			// an underflow node steals from or merges with a sibling, latch them while parent is latched exclusive
+			pos := path[level-1].childIndexInParentNode
+			if pos > 0 {
+				held.lock(c.latches.get(parent.Child[pos-1]), true)
+			}
+			if pos < parent.NumKeys {
+				held.lock(c.latches.get(parent.Child[pos+1]), true)
+			}
+			// `doDelete` may replace the separator equal to `key`, with `HighKey` of right most nodes on its left
+			if pos > 0 && parent.Keys[pos-1].eq(key) {
+				for ptr := parent.Child[pos-1]; ; {
+					held.lock(c.latches.get(ptr), true)
+					node := t.Get(ptr)
+					if node.IsLeaf {
+						break
+					}
+					ptr = node.Child[node.NumKeys]
+				}
+			}
+		}
+		if node.IsLeaf {
+			break
+		}
+		// same routing as `doDelete`
+		var pos uint8
+		for pos < node.NumKeys && node.Keys[pos].lt(key) {
+			pos += 1
+		}
+		if pos < node.NumKeys && node.Keys[pos].eq(key) {
+			pos += 1
+		}
+		path = append(path, parentInfo{parentPtr: ptr, childIndexInParentNode: pos})
+		parent, ptr = node, node.Child[pos]
+	}
+
+	found := false
+	for pos := uint8(0); pos < node.NumKeys && !found; pos++ {
+		found = node.Keys[pos].eq(key)
+	}
+	if !found {
+		return false, true, depth
+	}
+	if depth >= 0 && safe < depth {
+		if safe > depth-1 {
+			safe = depth - 1
+		}
+		return false, false, safe
+	}
+	start := 0
+	if safe > 0 {
+		start = safe
+	}
+	// separators above `start` equal to `key` are kept, they are still valid bounds
+	t.doDelete(ptrs[start], key, make([]parentInfo, 0))
+	c.adjustAncestors(path[:start], -1)
+	return true, true, depth
+}
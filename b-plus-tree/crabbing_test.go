@@ -0,0 +1,195 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// pages in memory, safe for concurrent use
+type syncStore struct {
+	mu      sync.RWMutex
+	pages   map[uint64]*BNode
+	counter uint64
+}
+
+func newSyncTree(order uint8) BTree {
+	tree, _ := newSyncStoreTree(order)
+	return tree
+}
+
+func newSyncStoreTree(order uint8) (BTree, *syncStore) {
+	s := &syncStore{pages: map[uint64]*BNode{}}
+	return BTree{
+		Order:  order,
+		MinKey: (order+1)/2 - 1,
+		Get: func(ptr uint64) *BNode {
+			s.mu.RLock()
+			defer s.mu.RUnlock()
+			return s.pages[ptr]
+		},
+		New: func(node *BNode) uint64 {
+			ptr := atomic.AddUint64(&s.counter, 1)
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.pages[ptr] = node
+			return ptr
+		},
+		Del: func(ptr uint64) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.pages, ptr)
+		},
+	}, s
+}
+
+func (s *syncStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.pages)
+}
+
+func TestCrabbingBTree(t *testing.T) {
+	// a tree with `Monoid` keeps latches of the whole path
+	for _, monoid := range []*Monoid{nil, CountMonoid()} {
+		for _, order := range []uint8{3, 4, 7} {
+			tree := newSyncTree(order)
+			tree.Monoid = monoid
+			testCrabbingBTree(t, NewCrabbingBTree(tree))
+		}
+	}
+}
+
+func testCrabbingBTree(t *testing.T, c *CrabbingBTree) {
+	_, found := c.Search(createBigEndianData(1))
+	assert.False(t, found)
+	assert.False(t, c.Delete(createBigEndianData(1)))
+
+	const workers = 32
+	const keysPerWorker = 100
+	var wg sync.WaitGroup
+	for worker := 0; worker < workers; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			// every worker owns keys `worker`, `worker` + workers, ...
+			owned := map[uint16]bool{}
+			for i := 0; i < keysPerWorker*4; i++ {
+				key := uint16(worker + workers*random.Intn(keysPerWorker))
+				data := createBigEndianData(key)
+				if random.Intn(3) == 0 {
+					// a key which is never inserted is not removed from key counts
+					assert.False(t, c.Delete(createBigEndianData(key+workers*keysPerWorker)))
+					val, found := c.Search(data)
+					assert.Equal(t, owned[key], found)
+					if found {
+						assert.EqualValues(t, val, createData(key))
+					}
+				} else if owned[key] {
+					assert.True(t, c.Delete(data))
+					delete(owned, key)
+				} else {
+					c.Insert(data, createData(key))
+					owned[key] = true
+				}
+			}
+			for key := range owned {
+				val, found := c.Search(createBigEndianData(key))
+				assert.True(t, found)
+				assert.EqualValues(t, val, createData(key))
+			}
+		}(worker)
+	}
+	wg.Wait()
+	assert.Empty(t, c.tree.Validate())
+	assertCountSummaries(t, c.tree, c.tree.Root)
+}
+
+func TestCrabbingBTreeSharedRoot(t *testing.T) {
+	for _, monoid := range []*Monoid{nil, CountMonoid()} {
+		tree := newSyncTree(8)
+		tree.Monoid = monoid
+		c := NewCrabbingBTree(tree)
+		for key := uint16(0); key < 100; key += 2 {
+			c.Insert(createBigEndianData(key), createData(key))
+		}
+		c.Delete(createBigEndianData(50))
+
+		// a reader keeps root latched shared, writers which do not split or merge still finish
+		c.rootLatch.RLock()
+		c.latches.get(c.tree.Root).RLock()
+		done := make(chan bool)
+		go func() {
+			c.Insert(createBigEndianData(51), createData(51))
+			c.Delete(createBigEndianData(52))
+			c.Delete(createBigEndianData(53))
+			done <- true
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Fatal("writers wait for root")
+		}
+		c.latches.get(c.tree.Root).RUnlock()
+		c.rootLatch.RUnlock()
+
+		assert.Empty(t, c.tree.Validate())
+		assertCountSummaries(t, c.tree, c.tree.Root)
+		assert.EqualValues(t, 49, c.tree.Len())
+	}
+}
+
+// Check that summaries of `CountMonoid` in sub-tree at `ptr` are its key counts, if tree has a `Monoid`
+func assertCountSummaries(t *testing.T, tree BTree, ptr uint64) {
+	node := tree.Get(ptr)
+	if tree.Monoid == nil || node == nil || node.IsLeaf {
+		return
+	}
+	for i := uint8(0); i <= node.NumKeys; i++ {
+		assert.Equal(t, EncodeInt64(int64(node.Counts[i])), node.Summaries[i])
+		assertCountSummaries(t, tree, node.Child[i])
+	}
+}
+
+type concurrentWriter interface {
+	Insert(key Data, value Data)
+}
+
+// Insert b.N random keys by `writers` goroutines
+func benchmarkWriters(b *testing.B, newTree func() concurrentWriter) {
+	for _, writers := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("writers-%d", writers), func(b *testing.B) {
+			tree := newTree()
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for writer := 0; writer < writers; writer++ {
+				wg.Add(1)
+				go func(writer int) {
+					defer wg.Done()
+					random := rand.New(rand.NewSource(int64(writer)))
+					for i := writer; i < b.N; i += writers {
+						key := make(Data, 8)
+						binary.BigEndian.PutUint64(key, random.Uint64())
+						tree.Insert(key, key[:4])
+					}
+				}(writer)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkConcurrentBTreeInsert(b *testing.B) {
+	benchmarkWriters(b, func() concurrentWriter { return NewConcurrentBTree(newSyncTree(64)) })
+}
+
+func BenchmarkCrabbingBTreeInsert(b *testing.B) {
+	benchmarkWriters(b, func() concurrentWriter { return NewCrabbingBTree(newSyncTree(64)) })
+}
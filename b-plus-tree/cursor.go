@@ -0,0 +1,125 @@
+package bplustree
+
+// Cursor walks key / value pairs of a tree in ascending order of keys like `Iterator`, and it can change or delete
+// the current pair in place. It keeps the path from root to its leaf, so key counts and summaries of ancestors
+// are updated without descending again. The tree must not be changed other than by the cursor meanwhile
+type Cursor struct {
+	tree           *BTree
+	ancestorsStack []parentInfo // ancestors of `leaf`, index 0 is the root of a tree
+	leafPtr        uint64
+	leaf           *BNode
+	pos            int // position of current pair in `leaf`, -1 before the first pair of `leaf`
+	start          Data
+	end            Data
+}
+
+// Create a cursor over key / value pairs with `start` <= key < `end`, nil `start` or `end` means no bound.
+// Call `Next` before reading the first pair
+func (t *BTree) Cursor(start Data, end Data) *Cursor {
+	c := &Cursor{tree: t, start: start, end: end}
+	c.descend(t.Root, start)
+	return c
+}
+
+// Descend from a node to a leaf, pushing every internal node passed to ancestors, and stand before the first pair:
+//
+//	nodePtr: start of the descent
+//	key: route to the left most leaf which can contain it and stand before it, nil to go to the left most leaf
+func (c *Cursor) descend(nodePtr uint64, key Data) {
+	c.leafPtr = nodePtr
+	c.leaf = c.tree.Get(nodePtr)
+	for c.leaf != nil && !c.leaf.IsLeaf {
+		// keys equal to a separator may stay in its left child, so go to the left most child which can contain `key`
+		var pos uint8
+		for key != nil && pos < c.leaf.NumKeys && c.leaf.Keys[pos].lt(key) {
+			pos += 1
+		}
+		c.ancestorsStack = append(c.ancestorsStack, parentInfo{parentPtr: c.leafPtr, childIndexInParentNode: pos})
+		c.leafPtr = c.leaf.Child[pos]
+		c.leaf = c.tree.Get(c.leafPtr)
+	}
+	c.pos = -1
+	if c.leaf == nil || key == nil {
+		return
+	}
+	for c.pos+1 < int(c.leaf.NumKeys) && c.leaf.Keys[c.pos+1].lt(key) {
+		c.pos += 1
+	}
+}
+
+// Move to the first leaf after the current one, by going up to the nearest ancestor which has a next child
+func (c *Cursor) nextLeaf() {
+	for len(c.ancestorsStack) > 0 {
+		top := &c.ancestorsStack[len(c.ancestorsStack)-1]
+		parent := c.tree.Get(top.parentPtr)
+		if top.childIndexInParentNode < parent.NumKeys {
+			top.childIndexInParentNode += 1
+			c.descend(parent.Child[top.childIndexInParentNode], nil)
+			return
+		}
+		c.ancestorsStack = c.ancestorsStack[:len(c.ancestorsStack)-1]
+	}
+	c.leaf = nil
+}
+
+// Move to the next pair, returns false when there is no more pair
+func (c *Cursor) Next() bool {
+	for c.leaf != nil {
+		c.pos += 1
+		if c.pos >= int(c.leaf.NumKeys) {
+			c.nextLeaf()
+			continue
+		}
+		key := c.leaf.Keys[c.pos]
+		if c.start != nil && key.lt(c.start) {
+			continue
+		}
+		if c.end != nil && !key.lt(c.end) {
+			c.leaf = nil
+			return false
+		}
+		return true
+	}
+	return false
+}
+
+func (c *Cursor) Key() Data {
+	return c.leaf.Keys[c.pos]
+}
+
+func (c *Cursor) Value() Data {
+	return c.leaf.Values[c.pos]
+}
+
+// Replace value of the current pair
+func (c *Cursor) SetValue(value Data) {
+	c.tree.dirty(c.leafPtr)
+	c.leaf.Values[c.pos] = value
+	c.tree.refreshSummaries(c.ancestorsStack)
+}
+
+// Delete the current pair, then `Next` moves to the pair after it. `Key` and `Value` must not be called before `Next`.
+// If the leaf underflows, the tree is rebalanced and the cursor descends again to stand before the deleted key
+func (c *Cursor) Delete() {
+	key := c.leaf.Keys[c.pos]
+	rebalanced := len(c.ancestorsStack) > 0 && c.leaf.NumKeys <= c.tree.MinKey
+	c.tree.deleteFromLeaf(c.leafPtr, uint8(c.pos), c.ancestorsStack)
+	if !rebalanced {
+		c.pos -= 1
+		if c.tree.Root == 0 {
+			// the last pair of tree is deleted
+			c.leaf = nil
+		}
+		return
+	}
+	c.ancestorsStack = c.ancestorsStack[:0]
+	c.descend(c.tree.Root, key)
+}
+
+// Create a cursor of a writable transaction, it is rejected once the tree stores buckets
+func (tx *Tx) Cursor(start Data, end Data) (*Cursor, error) {
+	if err := tx.pairsCheck(); err != nil {
+		return nil, err
+	}
+	return tx.tree.Cursor(start, end), nil
+}
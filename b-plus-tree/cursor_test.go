@@ -0,0 +1,130 @@
+package bplustree
+
+import (
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	c := newC(t, 4)
+	for key := uint16(0); key < 100; key++ {
+		c.add(createBigEndianData(key), createData(key))
+	}
+	visited := []uint16{}
+	for cursor := c.tree.Cursor(createBigEndianData(10), createBigEndianData(90)); cursor.Next(); {
+		visited = append(visited, uint16(cursor.Key()[0])<<8|uint16(cursor.Key()[1]))
+		assert.EqualValues(t, createData(visited[len(visited)-1]), cursor.Value())
+	}
+	assert.Equal(t, keyRange(10, 89, 1), visited)
+
+	cursor := newC(t, 4).tree.Cursor(nil, nil)
+	assert.False(t, cursor.Next())
+}
+
+func TestCursorDeleteAndSetValue(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		c.tree.Monoid = SumMonoid()
+		expected := map[uint16]int64{}
+		random := rand.New(rand.NewSource(int64(order)))
+		for _, i := range random.Perm(400)[:300] {
+			key := uint16(i)
+			c.tree.Insert(createBigEndianData(key), EncodeInt64(int64(key)))
+			expected[key] = int64(key)
+		}
+
+		// every pair is visited once, even when the tree is rebalanced under the cursor
+		inRange := 0
+		for key := range expected {
+			if key >= 50 {
+				inRange += 1
+			}
+		}
+		visited := 0
+		for cursor := c.tree.Cursor(createBigEndianData(50), nil); cursor.Next(); {
+			visited += 1
+			key := uint16(cursor.Key()[0])<<8 | uint16(cursor.Key()[1])
+			if key%3 != 0 {
+				cursor.Delete()
+				delete(expected, key)
+			} else {
+				cursor.SetValue(EncodeInt64(-int64(key)))
+				expected[key] = -int64(key)
+			}
+		}
+		assert.Empty(t, c.tree.Validate(), "order %d", order)
+
+		assert.Equal(t, inRange, visited)
+		var sum int64
+		for _, value := range expected {
+			sum += value
+		}
+		assert.Equal(t, EncodeInt64(sum), c.tree.Aggregate(nil, nil))
+		assert.Equal(t, uint64(len(expected)), c.tree.Len())
+		c.tree.Scan(nil, nil, func(key Data, value Data) bool {
+			assert.Equal(t, EncodeInt64(expected[uint16(key[0])<<8|uint16(key[1])]), value)
+			return true
+		})
+
+		// delete every pair
+		deleted := 0
+		for cursor := c.tree.Cursor(nil, nil); cursor.Next(); {
+			cursor.Delete()
+			deleted += 1
+		}
+		assert.Equal(t, len(expected), deleted)
+		assert.Equal(t, uint64(0), c.tree.Root)
+	}
+}
+
+func TestTxCursor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	for i := uint16(0); i < 200; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Commit())
+
+	tx = db.Begin(true)
+	cursor, err := tx.Cursor(nil, createBigEndianData(100))
+	assert.Nil(t, err)
+	for cursor.Next() {
+		cursor.Delete()
+	}
+	tx.Rollback()
+
+	tx = db.Begin(true)
+	cursor, err = tx.Cursor(nil, createBigEndianData(100))
+	assert.Nil(t, err)
+	for cursor.Next() {
+		cursor.Delete()
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(100, 199, 1), txKeys(tx))
+	_, err = tx.Cursor(nil, nil)
+	assert.Equal(t, ErrTxReadOnly, err)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func TestTxCursorBuckets(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "db"))
+	tx := db.Begin(true)
+	_, err := tx.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	// a cursor would delete the marker or set raw values of sub-buckets
+	_, err = tx.Cursor(nil, nil)
+	assert.Equal(t, ErrTxBuckets, err)
+	assert.NotNil(t, tx.Bucket(Data("tenants")))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,123 @@
+package bplustree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	DIFF_ADDED   byte = 0 // key is only in the new tree
+	DIFF_REMOVED byte = 1 // key is only in the old tree
+	DIFF_CHANGED byte = 2 // key is in both trees with different values
+)
+
+// DiffFunc receives a difference between two trees, `oldValue` is nil for DIFF_ADDED and `newValue` is nil for
+// DIFF_REMOVED. Returning false stops the diff
+type DiffFunc func(kind byte, key Data, oldValue Data, newValue Data) bool
+
+type treeDiff struct {
+	a       BTree
+	b       BTree
+	fn      DiffFunc
+	pending bool // whether pairs from `start` are not compared yet
+	start   Data
+	err     error
+}
+
+// Call `fn` for every difference from tree `a` to tree `b` in ascending order of keys, until `fn` returns false.
+// Duplicate keys are paired in the order they are scanned.
+// Both trees are walked down together while their internal nodes have the same separators, and a sub-tree is
+// skipped when both trees read the same node object from the same pointer, e.g. trees of two snapshots of a
+// `ConcurrentBTree` by `Snapshot.Tree`, or two versions of a tree over one page store where changed pages are copied
+// to new pointers. Other key ranges are compared by a merge-join over leaves of both trees, so trees without shared
+// pages are compared pair by pair.
+// Returns an error if a tree which can be written, that is it has `New` callback, shares a node object with the
+// other tree, because its pages may be changed in place and the shared sub-tree would be skipped wrongly. Pass
+// read-only views of versions instead
+func Diff(a BTree, b BTree, fn DiffFunc) error {
+	d := &treeDiff{a: a, b: b, fn: fn}
+	if d.diffNode(a.Root, b.Root, nil) {
+		d.compare(nil, false)
+	}
+	return d.err
+}
+
+// Walk sub-trees at `aPtr` and `bPtr`, whose keys are not smaller than `lower`.
+// Returns false when `fn` stops the diff
+func (d *treeDiff) diffNode(aPtr uint64, bPtr uint64, lower Data) bool {
+	aNode, bNode := d.a.Get(aPtr), d.b.Get(bPtr)
+	if aNode != nil && aPtr == bPtr && aNode == bNode {
+		if d.a.New != nil || d.b.New != nil {
+			d.err = fmt.Errorf("page %d is shared by trees which can be written", aPtr)
+			return false
+		}
+		// pairs equal to `lower` may be left of the shared sub-tree, they are compared before it
+		return d.compare(lower, true)
+	}
+	if aNode == nil || bNode == nil || aNode.IsLeaf || bNode.IsLeaf || !sameSeparators(aNode, bNode) {
+		if !d.pending {
+			d.pending, d.start = true, lower
+		}
+		return true
+	}
+	for i := uint8(0); i <= aNode.NumKeys; i++ {
+		childLower := lower
+		if i > 0 {
+			childLower = aNode.Keys[i-1]
+		}
+		if !d.diffNode(aNode.Child[i], bNode.Child[i], childLower) {
+			return false
+		}
+	}
+	return true
+}
+
+func sameSeparators(aNode *BNode, bNode *BNode) bool {
+	if aNode.NumKeys != bNode.NumKeys {
+		return false
+	}
+	for i := uint8(0); i < aNode.NumKeys; i++ {
+		if !aNode.Keys[i].eq(bNode.Keys[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Merge-join pending pairs of both trees from `start` to `end`, nil `end` means no bound. Leaves are walked by
+// cursors through their ancestors, because `Next` of a leaf before a copied one still points to the old copy.
+// Returns false when `fn` stops the diff
+func (d *treeDiff) compare(end Data, inclusive bool) bool {
+	if !d.pending {
+		return true
+	}
+	d.pending = false
+	next := func(cursor *Cursor) bool {
+		if !cursor.Next() {
+			return false
+		}
+		return end == nil || cursor.Key().lt(end) || inclusive && cursor.Key().eq(end)
+	}
+	aCursor, bCursor := d.a.Cursor(d.start, nil), d.b.Cursor(d.start, nil)
+	aOk, bOk := next(aCursor), next(bCursor)
+	for aOk || bOk {
+		switch {
+		case !bOk || aOk && aCursor.Key().lt(bCursor.Key()):
+			if !d.fn(DIFF_REMOVED, aCursor.Key(), aCursor.Value(), nil) {
+				return false
+			}
+			aOk = next(aCursor)
+		case !aOk || bCursor.Key().lt(aCursor.Key()):
+			if !d.fn(DIFF_ADDED, bCursor.Key(), nil, bCursor.Value()) {
+				return false
+			}
+			bOk = next(bCursor)
+		default:
+			if !bytes.Equal(aCursor.Value(), bCursor.Value()) && !d.fn(DIFF_CHANGED, aCursor.Key(), aCursor.Value(), bCursor.Value()) {
+				return false
+			}
+			aOk, bOk = next(aCursor), next(bCursor)
+		}
+	}
+	return true
+}
@@ -0,0 +1,169 @@
+package bplustree
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type diffEntry struct {
+	kind     byte
+	key      Data
+	oldValue Data
+	newValue Data
+}
+
+func collectDiff(t *testing.T, a BTree, b BTree) []diffEntry {
+	entries := []diffEntry{}
+	assert.Nil(t, Diff(a, b, func(kind byte, key Data, oldValue Data, newValue Data) bool {
+		entries = append(entries, diffEntry{kind: kind, key: key, oldValue: oldValue, newValue: newValue})
+		return true
+	}))
+	return entries
+}
+
+// View of `t` which can not be written
+func readOnly(t BTree) BTree {
+	t.New, t.Del, t.Dirty = nil, nil, nil
+	return t
+}
+
+// A new version of tree, which copies nodes on path to `key` to new pointers and replaces value of `key`.
+// Other nodes are shared with `t`
+func copyPath(t BTree, key Data, value Data) BTree {
+	var copyNode func(ptr uint64) uint64
+	copyNode = func(ptr uint64) uint64 {
+		node := cloneNode(t.Get(ptr))
+		var pos uint8
+		if node.IsLeaf {
+			for pos = 0; pos < node.NumKeys && !node.Keys[pos].eq(key); pos++ {
+			}
+			node.Values[pos] = value
+		} else {
+			for pos = 0; pos < node.NumKeys && !node.Keys[pos].gt(key); pos++ {
+			}
+			node.Child[pos] = copyNode(node.Child[pos])
+		}
+		return t.New(node)
+	}
+	version := t
+	version.Root = copyNode(t.Root)
+	return version
+}
+
+// Tree which counts pages read by it
+func countingTree(t BTree, reads *int) BTree {
+	get := t.Get
+	t.Get = func(ptr uint64) *BNode {
+		*reads += 1
+		return get(ptr)
+	}
+	return t
+}
+
+func TestDiff(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		a, b := newC(t, order), newC(t, order)
+		random := rand.New(rand.NewSource(int64(order)))
+		expected := []diffEntry{}
+		for key := uint16(0); key < 500; key++ {
+			switch random.Intn(5) {
+			case 0:
+				a.tree.Insert(createBigEndianData(key), createData(key))
+				expected = append(expected, diffEntry{DIFF_REMOVED, createBigEndianData(key), createData(key), nil})
+			case 1:
+				b.tree.Insert(createBigEndianData(key), createData(key))
+				expected = append(expected, diffEntry{DIFF_ADDED, createBigEndianData(key), nil, createData(key)})
+			case 2:
+				a.tree.Insert(createBigEndianData(key), createData(key))
+				b.tree.Insert(createBigEndianData(key), createData(key+1))
+				expected = append(expected, diffEntry{DIFF_CHANGED, createBigEndianData(key), createData(key), createData(key + 1)})
+			default:
+				a.tree.Insert(createBigEndianData(key), createData(key))
+				b.tree.Insert(createBigEndianData(key), createData(key))
+			}
+		}
+		assert.Equal(t, expected, collectDiff(t, a.tree, b.tree))
+		assert.Empty(t, collectDiff(t, readOnly(a.tree), readOnly(a.tree)))
+		assert.Empty(t, collectDiff(t, newC(t, order).tree, newC(t, order).tree))
+
+		// pages of a tree which can be written may be changed in place, so they are not skipped
+		assert.NotNil(t, Diff(a.tree, a.tree, func(kind byte, key Data, oldValue Data, newValue Data) bool { return true }))
+
+		// `fn` stops the diff
+		calls := 0
+		assert.Nil(t, Diff(a.tree, b.tree, func(kind byte, key Data, oldValue Data, newValue Data) bool {
+			calls += 1
+			return calls < 3
+		}))
+		assert.Equal(t, 3, calls)
+	}
+}
+
+func TestDiffSharedPages(t *testing.T) {
+	c := newC(t, 4)
+	for key := uint16(0); key < 1000; key++ {
+		c.tree.Insert(createBigEndianData(key), createData(key))
+	}
+	pages := len(c.pages)
+	version := copyPath(c.tree, createBigEndianData(10), createData(11))
+	version = copyPath(version, createBigEndianData(700), createData(701))
+
+	reads := 0
+	entries := collectDiff(t, countingTree(readOnly(c.tree), &reads), countingTree(readOnly(version), &reads))
+	assert.Equal(t, []diffEntry{
+		{DIFF_CHANGED, createBigEndianData(10), createData(10), createData(11)},
+		{DIFF_CHANGED, createBigEndianData(700), createData(700), createData(701)},
+	}, entries)
+	// only pages around the copied paths are read
+	assert.Less(t, reads, pages/4)
+
+	// the same changes are found without shared pages, `Next` of leaves is not updated by `copyPath` so a cursor
+	// walks the version
+	copied := newC(t, 3)
+	for cursor := version.Cursor(nil, nil); cursor.Next(); {
+		copied.tree.Insert(cursor.Key(), cursor.Value())
+	}
+	assert.Equal(t, entries, collectDiff(t, c.tree, copied.tree))
+	assert.Empty(t, collectDiff(t, version, copied.tree))
+	assert.NotNil(t, Diff(c.tree, version, func(kind byte, key Data, oldValue Data, newValue Data) bool { return true }))
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	c := newC(t, 4)
+	tree := NewConcurrentBTree(c.tree)
+	for key := uint16(0); key < 1000; key++ {
+		tree.Insert(createBigEndianData(key), createData(key))
+	}
+	pages := len(c.pages)
+	old := tree.Snapshot()
+	defer old.Release()
+	assert.True(t, tree.CompareAndSwap(createBigEndianData(10), createData(10), createData(11)))
+	assert.True(t, tree.Delete(createBigEndianData(700)))
+	tree.Insert(createBigEndianData(5000), createData(0))
+	current := tree.Snapshot()
+	defer current.Release()
+
+	expected := []diffEntry{
+		{DIFF_CHANGED, createBigEndianData(10), createData(10), createData(11)},
+		{DIFF_REMOVED, createBigEndianData(700), createData(700), nil},
+		{DIFF_ADDED, createBigEndianData(5000), nil, createData(0)},
+	}
+	reads := 0
+	assert.Equal(t, expected, collectDiff(t, countingTree(old.Tree(), &reads), countingTree(current.Tree(), &reads)))
+	// pages which are not changed between snapshots are skipped
+	assert.Less(t, reads, pages/4)
+
+	// pages changed in place after both snapshots keep their content and node objects for them
+	for key := uint16(0); key < 1000; key += 3 {
+		tree.CompareAndSwap(createBigEndianData(key), createData(key), createData(key+2))
+	}
+	reads = 0
+	assert.Equal(t, expected, collectDiff(t, countingTree(old.Tree(), &reads), countingTree(current.Tree(), &reads)))
+	assert.Less(t, reads, pages/4)
+	latest := tree.Snapshot()
+	defer latest.Release()
+	assert.Len(t, collectDiff(t, current.Tree(), latest.Tree()), 334)
+	assert.Empty(t, collectDiff(t, latest.Tree(), tree.Snapshot().Tree()))
+}
@@ -3,6 +3,7 @@ package bplustree
 import (
 	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 )
 
 /*
@@ -11,11 +12,78 @@ ORDER = 4
 Data = x*B
 1B = uint8
 
-| IsLeaf | NumKeys | Next   |  Child          | k0len | v0len | k0      | v0      | k1len | v1len | k1      | v1      | k2len | v2len | k2      |  v2
-| 1B     | 1B      | 8B     |  ORDER*8B = 32B |  2B   |  2B   | k0len B | v0len B |  2B   |  2B   | k1len B | v1len B |  2B   |  2B   | k2len B | v2len B
+| IsLeaf | NumKeys | Next   |  Child (internal only) | Counts (internal only) | Summaries (internal only)  | k0len | v0len | k0      | v0      | k1len | v1len | k1      | v1      | k2len | v2len | k2      |  v2
+| 1B     | 1B      | 8B     |  ORDER*8B = 32B        | ORDER*8B = 32B         | PAGE_SUMMARY_SIZE B        |  2B   |  2B   | k0len B | v0len B |  2B   |  2B   | k1len B | v1len B |  2B   |  2B   | k2len B | v2len B
+
+Counts: number of keys in sub-tree of each child
+Summaries: flag 1B, if it is 1 then each child has a slot of slen 2B and its summary, padded to BTREE_MAX_SUMMARY_SIZE B
+checksum: CRC-32 of every byte before it, stored in the last 4 bytes of the page
+HighKey is not stored, a decoded node has nil HighKey, that is unknown
 *
 */
 
+const (
+	PAGE_HEADER_SIZE   = 2 + 8                                // IsLeaf, NumKeys, Next
+	PAGE_CHILD_SIZE    = ORDER * 8                            // Child of internal node
+	PAGE_COUNT_SIZE    = ORDER * 8                            // Counts of internal node
+	PAGE_SUMMARY_SIZE  = 1 + ORDER*(2+BTREE_MAX_SUMMARY_SIZE) // Summaries of internal node, flag and slen + summary per child
+	PAGE_ENTRY_SIZE    = 2 + 2                                // klen, vlen
+	PAGE_CHECKSUM_SIZE = 4                                    // checksum at the end of page
+)
+
+// Write checksum of `page` into its last bytes
+func putChecksum(page []byte) {
+	end := len(page) - PAGE_CHECKSUM_SIZE
+	binary.LittleEndian.PutUint32(page[end:], crc32.ChecksumIEEE(page[:end]))
+}
+
+// Check if checksum at the end of `page` matches its content
+func verifyChecksum(page []byte) error {
+	end := len(page) - PAGE_CHECKSUM_SIZE
+	if stored, actual := binary.LittleEndian.Uint32(page[end:]), crc32.ChecksumIEEE(page[:end]); stored != actual {
+		return fmt.Errorf("checksum = %08x does not match content %08x", stored, actual)
+	}
+	return nil
+}
+
+// Write summaries of children into `section`, they are only written if a child has a summary
+func encodeSummaries(section []byte, node BNode) error {
+	for i := 0; i < ORDER && i < len(node.Summaries); i++ {
+		if node.Summaries[i] != nil {
+			section[0] = 1
+		}
+	}
+	if section[0] == 0 {
+		return nil
+	}
+	for i := 0; i < ORDER && i < len(node.Summaries); i++ {
+		slot := section[1+i*(2+BTREE_MAX_SUMMARY_SIZE):]
+		slen := len(node.Summaries[i])
+		if slen > BTREE_MAX_SUMMARY_SIZE {
+			return fmt.Errorf("summary %d has bytes = %d larger than maximum %d", i, slen, BTREE_MAX_SUMMARY_SIZE)
+		}
+		binary.LittleEndian.PutUint16(slot[0:2], uint16(slen))
+		copy(slot[2:2+slen], node.Summaries[i])
+	}
+	return nil
+}
+
+func decodeSummaries(section []byte) ([]Data, error) {
+	summaries := make([]Data, ORDER)
+	if section[0] == 0 {
+		return summaries, nil
+	}
+	for i := 0; i < ORDER; i++ {
+		slot := section[1+i*(2+BTREE_MAX_SUMMARY_SIZE):]
+		slen := int(binary.LittleEndian.Uint16(slot[0:2]))
+		if slen > BTREE_MAX_SUMMARY_SIZE {
+			return nil, fmt.Errorf("summary %d has bytes = %d larger than maximum %d", i, slen, BTREE_MAX_SUMMARY_SIZE)
+		}
+		summaries[i] = slot[2 : 2+slen]
+	}
+	return summaries, nil
+}
+
 func EncodeToBytes(node BNode) ([]byte, error) {
 
 	result := make([]byte, BTREE_PAGE_SIZE)
@@ -23,17 +91,28 @@ func EncodeToBytes(node BNode) ([]byte, error) {
 		result[0] = 1
 	}
 	result[1] = node.NumKeys
-	if node.IsLeaf {
-		binary.LittleEndian.PutUint64(result[2:10], node.Next)
-	}
+	binary.LittleEndian.PutUint64(result[2:10], node.Next)
+	offset := PAGE_HEADER_SIZE
 	if !node.IsLeaf {
-		for i := 0; i < ORDER; i++ {
-			binary.LittleEndian.PutUint64(result[10+i*8:10+(i+1)*8], node.Child[i])
+		for i := 0; i < ORDER && i < len(node.Child); i++ {
+			binary.LittleEndian.PutUint64(result[offset+i*8:offset+(i+1)*8], node.Child[i])
+		}
+		offset += PAGE_CHILD_SIZE
+		for i := 0; i < ORDER && i < len(node.Counts); i++ {
+			binary.LittleEndian.PutUint64(result[offset+i*8:offset+(i+1)*8], node.Counts[i])
+		}
+		offset += PAGE_COUNT_SIZE
+		if err := encodeSummaries(result[offset:offset+PAGE_SUMMARY_SIZE], node); err != nil {
+			return nil, err
 		}
+		offset += PAGE_SUMMARY_SIZE
 	}
-	// 2+8+32=42
-	offset := 42
 	for i := 0; i < ORDER-1; i++ {
+		if i >= len(node.Keys) {
+			// node of a tree with smaller order, its missing slots are empty
+			offset += PAGE_ENTRY_SIZE
+			continue
+		}
 		klen := len(node.Keys[i])
 		if klen > BTREE_MAX_KEY_SIZE {
 			return nil, fmt.Errorf("key %d has bytes = %d larger than maximum %d", i, klen, BTREE_MAX_KEY_SIZE)
@@ -42,7 +121,7 @@ func EncodeToBytes(node BNode) ([]byte, error) {
 		if node.IsLeaf {
 			vlen = len(node.Values[i])
 			if vlen > BTREE_MAX_VAL_SIZE {
-				return nil, fmt.Errorf("value %d has bytes = %d larger than maximum %d", i, vlen, BTREE_MAX_KEY_SIZE)
+				return nil, fmt.Errorf("value %d has bytes = %d larger than maximum %d", i, vlen, BTREE_MAX_VAL_SIZE)
 			}
 		}
 		binary.LittleEndian.PutUint16(result[offset:offset+2], uint16(klen))
@@ -53,12 +132,23 @@ func EncodeToBytes(node BNode) ([]byte, error) {
 		if node.IsLeaf {
 			copy(result[offset+4+klen:offset+4+klen+vlen], node.Values[i])
 		}
-		offset += 4 + klen + vlen
+		offset += PAGE_ENTRY_SIZE + klen + vlen
 	}
+	putChecksum(result)
 	return result, nil
 }
 
+// Decode a page encoded by `EncodeToBytes`, returns an error instead of a node if the page is corrupted
 func DecodeToBNode(pageData []byte) (*BNode, error) {
+	if len(pageData) != BTREE_PAGE_SIZE {
+		return nil, fmt.Errorf("page has bytes = %d, expected %d", len(pageData), BTREE_PAGE_SIZE)
+	}
+	if err := verifyChecksum(pageData); err != nil {
+		return nil, err
+	}
+	if pageData[0] > 1 {
+		return nil, fmt.Errorf("page type %d is not a node", pageData[0])
+	}
 	node := BNode{
 		Keys:    make([]Data, ORDER-1),
 		IsLeaf:  false,
@@ -67,29 +157,50 @@ func DecodeToBNode(pageData []byte) (*BNode, error) {
 	}
 	node.IsLeaf = (pageData[0] != 0)
 	node.NumKeys = pageData[1]
-	if node.IsLeaf {
-		node.Next = binary.LittleEndian.Uint64(pageData[2:10])
+	if node.NumKeys > ORDER-1 {
+		return nil, fmt.Errorf("page has %d keys larger than maximum %d", node.NumKeys, ORDER-1)
 	}
+	node.Next = binary.LittleEndian.Uint64(pageData[2:10])
+	// content must end before checksum
+	end := BTREE_PAGE_SIZE - PAGE_CHECKSUM_SIZE
+	offset := PAGE_HEADER_SIZE
 	// child
 	if !node.IsLeaf {
 		node.Child = make([]uint64, ORDER)
 		for i := 0; i < ORDER; i++ {
-			node.Child[i] = binary.LittleEndian.Uint64(pageData[10+i*8 : 10+(i+1)*8])
+			node.Child[i] = binary.LittleEndian.Uint64(pageData[offset+i*8 : offset+(i+1)*8])
 		}
+		offset += PAGE_CHILD_SIZE
+		node.Counts = make([]uint64, ORDER)
+		for i := 0; i < ORDER; i++ {
+			node.Counts[i] = binary.LittleEndian.Uint64(pageData[offset+i*8 : offset+(i+1)*8])
+		}
+		offset += PAGE_COUNT_SIZE
+		var err error
+		if node.Summaries, err = decodeSummaries(pageData[offset : offset+PAGE_SUMMARY_SIZE]); err != nil {
+			return nil, err
+		}
+		offset += PAGE_SUMMARY_SIZE
 	} else {
 		node.Values = make([]Data, ORDER-1)
 	}
-	// 2+8+32=42
-	offset := 42
 	for i := 0; i < ORDER-1; i++ {
+		if offset+PAGE_ENTRY_SIZE > end {
+			return nil, fmt.Errorf("key %d is out of page", i)
+		}
 		klen := int(binary.LittleEndian.Uint16(pageData[offset : offset+2]))
-		node.Keys[i] = pageData[offset+4 : offset+4+klen]
 		vlen := 0
 		if node.IsLeaf {
 			vlen = int(binary.LittleEndian.Uint16(pageData[offset+2 : offset+4]))
+		}
+		if offset+PAGE_ENTRY_SIZE+klen+vlen > end {
+			return nil, fmt.Errorf("key %d has bytes = %d and value has bytes = %d out of page", i, klen, vlen)
+		}
+		node.Keys[i] = pageData[offset+4 : offset+4+klen]
+		if node.IsLeaf {
 			node.Values[i] = pageData[offset+4+klen : offset+4+klen+vlen]
 		}
-		offset += 4 + klen + vlen
+		offset += PAGE_ENTRY_SIZE + klen + vlen
 	}
 	return &node, nil
 }
@@ -18,10 +18,12 @@ func TestEncodeToBytesOfLeaf(t *testing.T) {
 
 	expected[1] = 1
 	copy(expected[2:10], []byte{46, 22, 0, 0, 0, 0, 0, 0})
-	copy(expected[42:44], []byte{2, 0})
-	copy(expected[44:46], []byte{3, 0})
-	copy(expected[46:48], []byte{10, 20})
-	copy(expected[48:51], []byte{34, 12, 47})
+	copy(expected[10:12], []byte{2, 0})
+	copy(expected[12:14], []byte{3, 0})
+	copy(expected[14:16], []byte{10, 20})
+	copy(expected[16:19], []byte{34, 12, 47})
+
+	putChecksum(expected)
 
 	bytesArr, err := EncodeToBytes(*leaf)
 	assert.Nil(t, err)
@@ -40,14 +42,21 @@ func TestEncodeToBytesOfNode(t *testing.T) {
 	expected[0] = 0
 
 	node.insertToInternalNode([]byte{32, 3}, 0, 943, 342)
+	node.Counts[0] = 5
+	node.Counts[1] = 263
 
 	expected[1] = 1
 
 	copy(expected[10:18], []byte{175, 3, 0, 0, 0, 0, 0, 0})
 	copy(expected[18:26], []byte{86, 1, 0, 0, 0, 0, 0, 0})
 
-	copy(expected[42:44], []byte{2, 0})
-	copy(expected[46:48], []byte{32, 3})
+	copy(expected[42:50], []byte{5, 0, 0, 0, 0, 0, 0, 0})
+	copy(expected[50:58], []byte{7, 1, 0, 0, 0, 0, 0, 0})
+
+	copy(expected[339:341], []byte{2, 0})
+	copy(expected[343:345], []byte{32, 3})
+
+	putChecksum(expected)
 
 	bytesArr, err := EncodeToBytes(*node)
 	assert.Nil(t, err)
@@ -147,3 +156,134 @@ func TestDecodeToBNodeOfNode(t *testing.T) {
 	assert.EqualValues(t, decodedNode.Child[2], 41)
 	assert.EqualValues(t, decodedNode.Child[3], 1468)
 }
+
+func TestDecodeToBNodeWithSharedPrefixOfLeaf(t *testing.T) {
+
+	leaf := newLeaf(ORDER)
+	leaf.Next = 42
+
+	prefix := make(Data, BTREE_MAX_KEY_SIZE-1)
+	rand.Read(prefix)
+	k1 := append(append(Data{}, prefix...), 1)
+	k2 := append(append(Data{}, prefix...), 2)
+	k3 := append(Data{}, prefix[:10]...)
+	v1 := make(Data, BTREE_MAX_VAL_SIZE)
+	rand.Read(v1)
+
+	leaf.insertToLeafNode(k1, v1)
+	leaf.insertToLeafNode(k2, Data{})
+	leaf.insertToLeafNode(k3, Data{3})
+
+	encodedBytes, err := EncodeToBytes(*leaf)
+	assert.Nil(t, err)
+
+	decodedNode, err := DecodeToBNode(encodedBytes)
+	assert.Nil(t, err)
+	assert.EqualValues(t, decodedNode.NumKeys, 3)
+	assert.True(t, decodedNode.IsLeaf)
+	assert.EqualValues(t, decodedNode.Next, 42)
+	assert.EqualValues(t, decodedNode.Keys[0], k3)
+	assert.EqualValues(t, decodedNode.Values[0], Data{3})
+	assert.EqualValues(t, decodedNode.Keys[1], k1)
+	assert.EqualValues(t, decodedNode.Values[1], v1)
+	assert.EqualValues(t, decodedNode.Keys[2], k2)
+	assert.EqualValues(t, decodedNode.Values[2], Data{})
+
+	// whole first key is the common prefix
+	leaf = newLeaf(ORDER)
+	leaf.insertToLeafNode(Data("tenant/2026-10-17"), Data{1})
+	leaf.insertToLeafNode(Data("tenant/2026-10-17/a"), Data{2})
+
+	encodedBytes, err = EncodeToBytes(*leaf)
+	assert.Nil(t, err)
+	decodedNode, err = DecodeToBNode(encodedBytes)
+	assert.Nil(t, err)
+	assert.EqualValues(t, decodedNode.NumKeys, 2)
+	assert.EqualValues(t, decodedNode.Keys[0], Data("tenant/2026-10-17"))
+	assert.EqualValues(t, decodedNode.Keys[1], Data("tenant/2026-10-17/a"))
+	assert.EqualValues(t, decodedNode.Keys[2], Data{})
+	assert.EqualValues(t, decodedNode.Values[2], Data{})
+}
+
+func TestDecodeToBNodeWithSharedPrefixOfNode(t *testing.T) {
+
+	node := newNode(ORDER)
+
+	node.insertToInternalNode(Data("tenant/2026-10-17/c"), 0, 234, 1468)
+	node.insertToInternalNode(Data("tenant/2026-10-17/b"), 0, 654, 41)
+	node.insertToInternalNode(Data("tenant/2026-10-16"), 0, 9232, 347)
+
+	encodedBytes, err := EncodeToBytes(*node)
+	assert.Nil(t, err)
+
+	decodedNode, err := DecodeToBNode(encodedBytes)
+	assert.Nil(t, err)
+	assert.EqualValues(t, decodedNode.NumKeys, 3)
+	assert.False(t, decodedNode.IsLeaf)
+	assert.EqualValues(t, decodedNode.Keys[0], Data("tenant/2026-10-16"))
+	assert.EqualValues(t, decodedNode.Keys[1], Data("tenant/2026-10-17/b"))
+	assert.EqualValues(t, decodedNode.Keys[2], Data("tenant/2026-10-17/c"))
+	assert.EqualValues(t, decodedNode.Child, []uint64{9232, 347, 41, 1468})
+}
+
+func TestDecodeToBNodeWithSummaries(t *testing.T) {
+	node := newNode(ORDER)
+	node.insertToInternalNode([]byte{32, 3}, 0, 943, 342)
+	encodedBytes, err := EncodeToBytes(*node)
+	assert.Nil(t, err)
+	decodedNode, err := DecodeToBNode(encodedBytes)
+	assert.Nil(t, err)
+	assert.Equal(t, make([]Data, ORDER), decodedNode.Summaries)
+
+	node.Summaries[0] = EncodeInt64(7)
+	node.Summaries[1] = Data{}
+	encodedBytes, err = EncodeToBytes(*node)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, encodedBytes[74])
+	decodedNode, err = DecodeToBNode(encodedBytes)
+	assert.Nil(t, err)
+	assert.EqualValues(t, EncodeInt64(7), decodedNode.Summaries[0])
+	assert.EqualValues(t, Data{}, decodedNode.Summaries[1])
+	assert.EqualValues(t, Data{0x20, 0x03}, decodedNode.Keys[0])
+
+	node.Summaries[1] = make(Data, BTREE_MAX_SUMMARY_SIZE+1)
+	_, err = EncodeToBytes(*node)
+	assert.NotNil(t, err)
+}
+
+func TestDecodeToBNodeOfCorruptedPage(t *testing.T) {
+	leaf := newLeaf(ORDER)
+	leaf.insertToLeafNode([]byte{10, 20}, []byte{34, 12, 47})
+	encodedBytes, err := EncodeToBytes(*leaf)
+	assert.Nil(t, err)
+
+	_, err = DecodeToBNode(encodedBytes[:100])
+	assert.NotNil(t, err)
+
+	// checksum mismatch
+	corrupted := append([]byte{}, encodedBytes...)
+	corrupted[17] ^= 1
+	_, err = DecodeToBNode(corrupted)
+	assert.NotNil(t, err)
+
+	// valid checksum but not a node
+	corrupted = append([]byte{}, encodedBytes...)
+	corrupted[0] = 7
+	putChecksum(corrupted)
+	_, err = DecodeToBNode(corrupted)
+	assert.NotNil(t, err)
+
+	// valid checksum but too many keys
+	corrupted = append([]byte{}, encodedBytes...)
+	corrupted[1] = ORDER
+	putChecksum(corrupted)
+	_, err = DecodeToBNode(corrupted)
+	assert.NotNil(t, err)
+
+	// valid checksum but length out of page
+	corrupted = append([]byte{}, encodedBytes...)
+	copy(corrupted[12:14], []byte{255, 255})
+	putChecksum(corrupted)
+	_, err = DecodeToBNode(corrupted)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,144 @@
+package bplustree
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*
+*
+Sealed page = an encoded page encrypted with AES-GCM
+
+| Counter | Ciphertext                  | Tag  |
+| 8B      | BTREE_PAGE_SIZE B           | 16B  |
+
+nonce (12B) = uint32(page number) + counter, additional data = page number (8B)
+*
+*/
+
+const (
+	SEALED_COUNTER_SIZE = 8
+	SEALED_TAG_SIZE     = 16
+	SEALED_PAGE_SIZE    = SEALED_COUNTER_SIZE + BTREE_PAGE_SIZE + SEALED_TAG_SIZE
+)
+
+// PageCipher encrypts and authenticates pages with a caller-supplied AES key
+type PageCipher struct {
+	aead cipher.AEAD
+}
+
+// Create a cipher from `key`, which must be 16, 24 or 32 bytes to select AES-128, AES-192 or AES-256
+func NewPageCipher(key []byte) (*PageCipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &PageCipher{aead: aead}, nil
+}
+
+// nonce must never repeat for one key, so it is derived from page number and a counter bumped on every write of that page
+func (c *PageCipher) nonce(pageNum uint64, counter uint64) ([]byte, error) {
+	if pageNum > math.MaxUint32 {
+		return nil, fmt.Errorf("page %d exceeds maximum encrypted page number %d", pageNum, uint32(math.MaxUint32))
+	}
+	nonce := make([]byte, c.aead.NonceSize())
+	binary.LittleEndian.PutUint32(nonce[0:4], uint32(pageNum))
+	binary.LittleEndian.PutUint64(nonce[4:12], counter)
+	return nonce, nil
+}
+
+// Encrypt `page` stored at `pageNum` for the `counter`-th time.
+// Returns:
+//
+//	[]byte: sealed page with SEALED_PAGE_SIZE bytes
+func (c *PageCipher) Seal(pageNum uint64, counter uint64, page []byte) ([]byte, error) {
+	if len(page) != BTREE_PAGE_SIZE {
+		return nil, fmt.Errorf("page has bytes = %d, expected %d", len(page), BTREE_PAGE_SIZE)
+	}
+	nonce, err := c.nonce(pageNum, counter)
+	if err != nil {
+		return nil, err
+	}
+	additional := make([]byte, 8)
+	binary.LittleEndian.PutUint64(additional, pageNum)
+
+	result := make([]byte, SEALED_COUNTER_SIZE, SEALED_PAGE_SIZE)
+	binary.LittleEndian.PutUint64(result[0:SEALED_COUNTER_SIZE], counter)
+	return c.aead.Seal(result, nonce, page, additional), nil
+}
+
+// Decrypt a sealed page stored at `pageNum`. A page which was tampered with, or moved from another page number,
+// fails authentication and returns an error.
+// Returns:
+//
+//	[]byte: decrypted page
+//	uint64: write counter of the page
+func (c *PageCipher) Open(pageNum uint64, sealed []byte) ([]byte, uint64, error) {
+	if len(sealed) != SEALED_PAGE_SIZE {
+		return nil, 0, fmt.Errorf("sealed page %d has bytes = %d, expected %d", pageNum, len(sealed), SEALED_PAGE_SIZE)
+	}
+	counter := binary.LittleEndian.Uint64(sealed[0:SEALED_COUNTER_SIZE])
+	nonce, err := c.nonce(pageNum, counter)
+	if err != nil {
+		return nil, 0, err
+	}
+	additional := make([]byte, 8)
+	binary.LittleEndian.PutUint64(additional, pageNum)
+
+	page, err := c.aead.Open(nil, nonce, sealed[SEALED_COUNTER_SIZE:], additional)
+	if err != nil {
+		return nil, 0, fmt.Errorf("sealed page %d failed authentication: %w", pageNum, err)
+	}
+	return page, counter, nil
+}
+
+// Encode `node` and encrypt it as page `pageNum`
+func EncodeToSealedBytes(c *PageCipher, node BNode, pageNum uint64, counter uint64) ([]byte, error) {
+	page, err := EncodeToBytes(node)
+	if err != nil {
+		return nil, err
+	}
+	return c.Seal(pageNum, counter, page)
+}
+
+// Decrypt page `pageNum` and decode it into a node
+func DecodeSealedToBNode(c *PageCipher, pageNum uint64, sealed []byte) (*BNode, error) {
+	page, _, err := c.Open(pageNum, sealed)
+	if err != nil {
+		return nil, err
+	}
+	return DecodeToBNode(page)
+}
+
+// Rotate the key of `totalPages` sealed pages: every page is read from `src` and decrypted with `oldCipher`,
+// then encrypted with `newCipher` and written to `dst` at the same offset.
+// `src` and `dst` may be the same file, since each page is rewritten in place.
+func RotateKey(src io.ReaderAt, dst io.WriterAt, totalPages uint64, oldCipher *PageCipher, newCipher *PageCipher) error {
+	sealed := make([]byte, SEALED_PAGE_SIZE)
+	for pageNum := uint64(0); pageNum < totalPages; pageNum++ {
+		offset := int64(pageNum) * SEALED_PAGE_SIZE
+		if _, err := src.ReadAt(sealed, offset); err != nil {
+			return fmt.Errorf("read page %d: %w", pageNum, err)
+		}
+		page, counter, err := oldCipher.Open(pageNum, sealed)
+		if err != nil {
+			return err
+		}
+		resealed, err := newCipher.Seal(pageNum, counter+1, page)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.WriteAt(resealed, offset); err != nil {
+			return fmt.Errorf("write page %d: %w", pageNum, err)
+		}
+	}
+	return nil
+}
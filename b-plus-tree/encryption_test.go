@@ -0,0 +1,137 @@
+package bplustree
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCipher(t *testing.T, seed byte) *PageCipher {
+	key := bytes.Repeat([]byte{seed}, 32)
+	c, err := NewPageCipher(key)
+	assert.Nil(t, err)
+	return c
+}
+
+func TestNewPageCipher(t *testing.T) {
+	_, err := NewPageCipher(make([]byte, 16))
+	assert.Nil(t, err)
+	_, err = NewPageCipher(make([]byte, 32))
+	assert.Nil(t, err)
+	_, err = NewPageCipher(make([]byte, 7))
+	assert.NotNil(t, err)
+}
+
+func TestSealAndOpen(t *testing.T) {
+	c := newTestCipher(t, 1)
+	page := make([]byte, BTREE_PAGE_SIZE)
+	copy(page, []byte("customer pii"))
+
+	sealed, err := c.Seal(3, 7, page)
+	assert.Nil(t, err)
+	assert.Equal(t, len(sealed), SEALED_PAGE_SIZE)
+	assert.False(t, bytes.Contains(sealed, []byte("customer pii")))
+
+	opened, counter, err := c.Open(3, sealed)
+	assert.Nil(t, err)
+	assert.EqualValues(t, counter, 7)
+	assert.EqualValues(t, opened, page)
+
+	// same page written again must use a different nonce
+	resealed, err := c.Seal(3, 8, page)
+	assert.Nil(t, err)
+	assert.NotEqual(t, sealed[SEALED_COUNTER_SIZE:], resealed[SEALED_COUNTER_SIZE:])
+
+	// wrong page size
+	_, err = c.Seal(3, 7, page[:10])
+	assert.NotNil(t, err)
+	_, _, err = c.Open(3, sealed[:10])
+	assert.NotNil(t, err)
+}
+
+func TestOpenDetectsTampering(t *testing.T) {
+	c := newTestCipher(t, 1)
+	sealed, err := c.Seal(5, 1, make([]byte, BTREE_PAGE_SIZE))
+	assert.Nil(t, err)
+
+	// flipped bit in ciphertext
+	tampered := append([]byte{}, sealed...)
+	tampered[100] ^= 1
+	_, _, err = c.Open(5, tampered)
+	assert.NotNil(t, err)
+
+	// modified counter
+	tampered = append([]byte{}, sealed...)
+	tampered[0] ^= 1
+	_, _, err = c.Open(5, tampered)
+	assert.NotNil(t, err)
+
+	// page moved to another position
+	_, _, err = c.Open(6, sealed)
+	assert.NotNil(t, err)
+
+	// another key
+	_, _, err = newTestCipher(t, 2).Open(5, sealed)
+	assert.NotNil(t, err)
+}
+
+func TestSealedNodeRoundTrip(t *testing.T) {
+	c := newTestCipher(t, 1)
+	leaf := newLeaf(ORDER)
+	leaf.insertToLeafNode([]byte{10, 20}, []byte{34, 12, 47})
+	leaf.Next = 5678
+
+	sealed, err := EncodeToSealedBytes(c, *leaf, 9, 1)
+	assert.Nil(t, err)
+
+	decodedNode, err := DecodeSealedToBNode(c, 9, sealed)
+	assert.Nil(t, err)
+	assert.True(t, decodedNode.IsLeaf)
+	assert.EqualValues(t, decodedNode.NumKeys, 1)
+	assert.EqualValues(t, decodedNode.Next, 5678)
+	assert.EqualValues(t, decodedNode.Keys[0], []byte{10, 20})
+	assert.EqualValues(t, decodedNode.Values[0], []byte{34, 12, 47})
+
+	sealed[SEALED_PAGE_SIZE-1] ^= 1
+	_, err = DecodeSealedToBNode(c, 9, sealed)
+	assert.NotNil(t, err)
+}
+
+func TestRotateKey(t *testing.T) {
+	oldCipher := newTestCipher(t, 1)
+	newCipher := newTestCipher(t, 2)
+
+	path := filepath.Join(t.TempDir(), "pages")
+	file, err := os.Create(path)
+	assert.Nil(t, err)
+	defer file.Close()
+
+	pages := make([][]byte, 3)
+	for i := range pages {
+		pages[i] = bytes.Repeat([]byte{byte(i + 1)}, BTREE_PAGE_SIZE)
+		sealed, err := oldCipher.Seal(uint64(i), 1, pages[i])
+		assert.Nil(t, err)
+		_, err = file.WriteAt(sealed, int64(i)*SEALED_PAGE_SIZE)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, RotateKey(file, file, uint64(len(pages)), oldCipher, newCipher))
+
+	sealed := make([]byte, SEALED_PAGE_SIZE)
+	for i := range pages {
+		_, err = file.ReadAt(sealed, int64(i)*SEALED_PAGE_SIZE)
+		assert.Nil(t, err)
+		_, _, err = oldCipher.Open(uint64(i), sealed)
+		assert.NotNil(t, err)
+		page, counter, err := newCipher.Open(uint64(i), sealed)
+		assert.Nil(t, err)
+		assert.EqualValues(t, counter, 2)
+		assert.EqualValues(t, page, pages[i])
+	}
+
+	// pages are no longer readable with the old key
+	assert.NotNil(t, RotateKey(file, file, uint64(len(pages)), oldCipher, newCipher))
+}
@@ -0,0 +1,289 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+*
+A secondary index is a tree whose keys are entries of its secondary values, each one points to a primary key:
+
+| slen | secondary | primary key
+| 2B   | slen B    |
+
+slen: BigEndian, so every entry of a secondary value is in one range of keys, value of an entry is empty
+*
+*/
+
+const (
+	INDEX_SECONDARY_LEN_SIZE = 2
+	// name of a named tree of `Pager` which holds an index is this prefix followed by name of the index
+	INDEX_TREE_PREFIX = "index:"
+)
+
+// Extractor returns secondary values of a primary key / value pair, it returns nil if the pair is not indexed
+type Extractor func(key Data, value Data) []Data
+
+type secondaryIndex struct {
+	tree    *BTree
+	extract Extractor
+}
+
+// IndexedStore owns a primary `BTree` and its secondary indexes, every write of the primary tree updates each index
+// before it returns, so they stay consistent. Every tree must be changed only through `IndexedStore`.
+// A store of `OpenIndexedStore` keeps every tree in one `Pager`, and `Commit` writes them in a single commit, so a
+// crash leaves either every tree before a commit or every tree after it. A store of `OpenTxIndexedStore` keeps every
+// tree in a transaction, so they are committed or rolled back with it. Trees of `NewIndexedStore` are committed by
+// the caller
+type IndexedStore struct {
+	primary *BTree
+	indexes map[string]*secondaryIndex
+	pager   *Pager // pager of every tree, nil if trees are not in a pager
+	tx      *Tx    // transaction of every tree, nil if trees are not in a transaction
+}
+
+func NewIndexedStore(primary *BTree) *IndexedStore {
+	return &IndexedStore{primary: primary, indexes: map[string]*secondaryIndex{}}
+}
+
+// Store whose primary tree is the default tree of `pager`, open its indexes by `OpenIndex`
+func OpenIndexedStore(pager *Pager) *IndexedStore {
+	s := NewIndexedStore(pager.Tree())
+	s.pager = pager
+	return s
+}
+
+// Store whose primary tree is the tree of `tx`, open its indexes by `OpenIndex`. The store must not be used after
+// the transaction ends.
+// Returns `ErrTxBuckets` if the tree of `tx` stores buckets
+func OpenTxIndexedStore(tx *Tx) (*IndexedStore, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if tx.rootBucket().isMarked() {
+		return nil, ErrTxBuckets
+	}
+	s := NewIndexedStore(&tx.tree)
+	s.tx = tx
+	return s, nil
+}
+
+// Open index `name` of a store of `OpenIndexedStore` or `OpenTxIndexedStore`, from named tree
+// `INDEX_TREE_PREFIX` + `name` of its pager or transaction. The tree is created with entries of every pair in
+// primary tree if it does not exist, otherwise `extract` must be the extractor it was created with
+func (s *IndexedStore) OpenIndex(name string, extract Extractor) error {
+	if s.pager == nil && s.tx == nil {
+		return fmt.Errorf("store is not opened from a pager or a transaction")
+	}
+	if _, exists := s.indexes[name]; exists {
+		return fmt.Errorf("index %q already exists", name)
+	}
+	openTree, createTree := s.pager.OpenTree, s.pager.CreateTree
+	if s.tx != nil {
+		openTree, createTree = s.tx.OpenTree, s.tx.CreateTree
+	}
+	if tree, err := openTree(INDEX_TREE_PREFIX + name); err == nil {
+		s.indexes[name] = &secondaryIndex{tree: tree, extract: extract}
+		return nil
+	}
+	tree, err := createTree(INDEX_TREE_PREFIX+name, ORDER)
+	if err != nil {
+		return err
+	}
+	return s.AddIndex(name, tree, extract)
+}
+
+// Write primary tree and every index in one commit of the pager of a store of `OpenIndexedStore`, or commit the
+// transaction of a store of `OpenTxIndexedStore`
+func (s *IndexedStore) Commit() error {
+	if s.tx != nil {
+		return s.tx.Commit()
+	}
+	if s.pager == nil {
+		return fmt.Errorf("store is not opened from a pager or a transaction")
+	}
+	return s.pager.Commit(s.primary.Root)
+}
+
+// Check that a write of `key` can change trees of the store
+func (s *IndexedStore) writeCheck(key Data) error {
+	if s.tx != nil {
+		return s.tx.pairWriteCheck(key)
+	}
+	return nil
+}
+
+// An entry is a key of index tree, so it must fit in a key
+func indexEntryCheck(name string, secondary Data, key Data) error {
+	if size := INDEX_SECONDARY_LEN_SIZE + len(secondary) + len(key); size > BTREE_MAX_KEY_SIZE {
+		return fmt.Errorf("entry of index %q has bytes = %d larger than maximum %d", name, size, BTREE_MAX_KEY_SIZE)
+	}
+	return nil
+}
+
+func indexEntry(secondary Data, key Data) Data {
+	entry := make(Data, 0, INDEX_SECONDARY_LEN_SIZE+len(secondary)+len(key))
+	entry = binary.BigEndian.AppendUint16(entry, uint16(len(secondary)))
+	return append(append(entry, secondary...), key...)
+}
+
+// Prefix of every entry of `secondary`
+func indexPrefix(secondary Data) Data {
+	return indexEntry(secondary, nil)
+}
+
+// Smallest key greater than every key starting with `prefix`, nil if there is none
+func prefixEnd(prefix Data) Data {
+	end := append(Data(nil), prefix...)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i] += 1
+			return end[:i+1]
+		}
+	}
+	return nil
+}
+
+// Secondary values of a pair, every value is returned once
+func (index *secondaryIndex) secondaries(key Data, value Data) []Data {
+	unique := []Data{}
+	for _, secondary := range index.extract(key, value) {
+		duplicated := false
+		for _, other := range unique {
+			duplicated = duplicated || other.eq(secondary)
+		}
+		if !duplicated {
+			unique = append(unique, secondary)
+		}
+	}
+	return unique
+}
+
+// Add an index of `name` stored in `tree`, entries of every pair already in primary tree are added to it.
+// Nothing is added if an entry is too large
+func (s *IndexedStore) AddIndex(name string, tree *BTree, extract Extractor) error {
+	if _, exists := s.indexes[name]; exists {
+		return fmt.Errorf("index %q already exists", name)
+	}
+	index := &secondaryIndex{tree: tree, extract: extract}
+	batch := &Batch{}
+	var err error
+	s.primary.Scan(nil, nil, func(key Data, value Data) bool {
+		for _, secondary := range index.secondaries(key, value) {
+			if err = indexEntryCheck(name, secondary, key); err != nil {
+				return false
+			}
+			batch.Put(indexEntry(secondary, key), Data{})
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+	tree.ApplyBatch(batch)
+	s.indexes[name] = index
+	return nil
+}
+
+func (s *IndexedStore) Search(key Data) (Data, bool) {
+	return s.primary.Search(key)
+}
+
+func (s *IndexedStore) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	s.primary.Scan(start, end, fn)
+}
+
+// Insert or replace value of `key`, entries of its old value which are not entries of the new value are removed.
+// Sizes of `key`, `value` and every new entry are checked before any tree is changed
+func (s *IndexedStore) Insert(key Data, value Data) error {
+	if err := s.writeCheck(key); err != nil {
+		return err
+	}
+	if len(key) > BTREE_MAX_KEY_SIZE || len(value) > BTREE_MAX_VAL_SIZE {
+		return fmt.Errorf("key has bytes = %d and value has bytes = %d larger than maximum %d and %d",
+			len(key), len(value), BTREE_MAX_KEY_SIZE, BTREE_MAX_VAL_SIZE)
+	}
+	newSecondaries := map[string][]Data{}
+	for name, index := range s.indexes {
+		newSecondaries[name] = index.secondaries(key, value)
+		for _, secondary := range newSecondaries[name] {
+			if err := indexEntryCheck(name, secondary, key); err != nil {
+				return err
+			}
+		}
+	}
+	s.primary.Update(key, func(old Data, exists bool) (Data, bool) {
+		for name, index := range s.indexes {
+			var oldSecondaries []Data
+			if exists {
+				oldSecondaries = index.secondaries(key, old)
+			}
+			index.replace(key, oldSecondaries, newSecondaries[name])
+		}
+		return value, true
+	})
+	return nil
+}
+
+func (s *IndexedStore) Delete(key Data) (bool, error) {
+	if err := s.writeCheck(key); err != nil {
+		return false, err
+	}
+	deleted := false
+	s.primary.Update(key, func(old Data, exists bool) (Data, bool) {
+		if exists {
+			for _, index := range s.indexes {
+				index.replace(key, index.secondaries(key, old), nil)
+			}
+		}
+		deleted = exists
+		return nil, false
+	})
+	return deleted, nil
+}
+
+// Replace entries of `key` for `oldSecondaries` by entries for `newSecondaries`, entries in both are kept
+func (index *secondaryIndex) replace(key Data, oldSecondaries []Data, newSecondaries []Data) {
+	contains := func(secondaries []Data, secondary Data) bool {
+		for _, other := range secondaries {
+			if other.eq(secondary) {
+				return true
+			}
+		}
+		return false
+	}
+	batch := &Batch{}
+	for _, secondary := range oldSecondaries {
+		if !contains(newSecondaries, secondary) {
+			batch.Delete(indexEntry(secondary, key))
+		}
+	}
+	for _, secondary := range newSecondaries {
+		if !contains(oldSecondaries, secondary) {
+			batch.Put(indexEntry(secondary, key), Data{})
+		}
+	}
+	index.tree.ApplyBatch(batch)
+}
+
+// Call `fn` for every pair of primary tree with `secondary` in index `name`, in ascending order of primary keys,
+// until `fn` returns false
+func (s *IndexedStore) Lookup(name string, secondary Data, fn func(key Data, value Data) bool) error {
+	index, exists := s.indexes[name]
+	if !exists {
+		return fmt.Errorf("index %q does not exist", name)
+	}
+	prefix := indexPrefix(secondary)
+	for it := index.tree.Iterator(prefix, prefixEnd(prefix)); it.Next(); {
+		key := it.Key()[len(prefix):]
+		value, found := s.primary.Search(key)
+		if !found {
+			continue
+		}
+		if !fn(key, value) {
+			return nil
+		}
+	}
+	return nil
+}
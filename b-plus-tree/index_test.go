@@ -0,0 +1,266 @@
+package bplustree
+
+import (
+	"bytes"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Primary keys of pairs with `secondary` in index `name`
+func lookupKeys(t *testing.T, s *IndexedStore, name string, secondary Data) []Data {
+	keys := []Data{}
+	assert.Nil(t, s.Lookup(name, secondary, func(key Data, value Data) bool {
+		keys = append(keys, key)
+		return true
+	}))
+	return keys
+}
+
+func TestPrefixEnd(t *testing.T) {
+	assert.Equal(t, Data{1, 3}, prefixEnd(Data{1, 2}))
+	assert.Equal(t, Data{2}, prefixEnd(Data{1, 0xff}))
+	assert.Nil(t, prefixEnd(Data{0xff, 0xff}))
+}
+
+func TestIndexedStore(t *testing.T) {
+	primary := newC(t, 4)
+	byLength, byByte := newC(t, 4), newC(t, 3)
+	s := NewIndexedStore(&primary.tree)
+	assert.Nil(t, s.Insert(Data("k1"), Data("aa")))
+	assert.Nil(t, s.Insert(Data("k2"), Data("b")))
+
+	// an index added later has entries of existing pairs
+	assert.Nil(t, s.AddIndex("length", &byLength.tree, func(key Data, value Data) []Data {
+		return []Data{{byte(len(value))}}
+	}))
+	assert.NotNil(t, s.AddIndex("length", &byLength.tree, nil))
+	// every distinct byte of value, a secondary value of a prefix of another one must not match it
+	assert.Nil(t, s.AddIndex("byte", &byByte.tree, func(key Data, value Data) []Data {
+		secondaries := []Data{}
+		for i := range value {
+			secondaries = append(secondaries, value[i:i+1], value[:i+1])
+		}
+		return secondaries
+	}))
+
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "byte", Data("a")))
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "byte", Data("aa")))
+
+	assert.Nil(t, s.Insert(Data("k2"), Data("ab")))
+	assert.Nil(t, s.Insert(Data("k3"), Data("c")))
+	assert.Equal(t, []Data{Data("k1"), Data("k2")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Equal(t, []Data{Data("k3")}, lookupKeys(t, s, "length", Data{1}))
+	assert.Equal(t, []Data{Data("k1"), Data("k2")}, lookupKeys(t, s, "byte", Data("a")))
+	assert.Equal(t, []Data{Data("k2")}, lookupKeys(t, s, "byte", Data("b")))
+
+	deleted, err := s.Delete(Data("k1"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	deleted, err = s.Delete(Data("k1"))
+	assert.Nil(t, err)
+	assert.False(t, deleted)
+	assert.Equal(t, []Data{Data("k2")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Equal(t, []Data{}, lookupKeys(t, s, "byte", Data("aa")))
+
+	assert.NotNil(t, s.Lookup("missing", Data{1}, func(key Data, value Data) bool { return true }))
+}
+
+func TestIndexedStoreConsistent(t *testing.T) {
+	primary, index := newC(t, 4), newC(t, 4)
+	s := NewIndexedStore(&primary.tree)
+	// secondary values are value bytes of each pair, with many pairs sharing one
+	assert.Nil(t, s.AddIndex("bytes", &index.tree, func(key Data, value Data) []Data {
+		secondaries := []Data{}
+		for i := range value {
+			secondaries = append(secondaries, value[i:i+1])
+		}
+		return secondaries
+	}))
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		key := createBigEndianData(uint16(random.Intn(200)))
+		if random.Intn(4) == 0 {
+			_, err := s.Delete(key)
+			assert.Nil(t, err)
+		} else {
+			assert.Nil(t, s.Insert(key, Data{byte(random.Intn(10)), byte(random.Intn(10))}))
+		}
+	}
+	assert.Empty(t, index.tree.Validate())
+
+	// every entry points to a pair which has its secondary value, and every pair has its entries
+	entries := 0
+	for secondary := byte(0); secondary < 10; secondary++ {
+		assert.Nil(t, s.Lookup("bytes", Data{secondary}, func(key Data, value Data) bool {
+			assert.True(t, bytes.IndexByte(value, secondary) >= 0)
+			entries += 1
+			return true
+		}))
+	}
+	expected := 0
+	s.Scan(nil, nil, func(key Data, value Data) bool {
+		expected += 1
+		if value[0] != value[1] {
+			expected += 1
+		}
+		return true
+	})
+	assert.Equal(t, expected, entries)
+	assert.Equal(t, uint64(expected), index.tree.Len())
+}
+
+func TestIndexedStoreCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	length := func(key Data, value Data) []Data { return []Data{{byte(len(value))}} }
+	pager := openTestPager(t, path, nil)
+	s := OpenIndexedStore(pager)
+	assert.Nil(t, s.Insert(Data("k1"), Data("aa")))
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.NotNil(t, s.OpenIndex("length", length))
+	assert.Nil(t, s.Insert(Data("k2"), Data("b")))
+	assert.Nil(t, s.Commit())
+	assert.Equal(t, []string{INDEX_TREE_PREFIX + "length"}, pager.ListTrees())
+
+	// writes after the commit are discarded together
+	assert.Nil(t, s.Insert(Data("k3"), Data("c")))
+	deleted, err := s.Delete(Data("k1"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	s = OpenIndexedStore(pager)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Equal(t, []Data{Data("k2")}, lookupKeys(t, s, "length", Data{1}))
+	assert.Nil(t, s.Insert(Data("k3"), Data("c")))
+	assert.Nil(t, s.Commit())
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	s = OpenIndexedStore(pager)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Equal(t, []Data{Data("k2"), Data("k3")}, lookupKeys(t, s, "length", Data{1}))
+	assert.Nil(t, pager.Close())
+
+	assert.NotNil(t, NewIndexedStore(&newC(t, 4).tree).Commit())
+}
+
+func TestIndexedStoreTornCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	length := func(key Data, value Data) []Data { return []Data{{byte(len(value))}} }
+	pager := openTestPager(t, path, nil)
+	s := OpenIndexedStore(pager)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Nil(t, s.Insert(Data("k1"), Data("aa")))
+	assert.Nil(t, s.Commit())
+
+	// crash after journal is flushed, when only part of the pages are written
+	assert.Nil(t, s.Insert(Data("k2"), Data("b")))
+	deleted, err := s.Delete(Data("k1"))
+	assert.Nil(t, err)
+	assert.True(t, deleted)
+	pages, err := pager.commitPages(s.primary.Root)
+	assert.Nil(t, err)
+	assert.Nil(t, pager.writeJournal(pages))
+	written := 0
+	for ptr, page := range pages {
+		if written*2 < len(pages) {
+			assert.Nil(t, pager.writePage(ptr, page))
+			written++
+		}
+	}
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	s = OpenIndexedStore(pager)
+	assert.Nil(t, s.OpenIndex("length", length))
+	val, ok := s.Search(Data("k1"))
+	assert.True(t, ok)
+	assert.Equal(t, Data("aa"), val)
+	_, ok = s.Search(Data("k2"))
+	assert.False(t, ok)
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Empty(t, lookupKeys(t, s, "length", Data{1}))
+	assert.Nil(t, pager.Close())
+}
+
+func TestIndexedStoreEntrySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	value := func(key Data, value Data) []Data { return []Data{value} }
+	pager := openTestPager(t, path, nil)
+	s := OpenIndexedStore(pager)
+	assert.Nil(t, s.OpenIndex("value", value))
+	assert.Nil(t, s.Insert(Data("k1"), Data("v")))
+
+	// entry of a large key and a large secondary value does not fit in a key of index tree
+	key := Data(bytes.Repeat([]byte{'k'}, 300))
+	assert.NotNil(t, s.Insert(key, Data(bytes.Repeat([]byte{'v'}, 200))))
+	_, found := s.Search(key)
+	assert.False(t, found)
+	assert.NotNil(t, s.Insert(Data("k2"), Data(bytes.Repeat([]byte{'v'}, BTREE_MAX_VAL_SIZE+1))))
+	assert.Nil(t, s.Commit())
+	assert.Nil(t, s.Insert(key, Data("v")))
+	assert.Nil(t, s.Commit())
+	assert.Equal(t, []Data{Data("k1"), key}, lookupKeys(t, s, "value", Data("v")))
+
+	// an index whose entries do not fit is not added
+	tooLarge := func(key Data, value Data) []Data { return []Data{bytes.Repeat([]byte{'s'}, 100)} }
+	assert.NotNil(t, s.AddIndex("large", &newC(t, 4).tree, tooLarge))
+	assert.NotNil(t, s.Lookup("large", Data("s"), func(key Data, value Data) bool { return true }))
+	assert.Nil(t, pager.Close())
+}
+
+func TestTxIndexedStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	length := func(key Data, value Data) []Data { return []Data{{byte(len(value))}} }
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	s, err := OpenTxIndexedStore(tx)
+	assert.Nil(t, err)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Nil(t, s.Insert(Data("k1"), Data("aa")))
+	assert.NotNil(t, s.Insert(Data{}, Data("a")))
+	assert.Nil(t, s.Commit())
+
+	// pairs and their entries are rolled back with the transaction
+	tx = db.Begin(true)
+	s, err = OpenTxIndexedStore(tx)
+	assert.Nil(t, err)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Nil(t, s.Insert(Data("k2"), Data("b")))
+	assert.Nil(t, tx.Savepoint("delete"))
+	_, err = s.Delete(Data("k1"))
+	assert.Nil(t, err)
+	assert.Nil(t, tx.RollbackTo("delete"))
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Equal(t, []Data{Data("k2")}, lookupKeys(t, s, "length", Data{1}))
+	tx.Rollback()
+	assert.Equal(t, ErrTxDone, s.Insert(Data("k3"), Data("c")))
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	s, err = OpenTxIndexedStore(tx)
+	assert.Nil(t, err)
+	assert.Nil(t, s.OpenIndex("length", length))
+	assert.Equal(t, []Data{Data("k1")}, lookupKeys(t, s, "length", Data{2}))
+	assert.Empty(t, lookupKeys(t, s, "length", Data{1}))
+	assert.Equal(t, ErrTxReadOnly, s.Insert(Data("k3"), Data("c")))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	// a tree of buckets is not a primary tree
+	db = openTestDB(t, filepath.Join(t.TempDir(), "buckets"))
+	tx = db.Begin(true)
+	_, err = tx.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	_, err = OpenTxIndexedStore(tx)
+	assert.Equal(t, ErrTxBuckets, err)
+	tx.Rollback()
+	assert.Nil(t, db.Close())
+}
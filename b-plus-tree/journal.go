@@ -0,0 +1,204 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+)
+
+/*
+*
+Journal: before a commit overwrites pages of a page file, their old content is saved in a journal file next to it.
+The journal is removed after every page of the commit is flushed to disk, so a journal found on open means a commit
+was interrupted, and old content is written back. Pages are stored as they are in file, sealed if it is encrypted.
+A file opened read-only, or scanned, is read with the saved pages instead, and the journal is kept.
+
+| magic | pageCount | total | page number | page     | ... | page number | page     | checksum
+| 8B    | 8B        | 8B    | 8B          | slotSize |     | 8B          | slotSize | 4B
+
+pageCount: number of pages in file before the commit, pages appended by the commit are truncated
+total: number of saved pages
+
+A page of an encrypted file is sealed with a nonce of its write counter, which must never repeat. An interrupted
+commit has sealed every page it wrote with the saved counter + 1, so the saved content is sealed again with counter
++ 2 instead of written back as it is. Pages appended by the commit were sealed with counter 1, they are kept in file as
+free pages sealed with counter 2, so a later commit which appends them again continues from there. Rolling back is
+deterministic, so a crash while rolling back and rolling back again writes the same sealed pages.
+*
+*/
+
+const (
+	JOURNAL_MAGIC       = "BPJRNL01"
+	JOURNAL_HEADER_SIZE = 8 + 8 + 8
+)
+
+func journalPath(path string) string {
+	return path + "-journal"
+}
+
+// Flush a directory, so a file created or removed in it survives a crash
+func syncDir(path string) error {
+	dir, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Save old content of `pages` which already exist in file, then flush the journal to disk
+func (p *Pager) writeJournal(pages map[uint64][]byte) error {
+	total, err := p.totalPages()
+	if err != nil {
+		return err
+	}
+	slotSize := p.slotSize()
+	journal := make([]byte, JOURNAL_HEADER_SIZE, JOURNAL_HEADER_SIZE+int64(len(pages))*(8+slotSize)+4)
+	copy(journal[0:8], JOURNAL_MAGIC)
+	binary.LittleEndian.PutUint64(journal[8:16], total)
+	var saved uint64
+	for ptr := range pages {
+		if ptr >= total {
+			continue
+		}
+		entry := make([]byte, 8+slotSize)
+		binary.LittleEndian.PutUint64(entry[0:8], ptr)
+		if _, err := p.file.ReadAt(entry[8:], int64(ptr)*slotSize); err != nil {
+			return fmt.Errorf("journal page %d: %w", ptr, err)
+		}
+		journal = append(journal, entry...)
+		saved += 1
+	}
+	binary.LittleEndian.PutUint64(journal[16:24], saved)
+	journal = binary.LittleEndian.AppendUint32(journal, crc32.ChecksumIEEE(journal))
+
+	file, err := os.OpenFile(journalPath(p.path), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err := file.Write(journal); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	return syncDir(p.path)
+}
+
+// Remove journal after a commit is flushed, the commit is durable once the removal is flushed too
+func (p *Pager) removeJournal() error {
+	if err := os.Remove(journalPath(p.path)); err != nil {
+		return err
+	}
+	return syncDir(p.path)
+}
+
+// Content of pages before an interrupted commit, saved in its journal
+type journalPages struct {
+	total uint64            // number of pages in file before the commit
+	slots map[uint64][]byte // saved pages as they are in file
+}
+
+// Saved content of page `ptr`, a nil journal has no page
+func (j *journalPages) saved(ptr uint64) ([]byte, bool) {
+	if j == nil {
+		return nil, false
+	}
+	slot, ok := j.slots[ptr]
+	return slot, ok
+}
+
+// Read journal of page file at `path`.
+// Returns:
+//
+//	*journalPages: nil if there is no journal, or it is not complete, then the page file is not written by the commit
+//	bool: whether a journal file exists
+func readJournal(path string, slotSize int64) (*journalPages, bool, error) {
+	journal, err := os.ReadFile(journalPath(path))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	if len(journal) < JOURNAL_HEADER_SIZE+4 || string(journal[0:8]) != JOURNAL_MAGIC ||
+		crc32.ChecksumIEEE(journal[:len(journal)-4]) != binary.LittleEndian.Uint32(journal[len(journal)-4:]) {
+		return nil, true, nil
+	}
+	saved := binary.LittleEndian.Uint64(journal[16:24])
+	if uint64(len(journal)) != JOURNAL_HEADER_SIZE+saved*uint64(8+slotSize)+4 {
+		return nil, true, fmt.Errorf("journal has %d bytes for %d pages", len(journal), saved)
+	}
+	pages := &journalPages{total: binary.LittleEndian.Uint64(journal[8:16]), slots: map[uint64][]byte{}}
+	for offset := int64(JOURNAL_HEADER_SIZE); offset < int64(len(journal))-4; offset += 8 + slotSize {
+		ptr := binary.LittleEndian.Uint64(journal[offset : offset+8])
+		pages.slots[ptr] = journal[offset+8 : offset+8+slotSize]
+	}
+	return pages, true, nil
+}
+
+// Write back old content saved in a journal left by an interrupted commit, then remove the journal.
+// A journal which is not complete is removed as is, because the page file is not written before it is flushed
+func (p *Pager) recoverJournal() error {
+	journal, found, err := readJournal(p.path, p.slotSize())
+	if err != nil || !found {
+		return err
+	}
+	if journal == nil {
+		return p.removeJournal()
+	}
+	for ptr, slot := range journal.slots {
+		if p.cipher != nil {
+			if slot, err = p.resealSaved(ptr, slot); err != nil {
+				return fmt.Errorf("roll back page %d: %w", ptr, err)
+			}
+		}
+		if _, err := p.file.WriteAt(slot, int64(ptr)*p.slotSize()); err != nil {
+			return fmt.Errorf("roll back page %d: %w", ptr, err)
+		}
+	}
+	if p.cipher == nil {
+		if err := p.file.Truncate(int64(journal.total) * p.slotSize()); err != nil {
+			return err
+		}
+	} else if err := p.resealAppended(journal.total); err != nil {
+		return err
+	}
+	if err := p.file.Sync(); err != nil {
+		return err
+	}
+	return p.removeJournal()
+}
+
+// Seal saved content of page `ptr` with a counter after the one used by the interrupted commit. A saved page which
+// can not be opened has no content to keep, it becomes a free page
+func (p *Pager) resealSaved(ptr uint64, saved []byte) ([]byte, error) {
+	counter := binary.LittleEndian.Uint64(saved[0:SEALED_COUNTER_SIZE])
+	page, _, err := p.cipher.Open(ptr, saved)
+	if err != nil {
+		page = freePage(0)
+	}
+	return p.cipher.Seal(ptr, counter+2, page)
+}
+
+// Replace pages appended by the interrupted commit after the first `total` pages with free pages sealed with
+// counter 2
+func (p *Pager) resealAppended(total uint64) error {
+	size, err := p.totalPages()
+	if err != nil {
+		return err
+	}
+	for ptr := total; ptr < size; ptr++ {
+		slot, err := p.cipher.Seal(ptr, 2, freePage(0))
+		if err != nil {
+			return err
+		}
+		if _, err := p.file.WriteAt(slot, int64(ptr)*p.slotSize()); err != nil {
+			return fmt.Errorf("roll back page %d: %w", ptr, err)
+		}
+	}
+	return nil
+}
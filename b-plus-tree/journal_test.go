@@ -0,0 +1,197 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func commitTestKeys(t *testing.T, path string, total uint16) {
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	for i := uint16(0); i < total; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+}
+
+func assertTestKeys(t *testing.T, path string, total uint16) {
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	assert.Empty(t, tree.Validate())
+	for i := uint16(0); i < total; i++ {
+		val, found := tree.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, createData(i))
+	}
+	assert.Nil(t, pager.Close())
+}
+
+// Crash after journal is flushed, while pages of a commit are written
+func interruptTestCommit(t *testing.T, path string) {
+	pager := openTestPager(t, path, nil)
+	garbage := make([]byte, BTREE_PAGE_SIZE)
+	for i := range garbage {
+		garbage[i] = 0xAB
+	}
+	pages := map[uint64][]byte{0: garbage, 1: garbage, 2: garbage, pager.pageCount + 3: garbage}
+	assert.Nil(t, pager.writeJournal(pages))
+	for ptr, page := range pages {
+		assert.Nil(t, pager.writePage(ptr, page))
+	}
+	assert.Nil(t, pager.Close())
+}
+
+func TestJournalRollsBackInterruptedCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	commitTestKeys(t, path, 100)
+	info, err := os.Stat(path)
+	assert.Nil(t, err)
+
+	interruptTestCommit(t, path)
+	assertTestKeys(t, path, 100)
+	_, err = os.Stat(journalPath(path))
+	assert.True(t, os.IsNotExist(err))
+	newInfo, err := os.Stat(path)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Size(), newInfo.Size())
+}
+
+func TestJournalReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	commitTestKeys(t, path, 100)
+	interruptTestCommit(t, path)
+	file, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	journal, err := os.ReadFile(journalPath(path))
+	assert.Nil(t, err)
+
+	// pages saved in journal are read instead of file
+	pager, err := OpenPagerReadOnly(path, nil)
+	assert.Nil(t, err)
+	tree := pager.Tree()
+	assert.Empty(t, tree.Validate())
+	assert.EqualValues(t, 100, tree.Len())
+	tree.Insert(createBigEndianData(100), createData(100))
+	assert.Equal(t, ErrPagerReadOnly, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	scan, err := ScanPages(path, nil)
+	assert.Nil(t, err)
+	assert.True(t, scan.HotJournal)
+	assert.Nil(t, scan.MetaErr)
+	assert.Empty(t, scan.LostPages)
+	assert.EqualValues(t, len(file)/BTREE_PAGE_SIZE-4, scan.TotalPages)
+	dst := openTestPager(t, filepath.Join(t.TempDir(), "salvaged"), nil)
+	recovered, err := scan.Salvage(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, recovered)
+	assert.Nil(t, dst.Close())
+
+	// neither file nor journal is changed, until the file is opened to be written
+	newFile, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, file, newFile)
+	newJournal, err := os.ReadFile(journalPath(path))
+	assert.Nil(t, err)
+	assert.Equal(t, journal, newJournal)
+	assertTestKeys(t, path, 100)
+	_, err = os.Stat(journalPath(path))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestJournalIncomplete(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	commitTestKeys(t, path, 100)
+
+	// crash while journal is written, page file is not changed yet
+	pager := openTestPager(t, path, nil)
+	assert.Nil(t, pager.writeJournal(map[uint64][]byte{0: nil, 1: nil}))
+	assert.Nil(t, pager.Close())
+	journal, err := os.ReadFile(journalPath(path))
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(journalPath(path), journal[:len(journal)/2], 0644))
+
+	assertTestKeys(t, path, 100)
+	_, err = os.Stat(journalPath(path))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestJournalRemovedAfterCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	commitTestKeys(t, path, 10)
+	_, err := os.Stat(journalPath(path))
+	assert.True(t, os.IsNotExist(err))
+}
+
+// Record sealed pages of file at `path` by their nonces, a nonce must not seal two different pages
+func recordNonces(t *testing.T, path string, sealed map[[2]uint64][]byte) {
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	for ptr := 0; ptr*SEALED_PAGE_SIZE < len(content); ptr++ {
+		slot := content[ptr*SEALED_PAGE_SIZE : (ptr+1)*SEALED_PAGE_SIZE]
+		nonce := [2]uint64{uint64(ptr), binary.LittleEndian.Uint64(slot[0:SEALED_COUNTER_SIZE])}
+		if old, ok := sealed[nonce]; ok {
+			assert.Equal(t, old, slot, "page %d is sealed again with counter %d", nonce[0], nonce[1])
+		}
+		sealed[nonce] = slot
+	}
+}
+
+func TestJournalRollbackKeepsNoncesUnique(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	cipher := newTestCipher(t, 1)
+	sealed := map[[2]uint64][]byte{}
+	pager := openTestPager(t, path, cipher)
+	tree := pager.Tree()
+	for i := uint16(0); i < 100; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+	recordNonces(t, path, sealed)
+
+	// crash after journal is flushed, while pages are written
+	pager = openTestPager(t, path, cipher)
+	garbage := make([]byte, BTREE_PAGE_SIZE)
+	for i := range garbage {
+		garbage[i] = 0xAB
+	}
+	pages := map[uint64][]byte{0: garbage, 1: garbage, 2: garbage, pager.pageCount + 3: garbage}
+	assert.Nil(t, pager.writeJournal(pages))
+	for ptr, page := range pages {
+		assert.Nil(t, pager.writePage(ptr, page))
+	}
+	assert.Nil(t, pager.Close())
+	recordNonces(t, path, sealed)
+	journal, err := os.ReadFile(journalPath(path))
+	assert.Nil(t, err)
+
+	// crash again after rolling back, before journal is removed
+	pager = openTestPager(t, path, cipher)
+	assert.Nil(t, pager.Close())
+	recordNonces(t, path, sealed)
+	assert.Nil(t, os.WriteFile(journalPath(path), journal, 0644))
+
+	// pages written after rolling back, including appended ones, use new counters
+	pager = openTestPager(t, path, cipher)
+	tree = pager.Tree()
+	for i := uint16(100); i < 300; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Empty(t, tree.Validate())
+	for i := uint16(0); i < 300; i++ {
+		val, found := tree.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, createData(i))
+	}
+	assert.Nil(t, pager.Close())
+	recordNonces(t, path, sealed)
+	_, err = os.Stat(journalPath(path))
+	assert.True(t, os.IsNotExist(err))
+}
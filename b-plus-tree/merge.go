@@ -0,0 +1,285 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+/*
+*
+A value of `MergeTree` is stored in leaf `Values` as a merge record, a base value followed by operands which are not
+merged yet:
+
+| kind | base size | base | operand size | operand | ... | operand size | operand
+| 1B   | 4B        |      | 4B           |         |     | 4B           |
+
+kind: MERGE_NO_BASE if key has no base value, then base size and base are omitted
+*
+*/
+
+const (
+	MERGE_NO_BASE   byte = 0
+	MERGE_WITH_BASE byte = 1
+	// operands of a key are merged into its base value when it has this many operands
+	MERGE_MAX_OPERANDS = 16
+)
+
+// MergeFunc combines `operands` of a key, in the order they were recorded, with its base value.
+// `exists` is false if key has no base value, then `base` is nil
+type MergeFunc func(base Data, exists bool, operands []Data) (Data, error)
+
+func appendMergeEntry(record Data, entry Data) Data {
+	record = binary.LittleEndian.AppendUint32(record, uint32(len(entry)))
+	return append(record, entry...)
+}
+
+func encodeMergeRecord(base Data, exists bool, operands []Data) Data {
+	record := Data{MERGE_NO_BASE}
+	if exists {
+		record[0] = MERGE_WITH_BASE
+		record = appendMergeEntry(record, base)
+	}
+	for _, operand := range operands {
+		record = appendMergeEntry(record, operand)
+	}
+	return record
+}
+
+// Returns:
+//
+//	Data: base value
+//	bool: whether record has a base value
+//	[]Data: operands
+func decodeMergeRecord(record Data) (Data, bool, []Data, error) {
+	if len(record) == 0 || record[0] > MERGE_WITH_BASE {
+		return nil, false, nil, fmt.Errorf("invalid merge record")
+	}
+	entries := []Data{}
+	for offset := 1; offset < len(record); {
+		if offset+4 > len(record) {
+			return nil, false, nil, fmt.Errorf("merge record is truncated at %d", offset)
+		}
+		size := int(binary.LittleEndian.Uint32(record[offset:]))
+		offset += 4
+		if offset+size > len(record) {
+			return nil, false, nil, fmt.Errorf("merge record is truncated at %d", offset)
+		}
+		entries = append(entries, record[offset:offset+size])
+		offset += size
+	}
+	if record[0] == MERGE_NO_BASE {
+		return nil, false, entries, nil
+	}
+	if len(entries) == 0 {
+		return nil, false, nil, fmt.Errorf("merge record has no base value")
+	}
+	return entries[0], true, entries[1:], nil
+}
+
+// MergeTree wraps a `BTree` with a merge operator: `Merge` records an operand of a key without reading its value,
+// operands are combined with the base value by `MergeFunc` when the key is read, or when it has `MERGE_MAX_OPERANDS`
+// operands or its record would be larger than `BTREE_MAX_VAL_SIZE`, or when its leaf is split or merged, or by
+// `Compact`. Every value of the tree must be written through `MergeTree`
+type MergeTree struct {
+	tree  *BTree
+	merge MergeFunc
+}
+
+// Wrap `tree` with `merge`, it sets `Fold` of the tree so operands are merged when a leaf is split or merged
+func NewMergeTree(tree *BTree, merge MergeFunc) *MergeTree {
+	m := &MergeTree{tree: tree, merge: merge}
+	tree.Fold = m.foldRecord
+	return m
+}
+
+// Combine operands of a merge record with its base value
+// Returns:
+//
+//	Data: merged value
+//	bool: whether key has a value
+func (m *MergeTree) fold(record Data) (Data, bool, error) {
+	base, exists, operands, err := decodeMergeRecord(record)
+	if err != nil || len(operands) == 0 {
+		return base, exists, err
+	}
+	value, err := m.merge(base, exists, operands)
+	if err != nil {
+		return nil, false, err
+	}
+	return value, true, nil
+}
+
+// Merge record with operands merged into its base value, for a leaf which is rewritten anyway. A record which
+// fails to merge is kept, so the error is returned when its key is read
+func (m *MergeTree) foldRecord(record Data) Data {
+	_, _, operands, err := decodeMergeRecord(record)
+	if err != nil || len(operands) == 0 {
+		return record
+	}
+	value, exists, err := m.fold(record)
+	if err != nil || !exists {
+		return record
+	}
+	if merged := encodeMergeRecord(value, true, nil); len(merged) <= BTREE_MAX_VAL_SIZE {
+		return merged
+	}
+	return record
+}
+
+// Value of `key` with every operand merged, the merged value is not written back
+func (m *MergeTree) Search(key Data) (Data, bool, error) {
+	record, found := m.tree.Search(key)
+	if !found {
+		return nil, false, nil
+	}
+	return m.fold(record)
+}
+
+// Set base value of `key`, operands recorded before are discarded
+func (m *MergeTree) Insert(key Data, value Data) {
+	m.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		return encodeMergeRecord(value, true, nil), true
+	})
+}
+
+func (m *MergeTree) Delete(key Data) bool {
+	return m.tree.Delete(key)
+}
+
+// Record `operand` of `key`, it is appended to the merge record in its leaf in a single descent.
+// Operands are merged first if the record would be larger than `BTREE_MAX_VAL_SIZE`.
+// Returns error of `MergeFunc` if operands of `key` are merged, or if the merged record is still too large,
+// then the operand is not recorded
+func (m *MergeTree) Merge(key Data, operand Data) error {
+	var err error
+	m.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		if !exists {
+			record := encodeMergeRecord(nil, false, []Data{operand})
+			if len(record) > BTREE_MAX_VAL_SIZE {
+				err = fmt.Errorf("merge record has bytes = %d larger than maximum %d", len(record), BTREE_MAX_VAL_SIZE)
+				return nil, false
+			}
+			return record, true
+		}
+		// the old record may be read by a snapshot, so it is copied instead of appended in place
+		record := appendMergeEntry(append(make(Data, 0, len(old)+4+len(operand)), old...), operand)
+		base, hasBase, operands, decodeErr := decodeMergeRecord(record)
+		if decodeErr != nil {
+			err = decodeErr
+			return old, true
+		}
+		if len(operands) < MERGE_MAX_OPERANDS && len(record) <= BTREE_MAX_VAL_SIZE {
+			return record, true
+		}
+		value, mergeErr := m.merge(base, hasBase, operands)
+		if mergeErr != nil {
+			err = mergeErr
+			return old, true
+		}
+		merged := encodeMergeRecord(value, true, nil)
+		if len(merged) > BTREE_MAX_VAL_SIZE {
+			err = fmt.Errorf("merged record has bytes = %d larger than maximum %d", len(merged), BTREE_MAX_VAL_SIZE)
+			return old, true
+		}
+		return merged, true
+	})
+	return err
+}
+
+// Merge operands of every key into its base value.
+// Returns:
+//
+//	int: number of merged keys
+func (m *MergeTree) Compact() (int, error) {
+	merged := 0
+	for it := m.tree.Iterator(nil, nil); it.Next(); {
+		_, _, operands, err := decodeMergeRecord(it.Value())
+		if err != nil {
+			return merged, fmt.Errorf("key %x: %w", it.Key(), err)
+		}
+		if len(operands) == 0 {
+			continue
+		}
+		value, _, err := m.fold(it.Value())
+		if err != nil {
+			return merged, fmt.Errorf("key %x: %w", it.Key(), err)
+		}
+		record := encodeMergeRecord(value, true, nil)
+		merged += 1
+		if m.tree.Monoid != nil {
+			// summaries of ancestors have to be refreshed, replacing a value does not move the iterator
+			m.tree.Update(it.Key(), func(old Data, exists bool) (Data, bool) { return record, true })
+			continue
+		}
+		// the iterator is at this value, so it is replaced in place
+		m.tree.dirty(it.leafPtr)
+		it.leaf.Values[it.pos] = record
+	}
+	return merged, nil
+}
+
+// ============================= BUILT-IN OPERATORS ==================================
+
+func EncodeInt64(value int64) Data {
+	return binary.BigEndian.AppendUint64(nil, uint64(value))
+}
+
+func DecodeInt64(data Data) (int64, error) {
+	if len(data) != 8 {
+		return 0, fmt.Errorf("int64 has 8 bytes, got %d", len(data))
+	}
+	return int64(binary.BigEndian.Uint64(data)), nil
+}
+
+// Add operands to base value, as int64 encoded by `EncodeInt64`. A key without base value starts at 0
+func Int64Add(base Data, exists bool, operands []Data) (Data, error) {
+	var sum int64
+	if exists {
+		value, err := DecodeInt64(base)
+		if err != nil {
+			return nil, err
+		}
+		sum = value
+	}
+	for _, operand := range operands {
+		value, err := DecodeInt64(operand)
+		if err != nil {
+			return nil, err
+		}
+		sum += value
+	}
+	return EncodeInt64(sum), nil
+}
+
+// Maximum of base value and operands, as int64 encoded by `EncodeInt64`
+func Int64Max(base Data, exists bool, operands []Data) (Data, error) {
+	values := operands
+	if exists {
+		values = append([]Data{base}, operands...)
+	}
+	var max int64
+	for i, operand := range values {
+		value, err := DecodeInt64(operand)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 || value > max {
+			max = value
+		}
+	}
+	return EncodeInt64(max), nil
+}
+
+// Append operands to base value
+func BytesAppend(base Data, exists bool, operands []Data) (Data, error) {
+	size := len(base)
+	for _, operand := range operands {
+		size += len(operand)
+	}
+	value := make(Data, 0, size)
+	value = append(value, base...)
+	for _, operand := range operands {
+		value = append(value, operand...)
+	}
+	return value, nil
+}
@@ -0,0 +1,189 @@
+package bplustree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRecord(t *testing.T) {
+	record := encodeMergeRecord(Data("base"), true, []Data{Data("a"), {}, Data("bc")})
+	base, exists, operands, err := decodeMergeRecord(record)
+	assert.Nil(t, err)
+	assert.True(t, exists)
+	assert.EqualValues(t, "base", base)
+	assert.Equal(t, []Data{Data("a"), {}, Data("bc")}, operands)
+
+	base, exists, operands, err = decodeMergeRecord(encodeMergeRecord(nil, false, []Data{Data("a")}))
+	assert.Nil(t, err)
+	assert.False(t, exists)
+	assert.Nil(t, base)
+	assert.Equal(t, []Data{Data("a")}, operands)
+
+	_, _, _, err = decodeMergeRecord(record[:len(record)-1])
+	assert.NotNil(t, err)
+	_, _, _, err = decodeMergeRecord(Data{MERGE_WITH_BASE})
+	assert.NotNil(t, err)
+}
+
+func TestMergeInt64Add(t *testing.T) {
+	c := newC(t, 4)
+	m := NewMergeTree(&c.tree, Int64Add)
+	for i := 0; i < 100; i++ {
+		for key := uint16(0); key < 20; key++ {
+			assert.Nil(t, m.Merge(createBigEndianData(key), EncodeInt64(int64(key))))
+		}
+	}
+	assert.Empty(t, c.tree.Validate())
+	for key := uint16(0); key < 20; key++ {
+		value, found, err := m.Search(createBigEndianData(key))
+		assert.Nil(t, err)
+		assert.True(t, found)
+		sum, err := DecodeInt64(value)
+		assert.Nil(t, err)
+		assert.Equal(t, int64(key)*100, sum)
+
+		// operands are merged eagerly when there are too many of them
+		record, _ := c.tree.Search(createBigEndianData(key))
+		_, _, operands, err := decodeMergeRecord(record)
+		assert.Nil(t, err)
+		assert.Less(t, len(operands), MERGE_MAX_OPERANDS)
+	}
+
+	// base value replaces operands
+	m.Insert(createBigEndianData(1), EncodeInt64(-5))
+	assert.Nil(t, m.Merge(createBigEndianData(1), EncodeInt64(2)))
+	value, _, _ := m.Search(createBigEndianData(1))
+	assert.Equal(t, EncodeInt64(-3), value)
+
+	assert.Nil(t, m.Merge(createBigEndianData(1), Data{1}))
+	_, _, err := m.Search(createBigEndianData(1))
+	assert.NotNil(t, err)
+	assert.True(t, m.Delete(createBigEndianData(1)))
+	_, found, err := m.Search(createBigEndianData(1))
+	assert.Nil(t, err)
+	assert.False(t, found)
+}
+
+func TestMergeOperators(t *testing.T) {
+	value, err := Int64Max(nil, false, []Data{EncodeInt64(-3), EncodeInt64(-7)})
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeInt64(-3), value)
+	value, err = Int64Max(EncodeInt64(10), true, []Data{EncodeInt64(4), EncodeInt64(9)})
+	assert.Nil(t, err)
+	assert.Equal(t, EncodeInt64(10), value)
+	_, err = Int64Max(Data{1, 2}, true, []Data{EncodeInt64(4)})
+	assert.NotNil(t, err)
+
+	value, err = BytesAppend(Data("ab"), true, []Data{Data("c"), Data("de")})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "abcde", value)
+}
+
+func TestMergeCompact(t *testing.T) {
+	c := newC(t, 3)
+	m := NewMergeTree(&c.tree, BytesAppend)
+	for key := uint16(0); key < 30; key++ {
+		if key%3 == 0 {
+			m.Insert(createBigEndianData(key), Data("base-"))
+		}
+		if key%2 == 0 {
+			assert.Nil(t, m.Merge(createBigEndianData(key), Data("x")))
+		}
+	}
+	// leaves are split by the first pass only, so every merged key has an operand left
+	for key := uint16(0); key < 30; key += 2 {
+		assert.Nil(t, m.Merge(createBigEndianData(key), Data("y")))
+	}
+	merged, err := m.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 15, merged)
+	merged, err = m.Compact()
+	assert.Nil(t, err)
+	assert.Equal(t, 0, merged)
+	assert.Empty(t, c.tree.Validate())
+
+	for key := uint16(0); key < 30; key++ {
+		expected := ""
+		if key%3 == 0 {
+			expected = "base-"
+		}
+		if key%2 == 0 {
+			expected += "xy"
+		}
+		value, found, err := m.Search(createBigEndianData(key))
+		assert.Nil(t, err)
+		assert.Equal(t, expected != "", found)
+		if found {
+			assert.EqualValues(t, expected, value)
+		}
+	}
+}
+
+// Number of operands recorded of `key` which are not merged yet
+func pendingOperands(t *testing.T, tree BTree, key Data) int {
+	record, found := tree.Search(key)
+	assert.True(t, found)
+	_, _, operands, err := decodeMergeRecord(record)
+	assert.Nil(t, err)
+	return len(operands)
+}
+
+func TestMergeDuringMaintenance(t *testing.T) {
+	c := newC(t, 3)
+	m := NewMergeTree(&c.tree, Int64Add)
+	for key := uint16(0); key < 200; key++ {
+		assert.Nil(t, m.Merge(createBigEndianData(key), EncodeInt64(1)))
+		assert.Nil(t, m.Merge(createBigEndianData(key), EncodeInt64(2)))
+	}
+	// every leaf is split or merged by then, except the last one
+	splits := 0
+	for key := uint16(0); key < 200; key++ {
+		if pendingOperands(t, c.tree, createBigEndianData(key)) == 0 {
+			splits += 1
+		}
+	}
+	assert.Greater(t, splits, 150)
+	for key := uint16(0); key < 200; key += 2 {
+		assert.True(t, m.Delete(createBigEndianData(key)))
+		assert.Nil(t, m.Merge(createBigEndianData(key+1), EncodeInt64(3)))
+	}
+	assert.Empty(t, c.tree.Validate())
+	for key := uint16(1); key < 200; key += 2 {
+		value, found, err := m.Search(createBigEndianData(key))
+		assert.Nil(t, err)
+		assert.True(t, found)
+		assert.Equal(t, EncodeInt64(6), value)
+	}
+}
+
+func TestMergeRecordSize(t *testing.T) {
+	c := newC(t, 4)
+	m := NewMergeTree(&c.tree, func(base Data, exists bool, operands []Data) (Data, error) {
+		return operands[len(operands)-1], nil
+	})
+	// operands are merged before the record grows over maximum size
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, m.Merge(Data("key"), make(Data, 300)))
+		record, _ := c.tree.Search(Data("key"))
+		assert.LessOrEqual(t, len(record), BTREE_MAX_VAL_SIZE)
+	}
+	assert.Less(t, pendingOperands(t, c.tree, Data("key")), 3)
+
+	appended := NewMergeTree(&newC(t, 4).tree, BytesAppend)
+	big := make(Data, 300)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, appended.Merge(Data("key"), big))
+	}
+	record, _ := appended.tree.Search(Data("key"))
+	assert.LessOrEqual(t, len(record), BTREE_MAX_VAL_SIZE)
+	// the merged value does not fit in a record, so the operand is not recorded
+	assert.NotNil(t, appended.Merge(Data("key"), big))
+	value, found, err := appended.Search(Data("key"))
+	assert.Nil(t, err)
+	assert.True(t, found)
+	assert.Equal(t, 900, len(value))
+	assert.NotNil(t, appended.Merge(Data("other"), make(Data, BTREE_MAX_VAL_SIZE)))
+	_, found, _ = appended.Search(Data("other"))
+	assert.False(t, found)
+}
@@ -0,0 +1,317 @@
+package bplustree
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+)
+
+/*
+*
+A tree with `HashMonoid` keeps a Merkle hash of every sub-tree in its parent, it is updated with summaries by every
+write: hash of a node combines hashes of its children, and hash of a leaf combines hashes of its pairs. Hashes are
+elliptic curve multiset hashes (ECMH) on P-256: a pair is hashed to a point of the curve, and hashes are combined by
+adding points. So hash of a range does not depend on the shape of a tree, and replicas with different splits compare
+hashes of any range. Finding two different sets of pairs with the same hash is as hard as discrete logarithm on the
+curve, so a hash commits to pairs of its range.
+
+hash:     | x   | y          a point of P-256, the point at infinity of an empty range is all zeros
+          | 32B | 32B        BigEndian
+
+`Sync` reconciles a replica with a source served by `ServeSync`, by messages over a stream:
+
+request:  | op | start | end
+          | 1B |       |
+data:     | size | bytes      nil data has size SYNC_NIL_SIZE and no bytes
+          | 4B   |
+SYNC_HASH response:  | hash | count | middle      middle is the key at the middle of range, nil if count is not
+                     |      | 8B    |             larger than SYNC_MAX_PAIRS
+SYNC_PAIRS response: | count | key | value | ... | key | value
+                     | 8B    |
+SYNC_DONE has no start, end and response
+
+The replica compares hash of a range with the source, a range of at most SYNC_MAX_PAIRS pairs is copied from the
+source, a larger one is split at its middle key. So a replica which differs in d pairs exchanges O(d log n) hashes.
+The replica verifies every response against the root hash of the source: hashes of the two halves of a range must
+combine to hash of the range, and copied pairs must hash to hash of their range. Copied pairs are applied together
+once the whole tree is verified, so nothing is copied from a source whose responses do not match its root hash.
+The source answers count and middle key by `CountRange`, `Rank` and `Select`, so it relies on key counts and
+summaries of inner nodes. Writes of `BTree`, `CrabbingBTree` and `BLinkTree` keep both, so a tree written through
+the wrappers can be served once its writers are done: `ServeSync` reads the tree without latches.
+*
+*/
+
+const (
+	MERKLE_HASH_SIZE = 64
+
+	SYNC_HASH  byte = 0
+	SYNC_PAIRS byte = 1
+	SYNC_DONE  byte = 2
+
+	SYNC_NIL_SIZE  = math.MaxUint32
+	SYNC_MAX_PAIRS = 16
+)
+
+var merkleCurve = elliptic.P256()
+
+// Point of a pair by try and increment: x is SHA-256 of a counter and the pair, the first x on the curve is taken
+// with its even y
+func hashToCurve(key Data, value Data) (*big.Int, *big.Int) {
+	digest := sha256.New()
+	compressed := make([]byte, 1+sha256.Size)
+	compressed[0] = 2 // even y
+	for counter := uint32(0); ; counter++ {
+		digest.Reset()
+		digest.Write(binary.LittleEndian.AppendUint32(nil, counter))
+		digest.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(key))))
+		digest.Write(key)
+		digest.Write(value)
+		digest.Sum(compressed[:1])
+		// nil if x is not smaller than p, or x^3 - 3x + b is not a square
+		if x, y := elliptic.UnmarshalCompressed(merkleCurve, compressed); x != nil {
+			return x, y
+		}
+	}
+}
+
+func encodeMerkleHash(x *big.Int, y *big.Int) Data {
+	hash := make(Data, MERKLE_HASH_SIZE)
+	x.FillBytes(hash[:MERKLE_HASH_SIZE/2])
+	y.FillBytes(hash[MERKLE_HASH_SIZE/2:])
+	return hash
+}
+
+func decodeMerkleHash(hash Data) (*big.Int, *big.Int) {
+	return new(big.Int).SetBytes(hash[:MERKLE_HASH_SIZE/2]), new(big.Int).SetBytes(hash[MERKLE_HASH_SIZE/2:])
+}
+
+// Whether `hash` is a point of the curve or the point at infinity, a hash of a peer is checked before it is combined
+func isMerkleHash(hash Data) bool {
+	if len(hash) != MERKLE_HASH_SIZE {
+		return false
+	}
+	x, y := decodeMerkleHash(hash)
+	return x.Sign() == 0 && y.Sign() == 0 || merkleCurve.IsOnCurve(x, y)
+}
+
+// Elliptic curve multiset hash of pairs on P-256
+func HashMonoid() *Monoid {
+	return &Monoid{
+		Identity: make(Data, MERKLE_HASH_SIZE),
+		Lift: func(key Data, value Data) Data {
+			return encodeMerkleHash(hashToCurve(key, value))
+		},
+		Combine: func(left Data, right Data) Data {
+			x1, y1 := decodeMerkleHash(left)
+			x2, y2 := decodeMerkleHash(right)
+			return encodeMerkleHash(merkleCurve.Add(x1, y1, x2, y2))
+		},
+	}
+}
+
+// Hash of every pair, tree must have `HashMonoid`
+func (t BTree) RootHash() Data {
+	return t.Aggregate(nil, nil)
+}
+
+// Hash of every pair with `start` <= key < `end`, nil `start` or `end` means no bound. Tree must have `HashMonoid`
+func (t BTree) SubtreeHash(start Data, end Data) Data {
+	return t.Aggregate(start, end)
+}
+
+func appendSyncData(message []byte, data Data) []byte {
+	if data == nil {
+		return binary.LittleEndian.AppendUint32(message, SYNC_NIL_SIZE)
+	}
+	message = binary.LittleEndian.AppendUint32(message, uint32(len(data)))
+	return append(message, data...)
+}
+
+func readSyncData(r io.Reader) (Data, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(header[:])
+	if size == SYNC_NIL_SIZE {
+		return nil, nil
+	}
+	if size > BTREE_MAX_VAL_SIZE {
+		return nil, fmt.Errorf("sync data has bytes = %d larger than maximum %d", size, BTREE_MAX_VAL_SIZE)
+	}
+	data := make(Data, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func readSyncCount(r io.Reader) (uint64, error) {
+	var count [8]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(count[:]), nil
+}
+
+// Answer requests of a replica from `t` until it is done, tree must have `HashMonoid`.
+// Returns an error of `conn`, or of an invalid request
+func ServeSync(t BTree, conn io.ReadWriter) error {
+	for {
+		var op [1]byte
+		if _, err := io.ReadFull(conn, op[:]); err != nil {
+			return err
+		}
+		if op[0] == SYNC_DONE {
+			return nil
+		}
+		if op[0] > SYNC_DONE {
+			return fmt.Errorf("invalid sync op %d", op[0])
+		}
+		start, err := readSyncData(conn)
+		if err != nil {
+			return err
+		}
+		end, err := readSyncData(conn)
+		if err != nil {
+			return err
+		}
+		var response []byte
+		count := t.CountRange(start, end)
+		if op[0] == SYNC_HASH {
+			response = appendSyncData(response, t.SubtreeHash(start, end))
+			response = binary.LittleEndian.AppendUint64(response, count)
+			var middle Data
+			if count > SYNC_MAX_PAIRS {
+				from := uint64(0)
+				if start != nil {
+					from = t.Rank(start)
+				}
+				middle, _, _ = t.Select(from + count/2)
+			}
+			response = appendSyncData(response, middle)
+		} else {
+			response = binary.LittleEndian.AppendUint64(response, count)
+			t.Scan(start, end, func(key Data, value Data) bool {
+				response = appendSyncData(appendSyncData(response, key), value)
+				return true
+			})
+		}
+		if _, err := conn.Write(response); err != nil {
+			return err
+		}
+	}
+}
+
+// Make `t` equal to the source tree served by `ServeSync` on the other end of `conn`, only ranges whose hashes
+// differ are copied. Tree must have `HashMonoid` and unique keys.
+// Returns an error of `conn`, or of an invalid response
+func Sync(t *BTree, conn io.ReadWriter) error {
+	return VerifiedSync(t, conn, nil)
+}
+
+// `Sync` from a source whose root hash must be `rootHash`, which is known from a trusted party, so every copied pair
+// is verified against it. A nil `rootHash` trusts root hash of the source.
+// Returns an error of `conn`, or of an invalid response, then `t` is not changed
+func VerifiedSync(t *BTree, conn io.ReadWriter, rootHash Data) error {
+	batch := &Batch{}
+	hash, err := t.syncRange(conn, nil, nil, batch)
+	if err != nil {
+		return err
+	}
+	if rootHash != nil && !bytes.Equal(hash, rootHash) {
+		return fmt.Errorf("root hash of sync source is not the trusted root hash")
+	}
+	t.ApplyBatch(batch)
+	_, err = conn.Write([]byte{SYNC_DONE})
+	return err
+}
+
+// Add writes which make pairs in range equal to the source to `batch`, the caller verifies hash of the range.
+// Returns hash of the range at the source
+func (t *BTree) syncRange(conn io.ReadWriter, start Data, end Data, batch *Batch) (Data, error) {
+	if _, err := conn.Write(appendSyncData(appendSyncData([]byte{SYNC_HASH}, start), end)); err != nil {
+		return nil, err
+	}
+	hash, err := readSyncData(conn)
+	if err != nil {
+		return nil, err
+	}
+	count, err := readSyncCount(conn)
+	if err != nil {
+		return nil, err
+	}
+	middle, err := readSyncData(conn)
+	if err != nil {
+		return nil, err
+	}
+	if !isMerkleHash(hash) {
+		return nil, fmt.Errorf("sync hash of range [%q, %q) is not a point of the curve", start, end)
+	}
+	if bytes.Equal(hash, t.SubtreeHash(start, end)) {
+		return hash, nil
+	}
+	// a middle key equal to `start` does not split the range
+	if count > SYNC_MAX_PAIRS && middle != nil && (start == nil || middle.gt(start)) && (end == nil || middle.lt(end)) {
+		left, err := t.syncRange(conn, start, middle, batch)
+		if err != nil {
+			return nil, err
+		}
+		right, err := t.syncRange(conn, middle, end, batch)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(t.Monoid.Combine(left, right), hash) {
+			return nil, fmt.Errorf("sync hashes of halves of range [%q, %q) do not combine to its hash", start, end)
+		}
+		return hash, nil
+	}
+	return hash, t.copyRange(conn, start, end, hash, batch)
+}
+
+// Add writes which replace pairs of `t` in range with pairs of the source to `batch`, if the pairs have `hash`
+func (t *BTree) copyRange(conn io.ReadWriter, start Data, end Data, hash Data, batch *Batch) error {
+	if _, err := conn.Write(appendSyncData(appendSyncData([]byte{SYNC_PAIRS}, start), end)); err != nil {
+		return err
+	}
+	count, err := readSyncCount(conn)
+	if err != nil {
+		return err
+	}
+	pairs := &Batch{}
+	t.Scan(start, end, func(key Data, value Data) bool {
+		pairs.Delete(key)
+		return true
+	})
+	received := t.Monoid.Identity
+	var prev Data
+	for i := uint64(0); i < count; i++ {
+		key, err := readSyncData(conn)
+		if err != nil {
+			return err
+		}
+		value, err := readSyncData(conn)
+		if err != nil {
+			return err
+		}
+		if key == nil || value == nil {
+			return fmt.Errorf("sync pair %d has nil key or value", i)
+		}
+		if (prev != nil && !key.gt(prev)) || (start != nil && key.lt(start)) || (end != nil && !key.lt(end)) {
+			return fmt.Errorf("sync pair %d has key %q out of order or out of range", i, key)
+		}
+		prev = key
+		received = t.Monoid.Combine(received, t.Monoid.Lift(key, value))
+		pairs.Put(key, value)
+	}
+	if !bytes.Equal(received, hash) {
+		return fmt.Errorf("sync pairs of range [%q, %q) do not have its hash", start, end)
+	}
+	batch.writes = append(batch.writes, pairs.writes...)
+	return nil
+}
@@ -0,0 +1,241 @@
+package bplustree
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newHashC(t *testing.T, order uint8) *C {
+	c := newC(t, order)
+	c.tree.Monoid = HashMonoid()
+	return c
+}
+
+// Connection which counts requests of `op` written to it, every request is written at once
+type countingConn struct {
+	net.Conn
+	op       byte
+	requests int
+}
+
+func (c *countingConn) Write(b []byte) (int, error) {
+	if len(b) > 0 && b[0] == c.op {
+		c.requests += 1
+	}
+	return c.Conn.Write(b)
+}
+
+// Sync `replica` from `source` over a pipe, returns number of hashes requested
+func syncOverPipe(t *testing.T, source BTree, replica *BTree) int {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeSync(source, server)
+	}()
+	conn := &countingConn{Conn: client, op: SYNC_HASH}
+	assert.Nil(t, Sync(replica, conn))
+	assert.Nil(t, <-served)
+	return conn.requests
+}
+
+func TestHashMonoid(t *testing.T) {
+	monoid := HashMonoid()
+	a, b, c := monoid.Lift(Data("a"), Data("1")), monoid.Lift(Data("b"), Data("2")), monoid.Lift(Data("c"), Data("3"))
+	for _, hash := range []Data{a, b, c, monoid.Identity} {
+		assert.True(t, isMerkleHash(hash))
+	}
+	assert.Equal(t, a, monoid.Combine(monoid.Identity, a))
+	assert.Equal(t, a, monoid.Combine(a, monoid.Identity))
+	assert.Equal(t, monoid.Combine(monoid.Combine(a, b), c), monoid.Combine(a, monoid.Combine(b, c)))
+	assert.Equal(t, monoid.Combine(a, b), monoid.Combine(b, a))
+	assert.NotEqual(t, monoid.Combine(a, a), monoid.Combine(a, b))
+	assert.NotEqual(t, monoid.Lift(Data("ab"), Data("c")), monoid.Lift(Data("a"), Data("bc")))
+
+	assert.False(t, isMerkleHash(bytes.Repeat([]byte{0xff}, MERKLE_HASH_SIZE)))
+	assert.False(t, isMerkleHash(a[1:]))
+	tampered := append(Data{}, a...)
+	tampered[MERKLE_HASH_SIZE-1] ^= 1
+	assert.False(t, isMerkleHash(tampered))
+}
+
+func TestRootHash(t *testing.T) {
+	a, b := newHashC(t, 3), newHashC(t, 4)
+	assert.Equal(t, a.tree.RootHash(), b.tree.RootHash())
+	random := rand.New(rand.NewSource(1))
+	for _, i := range random.Perm(500) {
+		a.tree.Insert(createBigEndianData(uint16(i)), createData(uint16(i)))
+	}
+	for i := uint16(0); i < 500; i++ {
+		b.tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Empty(t, a.tree.Validate())
+	// hashes do not depend on shape of trees
+	assert.Equal(t, a.tree.RootHash(), b.tree.RootHash())
+	part := newHashC(t, 4)
+	for i := uint16(100); i < 250; i++ {
+		part.tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Equal(t, part.tree.RootHash(), a.tree.SubtreeHash(createBigEndianData(100), createBigEndianData(250)))
+
+	// every write changes hashes of its ranges
+	hash := a.tree.RootHash()
+	a.tree.Update(createBigEndianData(200), func(old Data, exists bool) (Data, bool) { return createData(201), true })
+	assert.NotEqual(t, hash, a.tree.RootHash())
+	assert.Equal(t, b.tree.SubtreeHash(nil, createBigEndianData(200)), a.tree.SubtreeHash(nil, createBigEndianData(200)))
+	a.tree.Update(createBigEndianData(200), func(old Data, exists bool) (Data, bool) { return createData(200), true })
+	assert.Equal(t, hash, a.tree.RootHash())
+	a.tree.Delete(createBigEndianData(300))
+	assert.NotEqual(t, hash, a.tree.RootHash())
+	assert.Empty(t, a.tree.Validate())
+}
+
+func TestSync(t *testing.T) {
+	source, replica := newHashC(t, 4), newHashC(t, 3)
+	for i := uint16(0); i < 2000; i++ {
+		source.tree.Insert(createBigEndianData(i), createData(i))
+		replica.tree.Insert(createBigEndianData(i), createData(i))
+	}
+	replica.tree.Update(createBigEndianData(10), func(old Data, exists bool) (Data, bool) { return createData(11), true })
+	replica.tree.Delete(createBigEndianData(1000))
+	replica.tree.Insert(createBigEndianData(5000), createData(0))
+
+	requests := syncOverPipe(t, source.tree, &replica.tree)
+	// each differing pair costs hashes of ranges on a path of halving ranges
+	assert.Less(t, requests, 3*2*10)
+	assert.Empty(t, replica.tree.Validate())
+	assert.Equal(t, source.tree.RootHash(), replica.tree.RootHash())
+	assert.Equal(t, treeKeys(&source.tree), treeKeys(&replica.tree))
+	value, _ := replica.tree.Search(createBigEndianData(10))
+	assert.EqualValues(t, createData(10), value)
+
+	// replicas in sync compare a single hash
+	assert.Equal(t, 1, syncOverPipe(t, source.tree, &replica.tree))
+
+	// an empty replica copies every pair, and an empty source empties the replica
+	empty := newHashC(t, 4)
+	syncOverPipe(t, source.tree, &empty.tree)
+	assert.Equal(t, treeKeys(&source.tree), treeKeys(&empty.tree))
+	syncOverPipe(t, newHashC(t, 4).tree, &replica.tree)
+	assert.Equal(t, uint64(0), replica.tree.Len())
+	assert.Empty(t, replica.tree.Validate())
+}
+
+func TestSyncFromLatchedTrees(t *testing.T) {
+	crabbingTree, blinkTree := newSyncTree(4), newSyncTree(4)
+	crabbingTree.Monoid, blinkTree.Monoid = HashMonoid(), HashMonoid()
+	crabbing, blink := NewCrabbingBTree(crabbingTree), NewBLinkTree(blinkTree)
+	expected := newHashC(t, 3)
+	var wg sync.WaitGroup
+	for w := uint16(0); w < 4; w++ {
+		wg.Add(1)
+		go func(w uint16) {
+			defer wg.Done()
+			for i := w; i < 400; i += 4 {
+				crabbing.Insert(createBigEndianData(i), createData(i))
+				blink.Insert(createBigEndianData(i), createData(i))
+			}
+			for i := w; i < 400; i += 8 {
+				crabbing.Delete(createBigEndianData(i))
+				blink.Delete(createBigEndianData(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+	for i := uint16(0); i < 400; i++ {
+		if i%8 >= 4 {
+			expected.tree.Insert(createBigEndianData(i), createData(i))
+		}
+	}
+
+	// wrappers keep counts and summaries which the source serves
+	for _, source := range []BTree{crabbing.tree, blink.tree} {
+		assert.Equal(t, expected.tree.RootHash(), source.RootHash())
+		replica := newHashC(t, 4)
+		syncOverPipe(t, source, &replica.tree)
+		assert.Equal(t, treeKeys(&expected.tree), treeKeys(&replica.tree))
+	}
+}
+
+// Connection of a source which flips byte at `pos` of its responses to requests of `op`, negative `pos` counts
+// from the end. A request op is the only read of a single byte
+type tamperingConn struct {
+	net.Conn
+	op   byte
+	pos  int
+	last byte // op of latest request
+}
+
+func (c *tamperingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if len(b) == 1 && n == 1 {
+		c.last = b[0]
+	}
+	return n, err
+}
+
+func (c *tamperingConn) Write(b []byte) (int, error) {
+	if c.last == c.op {
+		b = append([]byte{}, b...)
+		pos := c.pos
+		if pos < 0 {
+			pos += len(b)
+		}
+		b[pos] ^= 1
+	}
+	return c.Conn.Write(b)
+}
+
+func TestVerifiedSync(t *testing.T) {
+	source, replica := newHashC(t, 4), newHashC(t, 4)
+	for i := uint16(0); i < 200; i++ {
+		source.tree.Insert(createBigEndianData(i), createData(i))
+		replica.tree.Insert(createBigEndianData(i), createData(i))
+	}
+	replica.tree.Delete(createBigEndianData(50))
+	replicaHash := replica.tree.RootHash()
+
+	sync := func(conn func(net.Conn) net.Conn, rootHash Data) error {
+		server, client := net.Pipe()
+		served := make(chan error, 1)
+		go func() {
+			served <- ServeSync(source.tree, conn(server))
+		}()
+		err := VerifiedSync(&replica.tree, client, rootHash)
+		client.Close()
+		<-served
+		server.Close()
+		return err
+	}
+	honest := func(conn net.Conn) net.Conn { return conn }
+
+	// a source whose root hash is not the trusted one, or whose responses do not match its hashes, is rejected
+	assert.NotNil(t, sync(honest, replicaHash))
+	assert.Equal(t, replicaHash, replica.tree.RootHash())
+	assert.NotNil(t, sync(func(conn net.Conn) net.Conn { return &tamperingConn{Conn: conn, op: SYNC_PAIRS, pos: -1} }, nil))
+	assert.Equal(t, replicaHash, replica.tree.RootHash())
+	assert.NotNil(t, sync(func(conn net.Conn) net.Conn { return &tamperingConn{Conn: conn, op: SYNC_HASH, pos: 4 + 1} }, nil))
+	assert.Equal(t, replicaHash, replica.tree.RootHash())
+
+	assert.Nil(t, sync(honest, source.tree.RootHash()))
+	assert.Equal(t, source.tree.RootHash(), replica.tree.RootHash())
+	assert.Equal(t, treeKeys(&source.tree), treeKeys(&replica.tree))
+}
+
+func TestServeSyncInvalidRequest(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeSync(newHashC(t, 4).tree, server)
+	}()
+	_, err := client.Write([]byte{SYNC_DONE + 1})
+	assert.Nil(t, err)
+	assert.NotNil(t, <-served)
+}
@@ -0,0 +1,316 @@
+package bplustree
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"sync"
+)
+
+var ErrConflict = errors.New("transaction conflicts with a concurrent commit")
+
+/*
+*
+Every committed write is a version of its key, stored in the tree as:
+
+| escaped key | 0x00 0x01 | ^commitTS | -> | MVCC_VALUE | value
+|             |           | 8B        |    | 1B         |
+| escaped key | 0x00 0x01 | ^commitTS | -> | MVCC_TOMBSTONE
+
+escaped key: every 0x00 byte of key is followed by 0xFF, so versions of a key are never mixed with versions of
+another key which starts with it. Commit timestamp is inverted, versions of a key are sorted from newest to oldest.
+*
+*/
+
+const (
+	MVCC_TOMBSTONE byte = 0 // version of a deleted key
+	MVCC_VALUE     byte = 1
+)
+
+// Versions of `key` start with this prefix
+func versionPrefix(key Data) Data {
+	prefix := make(Data, 0, len(key)+2+8)
+	for _, b := range key {
+		prefix = append(prefix, b)
+		if b == 0 {
+			prefix = append(prefix, 0xFF)
+		}
+	}
+	return append(prefix, 0x00, 0x01)
+}
+
+func encodeVersionKey(key Data, ts uint64) Data {
+	return binary.BigEndian.AppendUint64(versionPrefix(key), ^ts)
+}
+
+func decodeVersionKey(versionKey Data) (Data, uint64) {
+	key := Data{}
+	for i := 0; i+1 < len(versionKey); i++ {
+		if versionKey[i] != 0 {
+			key = append(key, versionKey[i])
+			continue
+		}
+		if versionKey[i+1] == 0x01 {
+			return key, ^binary.BigEndian.Uint64(versionKey[i+2:])
+		}
+		key = append(key, 0)
+		i += 1 // skip 0xFF
+	}
+	return key, 0
+}
+
+// A write of a transaction, which is not committed yet
+type mvccWrite struct {
+	value   Data
+	deleted bool
+}
+
+func (w mvccWrite) encode() Data {
+	if w.deleted {
+		return Data{MVCC_TOMBSTONE}
+	}
+	return append(Data{MVCC_VALUE}, w.value...)
+}
+
+// MVCC stores versions of keys in a tree, so transactions run concurrently with snapshot isolation:
+// a transaction reads the latest versions committed before it began, and it fails to commit with `ErrConflict`
+// if another transaction committed a write to the same key after it began.
+type MVCC struct {
+	mu     sync.Mutex // serializes commits and timestamps
+	tree   *ConcurrentBTree
+	clock  uint64         // timestamp of last commit
+	active map[uint64]int // number of running transactions by start timestamp
+}
+
+func NewMVCC(tree BTree) *MVCC {
+	return &MVCC{tree: NewConcurrentBTree(tree), active: map[uint64]int{}}
+}
+
+// MVCCTx is a transaction of `MVCC`, it sees its own writes. It must be ended by `Commit` or `Rollback`,
+// and it is not safe for concurrent use.
+type MVCCTx struct {
+	db      *MVCC
+	startTS uint64
+	writes  map[string]mvccWrite
+	done    bool
+}
+
+func (db *MVCC) Begin() *MVCCTx {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.active[db.clock] += 1
+	return &MVCCTx{db: db, startTS: db.clock, writes: map[string]mvccWrite{}}
+}
+
+// Latest version of `key` committed at or before `ts`
+// Returns:
+//
+//	Data: encoded version, nil if there is no version
+//	uint64: commit timestamp of the version
+func (db *MVCC) latestVersion(key Data, ts uint64) (Data, uint64) {
+	prefix := versionPrefix(key)
+	var version Data
+	var commitTS uint64
+	db.tree.Scan(encodeVersionKey(key, ts), nil, func(versionKey Data, value Data) bool {
+		if len(versionKey) == len(prefix)+8 && bytes.HasPrefix(versionKey, prefix) {
+			version = value
+			commitTS = ^binary.BigEndian.Uint64(versionKey[len(prefix):])
+		}
+		return false
+	})
+	return version, commitTS
+}
+
+func (tx *MVCCTx) Get(key Data) (Data, bool) {
+	if write, ok := tx.writes[string(key)]; ok {
+		return write.value, !write.deleted
+	}
+	version, _ := tx.db.latestVersion(key, tx.startTS)
+	if version == nil || version[0] == MVCC_TOMBSTONE {
+		return nil, false
+	}
+	return version[1:], true
+}
+
+func (tx *MVCCTx) Put(key Data, value Data) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.writes[string(key)] = mvccWrite{value: value}
+	return nil
+}
+
+func (tx *MVCCTx) Delete(key Data) error {
+	if tx.done {
+		return ErrTxDone
+	}
+	tx.writes[string(key)] = mvccWrite{deleted: true}
+	return nil
+}
+
+// Call `fn` for every key / value pair seen by the transaction with `start` <= key < `end` in ascending order,
+// until `fn` returns false. nil `start` or `end` means no bound
+func (tx *MVCCTx) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	var keys, values []Data
+	var versionStart, versionEnd Data
+	if start != nil {
+		versionStart = encodeVersionKey(start, math.MaxUint64)
+	}
+	if end != nil {
+		versionEnd = encodeVersionKey(end, math.MaxUint64)
+	}
+	var lastKey Data
+	tx.db.tree.Scan(versionStart, versionEnd, func(versionKey Data, version Data) bool {
+		key, ts := decodeVersionKey(versionKey)
+		if ts > tx.startTS || (lastKey != nil && key.eq(lastKey)) {
+			return true
+		}
+		lastKey = key
+		if version[0] == MVCC_VALUE {
+			keys = append(keys, key)
+			values = append(values, version[1:])
+		}
+		return true
+	})
+
+	// merge with own writes
+	writtenKeys := []Data{}
+	for key := range tx.writes {
+		if (start == nil || !Data(key).lt(start)) && (end == nil || Data(key).lt(end)) {
+			writtenKeys = append(writtenKeys, Data(key))
+		}
+	}
+	sort.Slice(writtenKeys, func(i, j int) bool { return writtenKeys[i].lt(writtenKeys[j]) })
+	i := 0
+	for _, writtenKey := range writtenKeys {
+		for ; i < len(keys) && keys[i].lt(writtenKey); i++ {
+			if !fn(keys[i], values[i]) {
+				return
+			}
+		}
+		if i < len(keys) && keys[i].eq(writtenKey) {
+			i += 1
+		}
+		if write := tx.writes[string(writtenKey)]; !write.deleted && !fn(writtenKey, write.value) {
+			return
+		}
+	}
+	for ; i < len(keys); i++ {
+		if !fn(keys[i], values[i]) {
+			return
+		}
+	}
+}
+
+// Commit writes of the transaction, so transactions which begin later see them.
+// Returns `ErrConflict` if another transaction committed a write to the same key after this one began,
+// then nothing is written
+func (tx *MVCCTx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	db := tx.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for key := range tx.writes {
+		if version, commitTS := db.latestVersion(Data(key), math.MaxUint64); version != nil && commitTS > tx.startTS {
+			tx.end()
+			return ErrConflict
+		}
+	}
+	// versions are not seen by running transactions, and transactions which begin later wait for `mu`
+	commitTS := db.clock + 1
+	for key, write := range tx.writes {
+		db.tree.Insert(encodeVersionKey(Data(key), commitTS), write.encode())
+	}
+	db.clock = commitTS
+	tx.end()
+	for key := range tx.writes {
+		db.collect(Data(key), db.watermark())
+	}
+	return nil
+}
+
+// Discard writes of the transaction
+func (tx *MVCCTx) Rollback() {
+	if tx.done {
+		return
+	}
+	tx.db.mu.Lock()
+	defer tx.db.mu.Unlock()
+	tx.end()
+}
+
+// End the transaction while holding `mu`
+func (tx *MVCCTx) end() {
+	tx.done = true
+	if tx.db.active[tx.startTS] -= 1; tx.db.active[tx.startTS] == 0 {
+		delete(tx.db.active, tx.startTS)
+	}
+}
+
+// Oldest timestamp a running transaction, or a transaction which begins later, can read at
+func (db *MVCC) watermark() uint64 {
+	watermark := db.clock
+	for ts := range db.active {
+		if ts < watermark {
+			watermark = ts
+		}
+	}
+	return watermark
+}
+
+// Versions of a key which no transaction can read: versions older than the latest version at `watermark`,
+// and that version itself if it is a tombstone
+func (db *MVCC) garbageVersions(key Data, watermark uint64) []Data {
+	prefix := versionPrefix(key)
+	garbage := []Data{}
+	found := false
+	db.tree.Scan(encodeVersionKey(key, watermark), nil, func(versionKey Data, version Data) bool {
+		if len(versionKey) != len(prefix)+8 || !bytes.HasPrefix(versionKey, prefix) {
+			return false
+		}
+		if found || version[0] == MVCC_TOMBSTONE {
+			garbage = append(garbage, versionKey)
+		}
+		found = true
+		return true
+	})
+	return garbage
+}
+
+// Delete versions of `key` which no transaction can read, while holding `mu`
+func (db *MVCC) collect(key Data, watermark uint64) int {
+	garbage := db.garbageVersions(key, watermark)
+	for _, versionKey := range garbage {
+		db.tree.Delete(versionKey)
+	}
+	return len(garbage)
+}
+
+// Delete versions of every key which no running transaction can read. Old versions of a key are also deleted when
+// a transaction writing the key commits, so this is only needed for keys which are not written again.
+// Returns:
+//
+//	int: number of deleted versions
+func (db *MVCC) GC() int {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := []Data{}
+	var lastKey Data
+	db.tree.Scan(nil, nil, func(versionKey Data, version Data) bool {
+		if key, _ := decodeVersionKey(versionKey); lastKey == nil || !key.eq(lastKey) {
+			keys = append(keys, key)
+			lastKey = key
+		}
+		return true
+	})
+	watermark := db.watermark()
+	total := 0
+	for _, key := range keys {
+		total += db.collect(key, watermark)
+	}
+	return total
+}
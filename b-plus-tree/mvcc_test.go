@@ -0,0 +1,204 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersionKey(t *testing.T) {
+	keys := []Data{{}, {0}, {0, 0}, {0, 1}, {1}, {1, 0}, {1, 0xFF}, Data("a"), Data("ab")}
+	for i, key := range keys {
+		versionKey := encodeVersionKey(key, 7)
+		decoded, ts := decodeVersionKey(versionKey)
+		assert.EqualValues(t, key, decoded)
+		assert.Equal(t, uint64(7), ts)
+		// newer version first
+		assert.True(t, encodeVersionKey(key, 8).lt(versionKey))
+		// every version of a key is before versions of greater keys
+		if i > 0 {
+			assert.True(t, encodeVersionKey(keys[i-1], 0).lt(encodeVersionKey(key, 1<<63)))
+		}
+	}
+}
+
+func mvccKeys(tx *MVCCTx) []string {
+	keys := []string{}
+	tx.Scan(nil, nil, func(key Data, value Data) bool {
+		keys = append(keys, string(key)+"="+string(value))
+		return true
+	})
+	return keys
+}
+
+func TestMVCCSnapshotIsolation(t *testing.T) {
+	db := NewMVCC(newSyncTree(4))
+	tx := db.Begin()
+	assert.Nil(t, tx.Put(Data("a"), Data("1")))
+	assert.Nil(t, tx.Put(Data("b"), Data("1")))
+	val, found := tx.Get(Data("a"))
+	assert.True(t, found)
+	assert.EqualValues(t, Data("1"), val)
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Put(Data("a"), Data("2")))
+
+	reader := db.Begin()
+	writer := db.Begin()
+	assert.Nil(t, writer.Put(Data("a"), Data("2")))
+	assert.Nil(t, writer.Delete(Data("b")))
+	assert.Nil(t, writer.Put(Data("c"), Data("2")))
+	assert.Equal(t, []string{"a=2", "c=2"}, mvccKeys(writer))
+	assert.Nil(t, writer.Commit())
+
+	// reader still sees versions before it began
+	val, _ = reader.Get(Data("a"))
+	assert.EqualValues(t, Data("1"), val)
+	_, found = reader.Get(Data("c"))
+	assert.False(t, found)
+	assert.Equal(t, []string{"a=1", "b=1"}, mvccKeys(reader))
+	reader.Rollback()
+
+	tx = db.Begin()
+	assert.Equal(t, []string{"a=2", "c=2"}, mvccKeys(tx))
+	_, found = tx.Get(Data("b"))
+	assert.False(t, found)
+	tx.Rollback()
+}
+
+func TestMVCCConflict(t *testing.T) {
+	db := NewMVCC(newSyncTree(4))
+	first := db.Begin()
+	second := db.Begin()
+	third := db.Begin()
+	assert.Nil(t, first.Put(Data("k"), Data("1")))
+	assert.Nil(t, second.Put(Data("k"), Data("2")))
+	assert.Nil(t, third.Put(Data("other"), Data("3")))
+	assert.Nil(t, first.Commit())
+	assert.Equal(t, ErrConflict, second.Commit())
+	assert.Nil(t, third.Commit())
+
+	tx := db.Begin()
+	val, _ := tx.Get(Data("k"))
+	assert.EqualValues(t, Data("1"), val)
+	assert.Equal(t, []string{"k=1", "other=3"}, mvccKeys(tx))
+	// delete conflicts too
+	other := db.Begin()
+	assert.Nil(t, other.Delete(Data("k")))
+	assert.Nil(t, other.Commit())
+	assert.Nil(t, tx.Put(Data("k"), Data("4")))
+	assert.Equal(t, ErrConflict, tx.Commit())
+}
+
+func countVersions(db *MVCC) int {
+	total := 0
+	db.tree.Scan(nil, nil, func(key Data, value Data) bool {
+		total += 1
+		return true
+	})
+	return total
+}
+
+func TestMVCCGarbageCollection(t *testing.T) {
+	db := NewMVCC(newSyncTree(4))
+	for i := 0; i < 5; i++ {
+		tx := db.Begin()
+		assert.Nil(t, tx.Put(Data("a"), Data{byte(i)}))
+		assert.Nil(t, tx.Put(Data("b"), Data{byte(i)}))
+		assert.Nil(t, tx.Commit())
+	}
+	// without running transactions, only the latest version is kept
+	assert.Equal(t, 2, countVersions(db))
+
+	old := db.Begin()
+	for i := 5; i < 10; i++ {
+		tx := db.Begin()
+		assert.Nil(t, tx.Put(Data("a"), Data{byte(i)}))
+		if i == 9 {
+			assert.Nil(t, tx.Delete(Data("b")))
+		}
+		assert.Nil(t, tx.Commit())
+	}
+	// version of `old` is kept, and newer versions because the watermark is held by `old`
+	assert.Equal(t, 0, db.GC())
+	val, _ := old.Get(Data("a"))
+	assert.EqualValues(t, Data{4}, val)
+	val, _ = old.Get(Data("b"))
+	assert.EqualValues(t, Data{4}, val)
+
+	old.Rollback()
+	// 5 old versions of `a`, `b` is deleted with its tombstone
+	assert.Equal(t, 5+2, db.GC())
+	assert.Equal(t, 1, countVersions(db))
+	assert.Empty(t, db.tree.Validate())
+}
+
+func TestMVCCConcurrentTransfers(t *testing.T) {
+	db := NewMVCC(newSyncTree(5))
+	const accounts = 10
+	account := func(i int) Data {
+		return Data{'a', byte(i)}
+	}
+	balance := func(value Data) int64 {
+		return int64(binary.BigEndian.Uint64(value))
+	}
+	amount := func(balance int64) Data {
+		return binary.BigEndian.AppendUint64(nil, uint64(balance))
+	}
+	tx := db.Begin()
+	for i := 0; i < accounts; i++ {
+		assert.Nil(t, tx.Put(account(i), amount(100)))
+	}
+	assert.Nil(t, tx.Commit())
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	conflicts := 0
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			random := rand.New(rand.NewSource(int64(worker)))
+			for i := 0; i < 50; i++ {
+				tx := db.Begin()
+				if random.Intn(4) == 0 {
+					// every snapshot has the same total
+					total := int64(0)
+					tx.Scan(nil, nil, func(key Data, value Data) bool {
+						total += balance(value)
+						return true
+					})
+					assert.Equal(t, int64(100*accounts), total)
+					tx.Rollback()
+					continue
+				}
+				from, to := account(random.Intn(accounts)), account(random.Intn(accounts))
+				fromValue, _ := tx.Get(from)
+				assert.Nil(t, tx.Put(from, amount(balance(fromValue)-1)))
+				toValue, _ := tx.Get(to)
+				assert.Nil(t, tx.Put(to, amount(balance(toValue)+1)))
+				if err := tx.Commit(); err != nil {
+					assert.Equal(t, ErrConflict, err)
+					mu.Lock()
+					conflicts += 1
+					mu.Unlock()
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	tx = db.Begin()
+	total := int64(0)
+	tx.Scan(nil, nil, func(key Data, value Data) bool {
+		total += balance(value)
+		return true
+	})
+	tx.Rollback()
+	assert.Equal(t, int64(100*accounts), total)
+	db.GC()
+	assert.Equal(t, accounts, countVersions(db))
+}
@@ -0,0 +1,103 @@
+package bplustree
+
+// Lookups of the nearest key, each walks one path from root. If the leaf reached does not have the key,
+// it is the last / first key of the nearest sub-tree before / after that path, which is remembered on the way down.
+// Each returns:
+//
+//	Data: key
+//	Data: value
+//	bool: false if there is no such key
+
+// Pair with the smallest key in tree
+func (t BTree) First() (Data, Data, bool) {
+	return t.edge(t.Root, false)
+}
+
+// Pair with the greatest key in tree
+func (t BTree) Last() (Data, Data, bool) {
+	return t.edge(t.Root, true)
+}
+
+// Pair with the greatest key <= `key`
+func (t BTree) Floor(key Data) (Data, Data, bool) {
+	return t.nearest(key, true, true)
+}
+
+// Pair with the smallest key >= `key`
+func (t BTree) Ceiling(key Data) (Data, Data, bool) {
+	return t.nearest(key, false, true)
+}
+
+// Pair with the greatest key < `key`
+func (t BTree) Lower(key Data) (Data, Data, bool) {
+	return t.nearest(key, true, false)
+}
+
+// Pair with the smallest key > `key`
+func (t BTree) Higher(key Data) (Data, Data, bool) {
+	return t.nearest(key, false, false)
+}
+
+// Pair with the greatest / smallest key in sub-tree at `nodePtr`:
+//
+//	last: true for the greatest key, false for the smallest one
+func (t BTree) edge(nodePtr uint64, last bool) (Data, Data, bool) {
+	cursor := t.Get(nodePtr)
+	for cursor != nil && !cursor.IsLeaf {
+		if last {
+			cursor = t.Get(cursor.Child[cursor.NumKeys])
+		} else {
+			cursor = t.Get(cursor.Child[0])
+		}
+	}
+	if cursor == nil || cursor.NumKeys == 0 {
+		return nil, nil, false
+	}
+	if last {
+		return cursor.Keys[cursor.NumKeys-1], cursor.Values[cursor.NumKeys-1], true
+	}
+	return cursor.Keys[0], cursor.Values[0], true
+}
+
+// Pair with the nearest key before / after `key`:
+//
+//	key:
+//	below: true for the greatest key before `key`, false for the smallest key after it
+//	inclusive: `key` itself can be returned
+func (t BTree) nearest(key Data, below bool, inclusive bool) (Data, Data, bool) {
+	// a key before `key` is less than it, or equal if `inclusive` and looking below. Otherwise it is after `key`
+	before := func(other Data) bool {
+		return other.lt(key) || (below == inclusive && other.eq(key))
+	}
+	var sibling uint64 // nearest sub-tree before / after the path, 0 if there is none
+	cursor := t.Get(t.Root)
+	for cursor != nil && !cursor.IsLeaf {
+		var pos uint8
+		for pos < cursor.NumKeys && before(cursor.Keys[pos]) {
+			pos += 1
+		}
+		if below && pos > 0 {
+			sibling = cursor.Child[pos-1]
+		} else if !below && pos < cursor.NumKeys {
+			sibling = cursor.Child[pos+1]
+		}
+		cursor = t.Get(cursor.Child[pos])
+	}
+	if cursor == nil {
+		return nil, nil, false
+	}
+	var pos uint8
+	for pos < cursor.NumKeys && before(cursor.Keys[pos]) {
+		pos += 1
+	}
+	if below && pos > 0 {
+		return cursor.Keys[pos-1], cursor.Values[pos-1], true
+	}
+	if !below && pos < cursor.NumKeys {
+		return cursor.Keys[pos], cursor.Values[pos], true
+	}
+	if sibling == 0 {
+		return nil, nil, false
+	}
+	return t.edge(sibling, below)
+}
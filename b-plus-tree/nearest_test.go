@@ -0,0 +1,118 @@
+package bplustree
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Check a nearest lookup returns `expected` key of `keys`, -1 means no key
+func assertNearest(t *testing.T, expected int, key Data, value Data, found bool) {
+	if expected < 0 {
+		assert.False(t, found)
+		assert.Nil(t, key)
+		return
+	}
+	assert.True(t, found)
+	assert.EqualValues(t, createBigEndianData(uint16(expected)), key)
+	assert.EqualValues(t, createData(uint16(expected)), value)
+}
+
+func TestNearest(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		_, _, found := c.tree.First()
+		assert.False(t, found)
+		_, _, found = c.tree.Ceiling(createBigEndianData(0))
+		assert.False(t, found)
+
+		random := rand.New(rand.NewSource(int64(order)))
+		present := map[uint16]bool{}
+		for i := 0; i < 600; i++ {
+			key := uint16(random.Intn(100)) * 2
+			if present[key] && random.Intn(3) == 0 {
+				c.del(createBigEndianData(key))
+				delete(present, key)
+			} else if !present[key] {
+				c.add(createBigEndianData(key), createData(key))
+				present[key] = true
+			}
+		}
+		keys := []int{}
+		for key := range present {
+			keys = append(keys, int(key))
+		}
+		sort.Ints(keys)
+
+		key, value, found := c.tree.First()
+		assertNearest(t, keys[0], key, value, found)
+		key, value, found = c.tree.Last()
+		assertNearest(t, keys[len(keys)-1], key, value, found)
+
+		for probe := -1; probe <= 201; probe++ {
+			floor, ceiling, lower, higher := -1, -1, -1, -1
+			for _, key := range keys {
+				if key <= probe {
+					floor = key
+				}
+				if key < probe {
+					lower = key
+				}
+				if key >= probe && ceiling < 0 {
+					ceiling = key
+				}
+				if key > probe && higher < 0 {
+					higher = key
+				}
+			}
+			data := Data{}
+			if probe >= 0 {
+				data = createBigEndianData(uint16(probe))
+			}
+			key, value, found = c.tree.Floor(data)
+			assertNearest(t, floor, key, value, found)
+			key, value, found = c.tree.Ceiling(data)
+			assertNearest(t, ceiling, key, value, found)
+			key, value, found = c.tree.Lower(data)
+			assertNearest(t, lower, key, value, found)
+			key, value, found = c.tree.Higher(data)
+			assertNearest(t, higher, key, value, found)
+		}
+	}
+}
+
+func TestNearestSingleDescent(t *testing.T) {
+	c := newC(t, 4)
+	for key := uint16(0); key < 200; key += 2 {
+		c.add(createBigEndianData(key), createData(key))
+	}
+	gets := 0
+	get := c.tree.Get
+	c.tree.Get = func(ptr uint64) *BNode {
+		gets += 1
+		return get(ptr)
+	}
+	height := 0
+	for node := get(c.tree.Root); node != nil; node = get(node.Child[0]) {
+		height += 1
+		if node.IsLeaf {
+			break
+		}
+	}
+
+	// key found in the leaf reached
+	key, _, _ := c.tree.Ceiling(createBigEndianData(101))
+	assert.EqualValues(t, createBigEndianData(102), key)
+	assert.Equal(t, height, gets)
+
+	// at most one more path down to the nearest sibling sub-tree
+	for probe := uint16(0); probe < 200; probe++ {
+		for _, lookup := range []func(Data) (Data, Data, bool){c.tree.Floor, c.tree.Ceiling, c.tree.Lower, c.tree.Higher} {
+			gets = 0
+			lookup(createBigEndianData(probe))
+			assert.LessOrEqual(t, gets, 2*height-1)
+		}
+	}
+}
@@ -0,0 +1,420 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+/*
+*
+Page file: page 0 is meta page, nodes are stored in page 1, 2, ... so pointer of a node is its page number.
+Every page has BTREE_PAGE_SIZE bytes, or SEALED_PAGE_SIZE bytes when it is encrypted by a `PageCipher`.
+
+Meta page:
+| magic | root | pageCount | freeHead | catalog | ... | checksum
+| 8B    | 8B   | 8B        | 8B       |         |     | 4B
+
+Catalog of named trees, in ascending order of names:
+| count | nlen | name   | order | root | ... | nlen | name   | order | root
+| 2B    | 1B   | nlen B | 1B    | 8B   |     | 1B   | nlen B | 1B    | 8B
+
+Free page:
+| PAGE_TYPE_FREE | 0  | next free page | ... | checksum
+| 1B             | 1B | 8B             |     | 4B
+*
+*/
+
+var ErrPagerReadOnly = errors.New("pager is opened read-only")
+
+const (
+	PAGER_MAGIC    = "BPTREE05" // version 05 stores every key in full, without a common prefix of a page
+	PAGE_TYPE_FREE = 0xFF
+)
+
+// Read and write whole pages of a file, encrypted when `cipher` is not nil
+type pageFile struct {
+	file     *os.File
+	cipher   *PageCipher
+	mu       sync.Mutex        // guards `counters`, pages are read by concurrent readers
+	counters map[uint64]uint64 // last write counter of sealed pages
+	journal  *journalPages     // pages before an interrupted commit, read instead of file when it is not rolled back
+}
+
+func (f *pageFile) slotSize() int64 {
+	if f.cipher != nil {
+		return SEALED_PAGE_SIZE
+	}
+	return BTREE_PAGE_SIZE
+}
+
+// Number of pages in file, including meta page
+func (f *pageFile) totalPages() (uint64, error) {
+	if f.journal != nil {
+		return f.journal.total, nil
+	}
+	info, err := f.file.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(info.Size() / f.slotSize()), nil
+}
+
+func (f *pageFile) readPage(pageNum uint64) ([]byte, error) {
+	slot := make([]byte, f.slotSize())
+	if saved, ok := f.journal.saved(pageNum); ok {
+		copy(slot, saved)
+	} else if _, err := f.file.ReadAt(slot, int64(pageNum)*f.slotSize()); err != nil {
+		return nil, fmt.Errorf("read page %d: %w", pageNum, err)
+	}
+	if f.cipher == nil {
+		return slot, nil
+	}
+	page, counter, err := f.cipher.Open(pageNum, slot)
+	if err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	f.counters[pageNum] = counter
+	f.mu.Unlock()
+	return page, nil
+}
+
+func (f *pageFile) writePage(pageNum uint64, page []byte) error {
+	slot := page
+	if f.cipher != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		counter, ok := f.counters[pageNum]
+		if !ok {
+			// counter is stored in plain, so it can be read even if the page was never decrypted
+			header := make([]byte, SEALED_COUNTER_SIZE)
+			if _, err := f.file.ReadAt(header, int64(pageNum)*f.slotSize()); err == nil {
+				counter = binary.LittleEndian.Uint64(header)
+			} else if err != io.EOF {
+				return fmt.Errorf("read counter of page %d: %w", pageNum, err)
+			}
+		}
+		var err error
+		if slot, err = f.cipher.Seal(pageNum, counter+1, page); err != nil {
+			return err
+		}
+		f.counters[pageNum] = counter + 1
+	}
+	if _, err := f.file.WriteAt(slot, int64(pageNum)*f.slotSize()); err != nil {
+		return fmt.Errorf("write page %d: %w", pageNum, err)
+	}
+	return nil
+}
+
+// Pager stores nodes of a tree in a page file. It provides `Get`, `New`, `Del` and `Dirty` callbacks of `BTree`,
+// every node is kept in memory once loaded, and changed nodes are only written to file on `Commit`.
+// `Get` is safe to call from concurrent readers, e.g. of a `ConcurrentBTree`, but writes and `Commit` must not run
+// concurrently with each other or with readers.
+type Pager struct {
+	pageFile
+	path      string
+	mu        sync.Mutex        // guards `nodes`, `pageCount`, `isFree` and `err` read by `Get`
+	root      uint64            // root pointer of last commit
+	pageCount uint64            // number of pages, including meta page
+	nodes     map[uint64]*BNode // nodes loaded or allocated since open
+	dirty     map[uint64]bool   // nodes allocated or changed since last commit
+	free      []uint64          // free pages, reused before growing file
+	isFree    map[uint64]bool
+	catalog   map[string]*catalogEntry // named trees
+	err       error                    // first error while loading a page, or while writing a commit
+	readOnly  bool
+}
+
+// Open a page file at `path`, or create it when it does not exist. A journal left by an interrupted commit is
+// rolled back first. `cipher` encrypts every page, nil to store pages in plain.
+func OpenPager(path string, cipher *PageCipher) (*Pager, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	p := newPager(file, path, cipher)
+	if err := p.recoverJournal(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := p.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// Open an existing page file at `path` without changing it, e.g. to check it. A journal left by an interrupted commit
+// is kept, and pages saved in it are read instead of file, as if the commit was rolled back. `Commit` fails with
+// `ErrPagerReadOnly`
+func OpenPagerReadOnly(path string, cipher *PageCipher) (*Pager, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	p := newPager(file, path, cipher)
+	p.readOnly = true
+	if p.journal, _, err = readJournal(path, p.slotSize()); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := p.load(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func newPager(file *os.File, path string, cipher *PageCipher) *Pager {
+	return &Pager{
+		pageFile:  pageFile{file: file, cipher: cipher, counters: map[uint64]uint64{}},
+		path:      path,
+		pageCount: 1,
+		nodes:     map[uint64]*BNode{},
+		dirty:     map[uint64]bool{},
+		isFree:    map[uint64]bool{},
+		catalog:   map[string]*catalogEntry{},
+	}
+}
+
+// Load meta page and free list of an existing file
+func (p *Pager) load() error {
+	total, err := p.totalPages()
+	if err != nil || total == 0 {
+		return err
+	}
+	page, err := p.readPage(0)
+	if err != nil {
+		return err
+	}
+	if string(page[0:8]) != PAGER_MAGIC {
+		return fmt.Errorf("not a page file")
+	}
+	if err := verifyChecksum(page); err != nil {
+		return fmt.Errorf("meta page: %w", err)
+	}
+	p.root = binary.LittleEndian.Uint64(page[8:16])
+	p.pageCount = binary.LittleEndian.Uint64(page[16:24])
+	freeHead := binary.LittleEndian.Uint64(page[24:32])
+	if p.catalog, err = decodeCatalog(page[32 : BTREE_PAGE_SIZE-PAGE_CHECKSUM_SIZE]); err != nil {
+		return fmt.Errorf("meta page: %w", err)
+	}
+	for freeHead != 0 {
+		if p.isFree[freeHead] || freeHead >= p.pageCount {
+			return fmt.Errorf("free list is broken at page %d", freeHead)
+		}
+		page, err := p.readPage(freeHead)
+		if err != nil {
+			return err
+		}
+		if err := verifyChecksum(page); err != nil {
+			return fmt.Errorf("free page %d: %w", freeHead, err)
+		}
+		if page[0] != PAGE_TYPE_FREE {
+			return fmt.Errorf("page %d in free list is not free", freeHead)
+		}
+		p.free = append(p.free, freeHead)
+		p.isFree[freeHead] = true
+		freeHead = binary.LittleEndian.Uint64(page[2:10])
+	}
+	return nil
+}
+
+// Create a tree which stores its nodes in this pager, with root of last commit
+func (p *Pager) Tree() *BTree {
+	return &BTree{
+		Root:   p.root,
+		Order:  ORDER,
+		MinKey: (ORDER+1)/2 - 1,
+		Get:    p.Get,
+		New:    p.New,
+		Del:    p.Del,
+		Dirty:  p.Dirty,
+	}
+}
+
+// Reference a node by its page number, nil if the page does not hold a node, or it can not be loaded
+func (p *Pager) Get(ptr uint64) *BNode {
+	p.mu.Lock()
+	node, cached := p.nodes[ptr]
+	missing := ptr == 0 || ptr >= p.pageCount || p.isFree[ptr]
+	p.mu.Unlock()
+	if cached {
+		return node
+	}
+	if missing {
+		return nil
+	}
+	// page is read without lock, so readers of other pages are not blocked
+	page, err := p.readPage(ptr)
+	if err == nil {
+		node, err = DecodeToBNode(page)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.err == nil {
+			p.err = fmt.Errorf("page %d: %w", ptr, err)
+		}
+		return nil
+	}
+	// another reader may load the page meanwhile, every reader must get the same node
+	if loaded, ok := p.nodes[ptr]; ok {
+		return loaded
+	}
+	p.nodes[ptr] = node
+	return node
+}
+
+// Allocate a page for `node`, reuse a free page if there is any
+func (p *Pager) New(node *BNode) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var ptr uint64
+	if total := len(p.free); total > 0 {
+		ptr = p.free[total-1]
+		p.free = p.free[:total-1]
+		delete(p.isFree, ptr)
+	} else {
+		ptr = p.pageCount
+		p.pageCount += 1
+	}
+	p.nodes[ptr] = node
+	p.dirty[ptr] = true
+	return ptr
+}
+
+// Mark a node to be written on next commit
+func (p *Pager) Dirty(ptr uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.nodes[ptr]; ok {
+		p.dirty[ptr] = true
+	}
+}
+
+// Return page of a node to free list
+func (p *Pager) Del(ptr uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ptr == 0 || p.isFree[ptr] {
+		return
+	}
+	delete(p.nodes, ptr)
+	delete(p.dirty, ptr)
+	p.free = append(p.free, ptr)
+	p.isFree[ptr] = true
+}
+
+// Put back a node deleted since last commit
+func (p *Pager) undelete(ptr uint64, node *BNode) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if !p.isFree[ptr] {
+		return
+	}
+	for i, freePtr := range p.free {
+		if freePtr == ptr {
+			p.free = append(p.free[:i], p.free[i+1:]...)
+			break
+		}
+	}
+	delete(p.isFree, ptr)
+	p.nodes[ptr] = node
+	p.dirty[ptr] = true
+}
+
+// First error while loading a page, or while writing a commit
+func (p *Pager) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// Content of a free page, linked to `next` free page
+func freePage(next uint64) []byte {
+	page := make([]byte, BTREE_PAGE_SIZE)
+	page[0] = PAGE_TYPE_FREE
+	binary.LittleEndian.PutUint64(page[2:10], next)
+	putChecksum(page)
+	return page
+}
+
+// Write changed nodes, free list and meta page with `root` pointer to file, then flush file to disk.
+// Old content of overwritten pages is saved in a journal first, so a crash in the middle is rolled back on next open
+func (p *Pager) Commit(root uint64) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if p.readOnly {
+		return ErrPagerReadOnly
+	}
+	pages, err := p.commitPages(root)
+	if err != nil {
+		return err
+	}
+
+	if err := p.writeJournal(pages); err != nil {
+		return err
+	}
+	// once file is changed, only the journal can restore it, so pager can not be used until it is opened again
+	for ptr, page := range pages {
+		if p.err = p.writePage(ptr, page); p.err != nil {
+			return p.err
+		}
+	}
+	if p.err = p.file.Sync(); p.err != nil {
+		return p.err
+	}
+	if p.err = p.removeJournal(); p.err != nil {
+		return p.err
+	}
+	p.root = root
+	for _, entry := range p.catalog {
+		if entry.tree != nil {
+			entry.root = entry.tree.Root
+		}
+	}
+	p.dirty = map[uint64]bool{}
+	return nil
+}
+
+// Pages written by a commit with `root`: changed nodes, free list and meta page
+func (p *Pager) commitPages(root uint64) (map[uint64][]byte, error) {
+	pages := map[uint64][]byte{}
+	for ptr := range p.dirty {
+		page, err := EncodeToBytes(*p.nodes[ptr])
+		if err != nil {
+			return nil, fmt.Errorf("page %d: %w", ptr, err)
+		}
+		pages[ptr] = page
+	}
+	var freeHead uint64
+	for _, ptr := range p.free {
+		pages[ptr] = freePage(freeHead)
+		freeHead = ptr
+	}
+
+	meta := make([]byte, BTREE_PAGE_SIZE)
+	copy(meta[0:8], PAGER_MAGIC)
+	binary.LittleEndian.PutUint64(meta[8:16], root)
+	binary.LittleEndian.PutUint64(meta[16:24], p.pageCount)
+	binary.LittleEndian.PutUint64(meta[24:32], freeHead)
+	if err := p.encodeCatalog(meta[32 : BTREE_PAGE_SIZE-PAGE_CHECKSUM_SIZE]); err != nil {
+		return nil, err
+	}
+	putChecksum(meta)
+	pages[0] = meta
+	return pages, nil
+}
+
+// Close file, changes after last commit are discarded
+func (p *Pager) Close() error {
+	return p.file.Close()
+}
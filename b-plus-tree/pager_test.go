@@ -0,0 +1,205 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func createBigEndianData(input uint16) Data {
+	buf := make([]byte, 2)
+	binary.BigEndian.PutUint16(buf, input)
+	return buf
+}
+
+func openTestPager(t *testing.T, path string, cipher *PageCipher) *Pager {
+	pager, err := OpenPager(path, cipher)
+	assert.Nil(t, err)
+	return pager
+}
+
+func TestPagerCommitAndReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	assert.Nil(t, tree.Get(tree.Root))
+	for i := uint16(0); i < 200; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	tree = pager.Tree()
+	assert.Empty(t, tree.Validate())
+	for i := uint16(0); i < 200; i++ {
+		val, found := tree.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, createData(i))
+	}
+
+	// deleted pages are reused
+	for i := uint16(0); i < 100; i++ {
+		assert.True(t, tree.Delete(createBigEndianData(i)))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	pageCount := pager.pageCount
+	assert.NotEmpty(t, pager.free)
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	tree = pager.Tree()
+	assert.NotEmpty(t, pager.free)
+	for i := uint16(0); i < 100; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Equal(t, pager.pageCount, pageCount)
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Empty(t, tree.Validate())
+	assert.Nil(t, pager.Close())
+}
+
+func TestPagerDiscardUncommitted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	tree.Insert(createBigEndianData(1), createData(1))
+	assert.Nil(t, pager.Commit(tree.Root))
+	tree.Insert(createBigEndianData(2), createData(2))
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, nil)
+	tree = pager.Tree()
+	_, found := tree.Search(createBigEndianData(1))
+	assert.True(t, found)
+	_, found = tree.Search(createBigEndianData(2))
+	assert.False(t, found)
+	assert.Nil(t, pager.Close())
+}
+
+func TestPagerEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	cipher := newTestCipher(t, 1)
+
+	pager := openTestPager(t, path, cipher)
+	tree := pager.Tree()
+	for i := uint16(0); i < 50; i++ {
+		tree.Insert(createBigEndianData(i), Data("secret"))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	// rewrite changed pages, write counters must keep increasing
+	tree.Insert(createBigEndianData(50), Data("secret"))
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	content, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.NotContains(t, string(content), "secret")
+	assert.Zero(t, len(content)%SEALED_PAGE_SIZE)
+
+	_, err = OpenPager(path, nil)
+	assert.NotNil(t, err)
+	_, err = OpenPager(path, newTestCipher(t, 2))
+	assert.NotNil(t, err)
+
+	// rotate key by rewriting the whole file
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	newCipher := newTestCipher(t, 2)
+	assert.Nil(t, RotateKey(file, file, uint64(len(content)/SEALED_PAGE_SIZE), cipher, newCipher))
+	assert.Nil(t, file.Close())
+
+	pager = openTestPager(t, path, newCipher)
+	tree = pager.Tree()
+	assert.Empty(t, tree.Validate())
+	for i := uint16(0); i <= 50; i++ {
+		val, found := tree.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, Data("secret"))
+	}
+	assert.Nil(t, pager.Close())
+}
+
+// Readers of a `ConcurrentBTree` and its snapshots load pages of an encrypted file at the same time
+func TestPagerConcurrentReaders(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	cipher := newTestCipher(t, 1)
+	pager := openTestPager(t, path, cipher)
+	tree := pager.Tree()
+	for i := uint16(0); i < 500; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	pager = openTestPager(t, path, cipher)
+	concurrent := NewConcurrentBTree(*pager.Tree())
+	snapshot := concurrent.Snapshot()
+	defer snapshot.Release()
+	var wg sync.WaitGroup
+	for reader := 0; reader < 8; reader++ {
+		wg.Add(1)
+		go func(reader int) {
+			defer wg.Done()
+			for i := uint16(0); i < 500; i++ {
+				key := createBigEndianData((i + uint16(reader)*61) % 500)
+				var value Data
+				var found bool
+				if reader%2 == 0 {
+					value, found = concurrent.Search(key)
+				} else {
+					value, found = snapshot.Search(key)
+				}
+				assert.True(t, found)
+				assert.EqualValues(t, createData(uint16(key[0])<<8|uint16(key[1])), value)
+			}
+			count := 0
+			concurrent.Scan(nil, nil, func(key Data, value Data) bool {
+				count += 1
+				return true
+			})
+			assert.Equal(t, 500, count)
+		}(reader)
+	}
+	wg.Wait()
+	assert.Nil(t, pager.Err())
+	// counters of pages loaded by readers are used by the next commit
+	concurrent.Insert(createBigEndianData(500), createData(500))
+	assert.Nil(t, pager.Commit(concurrent.tree.Root))
+	assert.Nil(t, pager.Close())
+}
+
+func TestPagerCorruptedPage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+
+	pager := openTestPager(t, path, nil)
+	tree := pager.Tree()
+	for i := uint16(0); i < 20; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xAB}, BTREE_PAGE_SIZE+20)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	pager = openTestPager(t, path, nil)
+	assert.Nil(t, pager.Get(1))
+	assert.NotNil(t, pager.Err())
+	assert.NotNil(t, pager.Commit(pager.root))
+	assert.Nil(t, pager.Close())
+
+	// meta page
+	assert.Nil(t, os.WriteFile(path, make([]byte, BTREE_PAGE_SIZE), 0644))
+	_, err = OpenPager(path, nil)
+	assert.NotNil(t, err)
+}
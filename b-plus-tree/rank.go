@@ -0,0 +1,73 @@
+package bplustree
+
+// Order statistics walk one path from root, using key counts of children stored in internal nodes
+
+// Number of keys in tree
+func (t BTree) Len() uint64 {
+	root := t.Get(t.Root)
+	if root == nil {
+		return 0
+	}
+	return root.count()
+}
+
+// Number of keys less than `key`, that is position of `key` in ascending order if it exists
+func (t BTree) Rank(key Data) uint64 {
+	var rank uint64
+	cursor := t.Get(t.Root)
+	for cursor != nil && !cursor.IsLeaf {
+		// keys equal to a separator may stay in its left child, so go to the left most child which can contain `key`
+		var pos uint8
+		for pos < cursor.NumKeys && cursor.Keys[pos].lt(key) {
+			rank += cursor.Counts[pos]
+			pos += 1
+		}
+		cursor = t.Get(cursor.Child[pos])
+	}
+	if cursor == nil {
+		return 0
+	}
+	for pos := uint8(0); pos < cursor.NumKeys && cursor.Keys[pos].lt(key); pos++ {
+		rank += 1
+	}
+	return rank
+}
+
+// Key / value pair at position `i` in ascending order of keys, starting from 0.
+// Returns:
+//
+//	Data: key
+//	Data: value
+//	bool: false if `i` is not less than `Len`
+func (t BTree) Select(i uint64) (Data, Data, bool) {
+	cursor := t.Get(t.Root)
+	if cursor == nil || i >= cursor.count() {
+		return nil, nil, false
+	}
+	for !cursor.IsLeaf {
+		var pos uint8
+		for pos < cursor.NumKeys && i >= cursor.Counts[pos] {
+			i -= cursor.Counts[pos]
+			pos += 1
+		}
+		cursor = t.Get(cursor.Child[pos])
+	}
+	return cursor.Keys[i], cursor.Values[i], true
+}
+
+// Number of keys with `start` <= key < `end`, nil `start` or `end` means no bound
+func (t BTree) CountRange(start Data, end Data) uint64 {
+	var from, to uint64
+	if start != nil {
+		from = t.Rank(start)
+	}
+	if end == nil {
+		to = t.Len()
+	} else {
+		to = t.Rank(end)
+	}
+	if to < from {
+		return 0
+	}
+	return to - from
+}
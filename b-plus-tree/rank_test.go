@@ -0,0 +1,90 @@
+package bplustree
+
+import (
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Check order statistics of tree against `keys` in ascending order
+func assertOrderStatistics(t *testing.T, tree BTree, keys []uint16) {
+	assert.Equal(t, uint64(len(keys)), tree.Len())
+	for i, key := range keys {
+		selected, value, found := tree.Select(uint64(i))
+		assert.True(t, found)
+		assert.EqualValues(t, createBigEndianData(key), selected)
+		assert.EqualValues(t, createData(key), value)
+		assert.Equal(t, uint64(i), tree.Rank(createBigEndianData(key)))
+		// rank of a missing key is position it would be inserted at
+		assert.Equal(t, uint64(i+1), tree.Rank(append(createBigEndianData(key), 0)))
+	}
+	_, _, found := tree.Select(uint64(len(keys)))
+	assert.False(t, found)
+}
+
+func TestOrderStatistics(t *testing.T) {
+	for order := uint8(3); order <= 6; order++ {
+		c := newC(t, order)
+		assertOrderStatistics(t, c.tree, nil)
+		random := rand.New(rand.NewSource(int64(order)))
+		present := map[uint16]bool{}
+		for i := 0; i < 1000; i++ {
+			key := uint16(random.Intn(200))
+			if present[key] {
+				c.del(createBigEndianData(key))
+				delete(present, key)
+			} else {
+				c.add(createBigEndianData(key), createData(key))
+				present[key] = true
+			}
+		}
+		keys := []uint16{}
+		for key := range present {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+		assertOrderStatistics(t, c.tree, keys)
+	}
+}
+
+func TestCountRange(t *testing.T) {
+	c := newC(t, 4)
+	keys := []Data{}
+	values := []Data{}
+	for key := uint16(0); key < 100; key += 2 {
+		keys = append(keys, createBigEndianData(key))
+		values = append(values, createData(key))
+	}
+	assert.Nil(t, c.tree.BulkLoad(keys, values))
+	assert.Empty(t, c.tree.Validate())
+
+	assert.Equal(t, uint64(50), c.tree.CountRange(nil, nil))
+	assert.Equal(t, uint64(5), c.tree.CountRange(nil, createBigEndianData(10)))
+	assert.Equal(t, uint64(45), c.tree.CountRange(createBigEndianData(10), nil))
+	assert.Equal(t, uint64(10), c.tree.CountRange(createBigEndianData(11), createBigEndianData(31)))
+	assert.Equal(t, uint64(0), c.tree.CountRange(createBigEndianData(31), createBigEndianData(11)))
+}
+
+func TestOrderStatisticsPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	batch := &Batch{}
+	for key := uint16(0); key < 300; key++ {
+		batch.Put(createBigEndianData(key), createData(key))
+	}
+	for key := uint16(0); key < 300; key += 3 {
+		batch.Delete(createBigEndianData(key))
+	}
+	assert.Nil(t, db.ApplyBatch(batch))
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx := db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assertOrderStatistics(t, tx.tree, txKeys(tx))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
@@ -0,0 +1,338 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// A page which can not be read or decoded
+type LostPage struct {
+	Page uint64
+	Err  error
+}
+
+// Keys in range [Start, End) which were stored under a lost page, nil means no bound
+type LostRange struct {
+	Page   uint64 // lost child
+	Parent uint64 // intact internal node which points to the lost child
+	Start  Data
+	End    Data
+}
+
+// Result of reading every page of a page file, without following pointers from root
+type PageScan struct {
+	TotalPages uint64
+	Nodes      map[uint64]*BNode // intact nodes by page number
+	FreePages  []uint64
+	LostPages  []LostPage
+	MetaErr    error // why the meta page can not be read, then roots of trees are not known
+	HotJournal bool  // a journal of an interrupted commit is found, pages are read as they were before the commit
+	root       uint64
+	catalog    map[string]*catalogEntry
+}
+
+// Read every page of file at `path`, the meta page is not required to be intact. Neither the file nor a journal of
+// an interrupted commit is changed, pages saved in the journal are read instead of file
+func ScanPages(path string, cipher *PageCipher) (*PageScan, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &pageFile{file: file, cipher: cipher, counters: map[uint64]uint64{}}
+	if f.journal, _, err = readJournal(path, f.slotSize()); err != nil {
+		return nil, err
+	}
+	total, err := f.totalPages()
+	if err != nil {
+		return nil, err
+	}
+	scan := &PageScan{
+		TotalPages: total,
+		Nodes:      map[uint64]*BNode{},
+		HotJournal: f.journal != nil,
+	}
+	scan.root, scan.catalog, scan.MetaErr = readMeta(f)
+	for pageNum := uint64(1); pageNum < total; pageNum++ {
+		page, err := f.readPage(pageNum)
+		if err == nil && page[0] == PAGE_TYPE_FREE {
+			if err = verifyChecksum(page); err == nil {
+				scan.FreePages = append(scan.FreePages, pageNum)
+				continue
+			}
+		}
+		var node *BNode
+		if err == nil {
+			node, err = DecodeToBNode(page)
+		}
+		if err != nil {
+			scan.LostPages = append(scan.LostPages, LostPage{Page: pageNum, Err: err})
+			continue
+		}
+		scan.Nodes[pageNum] = node
+	}
+	return scan, nil
+}
+
+// Root of default tree and catalog of named trees from meta page
+func readMeta(f *pageFile) (uint64, map[string]*catalogEntry, error) {
+	page, err := f.readPage(0)
+	if err != nil {
+		return 0, nil, err
+	}
+	if string(page[0:8]) != PAGER_MAGIC {
+		return 0, nil, fmt.Errorf("not a page file")
+	}
+	if err := verifyChecksum(page); err != nil {
+		return 0, nil, err
+	}
+	catalog, err := decodeCatalog(page[32 : BTREE_PAGE_SIZE-PAGE_CHECKSUM_SIZE])
+	if err != nil {
+		return 0, nil, err
+	}
+	return binary.LittleEndian.Uint64(page[8:16]), catalog, nil
+}
+
+// Key ranges pointed to by intact internal nodes, whose child pages are lost
+func (s *PageScan) LostRanges() []LostRange {
+	lost := map[uint64]bool{}
+	for _, lostPage := range s.LostPages {
+		lost[lostPage.Page] = true
+	}
+	ranges := []LostRange{}
+	for pageNum := uint64(1); pageNum < s.TotalPages; pageNum++ {
+		node, ok := s.Nodes[pageNum]
+		if !ok || node.IsLeaf {
+			continue
+		}
+		for i := uint8(0); i <= node.NumKeys; i++ {
+			if !lost[node.Child[i]] {
+				continue
+			}
+			lostRange := LostRange{Page: node.Child[i], Parent: pageNum}
+			if i > 0 {
+				lostRange.Start = node.Keys[i-1]
+			}
+			if i < node.NumKeys {
+				lostRange.End = node.Keys[i]
+			}
+			ranges = append(ranges, lostRange)
+		}
+	}
+	return ranges
+}
+
+// Roots of trees in file: default tree first, then named trees in order of names. Nil without meta page, then
+// roots of trees are not known
+func (s *PageScan) roots() []uint64 {
+	if s.MetaErr != nil {
+		return nil
+	}
+	roots := []uint64{s.root}
+	for _, name := range sortedNames(s.catalog) {
+		roots = append(roots, s.catalog[name].root)
+	}
+	return roots
+}
+
+// Check that intact leaves can be pairs of a single tree: sorted by their first keys, each leaf starts at or after
+// the last key of the previous one. Leaves of different trees usually overlap
+func (s *PageScan) singleTreeCheck() error {
+	leaves := []*BNode{}
+	for _, node := range s.Nodes {
+		if node.IsLeaf && node.NumKeys > 0 {
+			leaves = append(leaves, node)
+		}
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return leaves[i].Keys[0].lt(leaves[j].Keys[0])
+	})
+	for i := 1; i < len(leaves); i++ {
+		prev := leaves[i-1]
+		if leaves[i].Keys[0].lt(prev.Keys[prev.NumKeys-1]) {
+			return fmt.Errorf("meta page is lost and leaves of more than one tree overlap: %w", s.MetaErr)
+		}
+	}
+	return nil
+}
+
+// Whether an intact leaf has the marker pair of a root bucket, then some values are sub-buckets
+func (s *PageScan) hasBuckets() bool {
+	for _, node := range s.Nodes {
+		for i := uint8(0); node.IsLeaf && i < node.NumKeys; i++ {
+			if isBucketMarker(node.Keys[i], node.Values[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Pairs of intact leaves reachable from `ptr`, sorted by keys. Visited pages are added to `reached`
+func (s *PageScan) treePairs(ptr uint64, reached map[uint64]bool) ([]Data, []Data) {
+	keys, values := []Data{}, []Data{}
+	var walk func(ptr uint64)
+	walk = func(ptr uint64) {
+		node, ok := s.Nodes[ptr]
+		if !ok || reached[ptr] {
+			return
+		}
+		reached[ptr] = true
+		if !node.IsLeaf {
+			for i := uint8(0); i <= node.NumKeys; i++ {
+				walk(node.Child[i])
+			}
+			return
+		}
+		for i := uint8(0); i < node.NumKeys; i++ {
+			keys = append(keys, node.Keys[i])
+			values = append(values, node.Values[i])
+		}
+	}
+	walk(ptr)
+	return sortPairs(keys, values)
+}
+
+// Sort `keys` with their `values`, pairs of equal keys keep their order
+func sortPairs(keys []Data, values []Data) ([]Data, []Data) {
+	order := make([]int, len(keys))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return keys[order[i]].lt(keys[order[j]])
+	})
+	sortedKeys := make([]Data, len(keys))
+	sortedValues := make([]Data, len(values))
+	for i, idx := range order {
+		sortedKeys[i] = keys[idx]
+		sortedValues[i] = values[idx]
+	}
+	return sortedKeys, sortedValues
+}
+
+// Build empty `tree` from pairs of tree at `ptr`. A tree of a sub-bucket, or a tree with the marker of a root
+// bucket, is a bucket tree, its values which are sub-buckets are rebuilt too and point to their new roots. Without
+// the marker, they are copied as they are, and `Bucket` does not read them.
+// Returns:
+//
+//	int: number of recovered keys, with keys of sub-buckets
+func (s *PageScan) rebuild(tree *BTree, ptr uint64, nested bool, reached map[uint64]bool) (int, error) {
+	keys, values := s.treePairs(ptr, reached)
+	recovered := len(keys)
+	buckets := nested || len(keys) > 0 && isBucketMarker(keys[0], values[0])
+	for i, value := range values {
+		if !buckets || !isNestedBucket(value) {
+			continue
+		}
+		nested := *tree
+		nested.Root = 0
+		count, err := s.rebuild(&nested, binary.LittleEndian.Uint64(value[1:]), true, reached)
+		if err != nil {
+			return 0, err
+		}
+		values[i] = encodeNestedBucket(nested.Root)
+		recovered += count
+	}
+	if err := tree.BulkLoad(keys, values); err != nil {
+		return 0, err
+	}
+	return recovered, nil
+}
+
+// Intact leaves which are not reachable from a root of any tree, e.g. under a lost internal node. Without meta page
+// none, every intact leaf is salvaged into default tree
+func (s *PageScan) OrphanLeaves() []uint64 {
+	if s.MetaErr != nil {
+		return []uint64{}
+	}
+	reached := map[uint64]bool{}
+	for _, root := range s.roots() {
+		s.walkNested(root, false, reached)
+	}
+	orphans := []uint64{}
+	for pageNum := uint64(1); pageNum < s.TotalPages; pageNum++ {
+		if node, ok := s.Nodes[pageNum]; ok && node.IsLeaf && !reached[pageNum] {
+			orphans = append(orphans, pageNum)
+		}
+	}
+	return orphans
+}
+
+// Mark every page of tree at `ptr` and of its sub-buckets as `reached`, as `rebuild` visits them
+func (s *PageScan) walkNested(ptr uint64, nested bool, reached map[uint64]bool) {
+	keys, values := s.treePairs(ptr, reached)
+	buckets := nested || len(keys) > 0 && isBucketMarker(keys[0], values[0])
+	for _, value := range values {
+		if buckets && isNestedBucket(value) {
+			s.walkNested(binary.LittleEndian.Uint64(value[1:]), true, reached)
+		}
+	}
+}
+
+// Build a new tree in `dst` for every tree of file from pairs of its intact leaves, then commit them.
+// Named trees are created in `dst` with the same names, and sub-buckets are rebuilt with their bucket trees.
+// Orphan leaves, which are not reachable from a root, can only be told apart if the file has a single tree without
+// sub-buckets, then they are added to it. Otherwise they are skipped, see `OrphanLeaves`.
+// Without meta page, every intact leaf is added to default tree, unless leaves have sub-buckets or overlap, then
+// trees can not be told apart and an error is returned.
+// Returns:
+//
+//	int: number of recovered keys
+func (s *PageScan) Salvage(dst *Pager) (int, error) {
+	tree := dst.Tree()
+	if tree.Get(tree.Root) != nil || len(dst.ListTrees()) > 0 {
+		return 0, fmt.Errorf("salvage into a non-empty page file")
+	}
+	if s.MetaErr != nil {
+		if s.hasBuckets() {
+			return 0, fmt.Errorf("meta page is lost and sub-buckets can not be told apart: %w", s.MetaErr)
+		}
+		if err := s.singleTreeCheck(); err != nil {
+			return 0, err
+		}
+	}
+	if s.MetaErr != nil || len(s.catalog) == 0 && !s.hasBuckets() {
+		keys, values := []Data{}, []Data{}
+		for pageNum := uint64(1); pageNum < s.TotalPages; pageNum++ {
+			if node, ok := s.Nodes[pageNum]; ok && node.IsLeaf {
+				keys = append(keys, node.Keys[:node.NumKeys]...)
+				values = append(values, node.Values[:node.NumKeys]...)
+			}
+		}
+		keys, values = sortPairs(keys, values)
+		if err := tree.BulkLoad(keys, values); err != nil {
+			return 0, err
+		}
+		if err := dst.Commit(tree.Root); err != nil {
+			return 0, err
+		}
+		return len(keys), nil
+	}
+
+	recovered := 0
+	reached := map[uint64]bool{}
+	for i, root := range s.roots() {
+		target := tree
+		if i > 0 {
+			name := sortedNames(s.catalog)[i-1]
+			named, err := dst.CreateTree(name, s.catalog[name].order)
+			if err != nil {
+				return 0, err
+			}
+			target = named
+		}
+		count, err := s.rebuild(target, root, false, reached)
+		if err != nil {
+			return 0, err
+		}
+		recovered += count
+	}
+	if err := dst.Commit(tree.Root); err != nil {
+		return 0, err
+	}
+	return recovered, nil
+}
@@ -0,0 +1,259 @@
+package bplustree
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanPagesAndSalvage(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+
+	pager := openTestPager(t, srcPath, nil)
+	tree := pager.Tree()
+	for i := uint16(0); i < 100; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	for i := uint16(0); i < 10; i++ {
+		assert.True(t, tree.Delete(createBigEndianData(i)))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+
+	// find a leaf in the middle of tree to corrupt it
+	leafPtr := tree.Root
+	for !tree.Get(leafPtr).IsLeaf {
+		node := tree.Get(leafPtr)
+		leafPtr = node.Child[node.NumKeys/2]
+	}
+	lostKeys := map[string]bool{}
+	leaf := tree.Get(leafPtr)
+	for i := uint8(0); i < leaf.NumKeys; i++ {
+		lostKeys[string(leaf.Keys[i])] = true
+	}
+	assert.Nil(t, pager.Close())
+
+	file, err := os.OpenFile(srcPath, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xAB, 0xCD}, int64(leafPtr)*BTREE_PAGE_SIZE+14)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	assert.Len(t, scan.LostPages, 1)
+	assert.Equal(t, scan.LostPages[0].Page, leafPtr)
+	assert.NotEmpty(t, scan.FreePages)
+	lostRanges := scan.LostRanges()
+	assert.Len(t, lostRanges, 1)
+	assert.Equal(t, lostRanges[0].Page, leafPtr)
+	for key := range lostKeys {
+		assert.False(t, Data(key).lt(lostRanges[0].Start))
+		assert.True(t, Data(key).lt(lostRanges[0].End))
+	}
+
+	dst := openTestPager(t, filepath.Join(dir, "dst"), nil)
+	recovered, err := scan.Salvage(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, recovered, 90-len(lostKeys))
+
+	dstTree := dst.Tree()
+	assert.Empty(t, dstTree.Validate())
+	for i := uint16(0); i < 100; i++ {
+		val, found := dstTree.Search(createBigEndianData(i))
+		expected := i >= 10 && !lostKeys[string(createBigEndianData(i))]
+		assert.Equal(t, expected, found)
+		if found {
+			assert.EqualValues(t, val, createData(i))
+		}
+	}
+
+	// destination must be empty
+	_, err = scan.Salvage(dst)
+	assert.NotNil(t, err)
+	assert.Nil(t, dst.Close())
+}
+
+func TestSalvageNamedTreesAndBuckets(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	pager := openTestPager(t, srcPath, nil)
+	tree := pager.Tree()
+	root := OpenBucket(tree)
+	tenants, err := root.CreateBucket(Data("tenants"))
+	assert.Nil(t, err)
+	for i := uint16(0); i < 100; i++ {
+		assert.Nil(t, tenants.Put(createBigEndianData(i), createData(i)))
+	}
+	small, err := root.CreateBucket(Data("small"))
+	assert.Nil(t, err)
+	assert.Nil(t, small.Put(Data("a"), Data("b")))
+	named, err := pager.CreateTree("named", 3)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 50; i++ {
+		named.Insert(createBigEndianData(i), createData(i+1))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	assert.Empty(t, scan.OrphanLeaves())
+	dst := openTestPager(t, filepath.Join(dir, "dst"), nil)
+	recovered, err := scan.Salvage(dst)
+	assert.Nil(t, err)
+	// marker, 2 buckets and their pairs, pairs of named tree
+	assert.Equal(t, 1+2+100+50, recovered)
+
+	// every tree is rebuilt on its own
+	dstTree := dst.Tree()
+	assert.Empty(t, dstTree.Validate())
+	assert.Equal(t, uint64(3), dstTree.Len())
+	dstRoot := OpenBucket(dstTree)
+	dstTenants := dstRoot.Bucket(Data("tenants"))
+	for i := uint16(0); i < 100; i++ {
+		value, found := dstTenants.Get(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, createData(i), value)
+	}
+	value, found := dstRoot.Bucket(Data("small")).Get(Data("a"))
+	assert.True(t, found)
+	assert.EqualValues(t, "b", value)
+	assert.Equal(t, []string{"named"}, dst.ListTrees())
+	dstNamed, err := dst.OpenTree("named")
+	assert.Nil(t, err)
+	assert.Equal(t, uint8(3), dstNamed.Order)
+	assert.Empty(t, dstNamed.Validate())
+	assert.Equal(t, uint64(50), dstNamed.Len())
+	value, found = dstNamed.Search(createBigEndianData(7))
+	assert.True(t, found)
+	assert.EqualValues(t, createData(8), value)
+	assert.Nil(t, dst.Close())
+}
+
+func TestSalvageOrphanLeaves(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	pager := openTestPager(t, srcPath, nil)
+	tree := pager.Tree()
+	for i := uint16(0); i < 100; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+	}
+	named, err := pager.CreateTree("named", 4)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 100; i++ {
+		named.Insert(createBigEndianData(i), createData(i+1))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	// corrupt an internal node of named tree, its leaves can not be told from leaves of default tree
+	internalPtr := named.Get(named.Root).Child[0]
+	assert.False(t, named.Get(internalPtr).IsLeaf)
+	assert.Nil(t, pager.Close())
+	file, err := os.OpenFile(srcPath, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xAB, 0xCD}, int64(internalPtr)*BTREE_PAGE_SIZE+14)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, scan.OrphanLeaves())
+	dst := openTestPager(t, filepath.Join(dir, "dst"), nil)
+	_, err = scan.Salvage(dst)
+	assert.Nil(t, err)
+	dstTree := dst.Tree()
+	assert.Equal(t, uint64(100), dstTree.Len())
+	value, found := dstTree.Search(createBigEndianData(7))
+	assert.True(t, found)
+	assert.EqualValues(t, createData(7), value)
+	dstNamed, err := dst.OpenTree("named")
+	assert.Nil(t, err)
+	assert.Empty(t, dstNamed.Validate())
+	assert.Less(t, dstNamed.Len(), uint64(100))
+	assert.Nil(t, dst.Close())
+}
+
+func TestSalvageWithoutMetaPage(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	commitTestKeys(t, srcPath, 100)
+	file, err := os.OpenFile(srcPath, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xAB, 0xCD}, 8)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, scan.MetaErr)
+	assert.Empty(t, scan.OrphanLeaves())
+	dstPath := filepath.Join(dir, "dst")
+	dst := openTestPager(t, dstPath, nil)
+	recovered, err := scan.Salvage(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 100, recovered)
+	assert.Nil(t, dst.Close())
+	assertTestKeys(t, dstPath, 100)
+}
+
+// Overwrite bytes of page `ptr` of file at `path`, so it can not be decoded
+func corruptPage(t *testing.T, path string, ptr uint64) {
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	_, err = file.WriteAt([]byte{0xAB, 0xCD}, int64(ptr)*BTREE_PAGE_SIZE+14)
+	assert.Nil(t, err)
+	assert.Nil(t, file.Close())
+}
+
+func TestSalvageWithoutMetaAndInternalPage(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	commitTestKeys(t, srcPath, 300)
+	pager := openTestPager(t, srcPath, nil)
+	tree := pager.Tree()
+	internalPtr := tree.Get(tree.Root).Child[0]
+	assert.False(t, tree.Get(internalPtr).IsLeaf)
+	assert.Nil(t, pager.Close())
+	corruptPage(t, srcPath, 0)
+	corruptPage(t, srcPath, internalPtr)
+
+	// leaves under the lost internal page have no parent, they are still salvaged
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, scan.MetaErr)
+	assert.Len(t, scan.LostPages, 1)
+	dstPath := filepath.Join(dir, "dst")
+	dst := openTestPager(t, dstPath, nil)
+	recovered, err := scan.Salvage(dst)
+	assert.Nil(t, err)
+	assert.Equal(t, 300, recovered)
+	assert.Nil(t, dst.Close())
+	assertTestKeys(t, dstPath, 300)
+}
+
+func TestSalvageWithoutMetaNamedTrees(t *testing.T) {
+	dir := t.TempDir()
+	srcPath := filepath.Join(dir, "src")
+	pager := openTestPager(t, srcPath, nil)
+	tree := pager.Tree()
+	named, err := pager.CreateTree("named", 4)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 100; i++ {
+		tree.Insert(createBigEndianData(i), createData(i))
+		named.Insert(createBigEndianData(i), createData(i+1))
+	}
+	assert.Nil(t, pager.Commit(tree.Root))
+	assert.Nil(t, pager.Close())
+	corruptPage(t, srcPath, 0)
+
+	// pairs of both trees can not be told apart, nothing is salvaged silently
+	scan, err := ScanPages(srcPath, nil)
+	assert.Nil(t, err)
+	dst := openTestPager(t, filepath.Join(dir, "dst"), nil)
+	recovered, err := scan.Salvage(dst)
+	assert.NotNil(t, err)
+	assert.Equal(t, 0, recovered)
+	assert.Nil(t, dst.Close())
+}
@@ -0,0 +1,250 @@
+package bplustree
+
+import (
+	"sync"
+)
+
+// Old content of a page, kept for snapshots taken before it was changed or deleted
+type pageVersion struct {
+	node  *BNode
+	until uint64 // epoch of the change, the version is seen by snapshots of earlier epochs
+}
+
+// A page deleted while a snapshot may still read it
+type deferredDel struct {
+	ptr   uint64
+	epoch uint64
+}
+
+// versionStore wraps callbacks of a `BTree`, it keeps old content of pages as long as a snapshot can read them.
+// Writes happen in the current epoch, a snapshot sees every write of its epoch and before, and none after.
+// Snapshots which see the same content of a page and its sub-tree read the same node object, so a node object is
+// never changed.
+// It is not safe for concurrent use, `ConcurrentBTree` guards it with its lock, readers of `read` hold it shared.
+type versionStore struct {
+	get       func(uint64) *BNode
+	del       func(uint64)
+	epoch     uint64
+	changed   map[uint64]uint64        // epoch of the last change of a page, so it is copied at most once per epoch
+	versions  map[uint64][]pageVersion // old content of pages, in ascending order of `until`
+	deferred  []deferredDel
+	snapshots map[uint64]int    // number of live snapshots by epoch
+	currentMu sync.Mutex        // `read` adds to `current` under shared lock
+	current   map[uint64]*BNode // copy of current content of a page read by snapshots, until the page is changed
+}
+
+func newVersionStore(get func(uint64) *BNode, del func(uint64)) *versionStore {
+	return &versionStore{
+		get:       get,
+		del:       del,
+		changed:   map[uint64]uint64{},
+		versions:  map[uint64][]pageVersion{},
+		snapshots: map[uint64]int{},
+		current:   map[uint64]*BNode{},
+	}
+}
+
+// Remove the copy of current content of a page, which becomes its old version if `ok`
+func (s *versionStore) takeCurrent(ptr uint64) (*BNode, bool) {
+	s.currentMu.Lock()
+	defer s.currentMu.Unlock()
+	node, ok := s.current[ptr]
+	delete(s.current, ptr)
+	return node, ok
+}
+
+// Whether a live snapshot is taken in an epoch in range [`from`, `until`)
+func (s *versionStore) live(from uint64, until uint64) bool {
+	for epoch := range s.snapshots {
+		if from <= epoch && epoch < until {
+			return true
+		}
+	}
+	return false
+}
+
+func cloneNode(node *BNode) *BNode {
+	clone := *node
+	clone.Keys = append([]Data(nil), node.Keys...)
+	clone.Values = append([]Data(nil), node.Values...)
+	clone.Child = append([]uint64(nil), node.Child...)
+	clone.Counts = append([]uint64(nil), node.Counts...)
+	clone.Summaries = append([]Data(nil), node.Summaries...)
+	return &clone
+}
+
+// `Dirty` callback: copy the current content of a page before its first change in this epoch. A copy which
+// snapshots read already is kept as the old version, so they keep reading the same node object
+func (s *versionStore) dirty(ptr uint64) {
+	node, read := s.takeCurrent(ptr)
+	if len(s.snapshots) == 0 {
+		return
+	}
+	if changed := s.changed[ptr]; changed < s.epoch && s.live(changed, s.epoch) {
+		if !read {
+			node = cloneNode(s.get(ptr))
+		}
+		s.versions[ptr] = append(s.versions[ptr], pageVersion{node: node, until: s.epoch})
+	}
+	s.changed[ptr] = s.epoch
+}
+
+// Copy internal nodes on the path to `key` from the page at `root`, the same routing as `BTree.Update`. A write which
+// changes only a leaf calls it first, so snapshots on both sides of the write do not share the ancestors of the leaf
+func (s *versionStore) dirtyPath(root uint64, key Data) {
+	ptr := root
+	for node := s.get(ptr); node != nil && !node.IsLeaf; node = s.get(ptr) {
+		s.dirty(ptr)
+		var pos uint8
+		for pos < node.NumKeys && !key.lt(node.Keys[pos]) {
+			pos += 1
+		}
+		ptr = node.Child[pos]
+	}
+}
+
+// Record epoch of a new page, it is not seen by any live snapshot
+func (s *versionStore) created(ptr uint64) {
+	s.takeCurrent(ptr)
+	if len(s.snapshots) > 0 {
+		s.changed[ptr] = s.epoch
+	}
+}
+
+// `Del` callback: a page which a live snapshot can read is deleted when the snapshot is released
+func (s *versionStore) delete(ptr uint64) {
+	if len(s.versions[ptr]) > 0 || s.live(s.changed[ptr], s.epoch) {
+		s.deferred = append(s.deferred, deferredDel{ptr: ptr, epoch: s.epoch})
+		return
+	}
+	s.drop(ptr)
+}
+
+func (s *versionStore) drop(ptr uint64) {
+	s.takeCurrent(ptr)
+	delete(s.changed, ptr)
+	delete(s.versions, ptr)
+	s.del(ptr)
+}
+
+// Content of a page seen by snapshot of `epoch`
+func (s *versionStore) read(ptr uint64, epoch uint64) *BNode {
+	for _, version := range s.versions[ptr] {
+		if epoch < version.until {
+			return version.node
+		}
+	}
+	s.currentMu.Lock()
+	defer s.currentMu.Unlock()
+	if node, ok := s.current[ptr]; ok {
+		return node
+	}
+	node := s.get(ptr)
+	if node == nil {
+		return nil
+	}
+	// current content is changed in place by later writes, so readers share a copy until the page is changed
+	s.current[ptr] = cloneNode(node)
+	return s.current[ptr]
+}
+
+// Start a snapshot of the current epoch, later writes happen in the next epoch
+func (s *versionStore) acquire() uint64 {
+	epoch := s.epoch
+	s.snapshots[epoch] += 1
+	s.epoch += 1
+	return epoch
+}
+
+// Release a snapshot, then drop versions and deleted pages which no live snapshot can read
+func (s *versionStore) release(epoch uint64) {
+	if s.snapshots[epoch] -= 1; s.snapshots[epoch] == 0 {
+		delete(s.snapshots, epoch)
+	}
+	deferred := s.deferred[:0]
+	for _, page := range s.deferred {
+		if s.live(0, page.epoch) {
+			deferred = append(deferred, page)
+		} else {
+			s.drop(page.ptr)
+		}
+	}
+	s.deferred = deferred
+	if len(s.snapshots) == 0 {
+		s.current = map[uint64]*BNode{}
+	}
+	for ptr, versions := range s.versions {
+		kept := []pageVersion{}
+		from := uint64(0)
+		for _, version := range versions {
+			if s.live(from, version.until) {
+				kept = append(kept, version)
+			}
+			from = version.until
+		}
+		if len(kept) == 0 {
+			delete(s.versions, ptr)
+		} else {
+			s.versions[ptr] = kept
+		}
+	}
+}
+
+// Snapshot is a read-only view of a `ConcurrentBTree` at the time it was taken, later writes to the tree are not
+// seen by it. It is safe for concurrent use with writers. Pages it reads are not reclaimed until it is released.
+type Snapshot struct {
+	tree     *ConcurrentBTree
+	epoch    uint64
+	view     BTree
+	released bool
+}
+
+// Take a snapshot of the tree, `Release` it when done
+func (c *ConcurrentBTree) Snapshot() *Snapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	snapshot := &Snapshot{tree: c, epoch: c.versions.acquire()}
+	snapshot.view = BTree{
+		Root:   c.tree.Root,
+		Order:  c.tree.Order,
+		MinKey: c.tree.MinKey,
+		Get: func(ptr uint64) *BNode {
+			c.mu.RLock()
+			defer c.mu.RUnlock()
+			return c.versions.read(ptr, snapshot.epoch)
+		},
+	}
+	return snapshot
+}
+
+// Tree of the snapshot, it must not be written and it is valid until the snapshot is released. Two snapshots of a
+// tree read the same node object from a page whose sub-tree is not changed between them, so `Diff` skips it
+func (s *Snapshot) Tree() BTree {
+	return s.view
+}
+
+func (s *Snapshot) Search(key Data) (Data, bool) {
+	return s.view.Search(key)
+}
+
+// Call `fn` for every key / value pair with `start` <= key < `end` in ascending order, until `fn` returns false.
+// Unlike `ConcurrentBTree.Scan`, writers are not blocked meanwhile
+func (s *Snapshot) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	s.view.Scan(start, end, fn)
+}
+
+// Create an iterator over key / value pairs with `start` <= key < `end`, it is valid until the snapshot is released
+func (s *Snapshot) Iterator(start Data, end Data) *Iterator {
+	return s.view.Iterator(start, end)
+}
+
+// Release the snapshot, so pages only it can read are reclaimed. It must not be used after
+func (s *Snapshot) Release() {
+	s.tree.mu.Lock()
+	defer s.tree.mu.Unlock()
+	if s.released {
+		return
+	}
+	s.released = true
+	s.tree.versions.release(s.epoch)
+}
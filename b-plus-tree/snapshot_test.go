@@ -0,0 +1,123 @@
+package bplustree
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// number of nodes reachable from root
+func countNodes(t BTree, ptr uint64) int {
+	node := t.Get(ptr)
+	if node == nil {
+		return 0
+	}
+	count := 1
+	for i := uint8(0); !node.IsLeaf && i <= node.NumKeys; i++ {
+		count += countNodes(t, node.Child[i])
+	}
+	return count
+}
+
+func snapshotKeys(t *testing.T, s *Snapshot) []uint16 {
+	keys := []uint16{}
+	for it := s.Iterator(nil, nil); it.Next(); {
+		keys = append(keys, uint16(it.Key()[0])<<8|uint16(it.Key()[1]))
+		assert.EqualValues(t, createData(keys[len(keys)-1]), it.Value())
+	}
+	return keys
+}
+
+func keyRange(from uint16, to uint16, step uint16) []uint16 {
+	keys := []uint16{}
+	for key := from; key <= to; key += step {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func TestSnapshot(t *testing.T) {
+	tree, store := newSyncStoreTree(4)
+	c := NewConcurrentBTree(tree)
+	for key := uint16(1); key <= 50; key++ {
+		c.Insert(createBigEndianData(key), createData(key))
+	}
+	first := c.Snapshot()
+	for key := uint16(2); key <= 50; key += 2 {
+		assert.True(t, c.Delete(createBigEndianData(key)))
+	}
+	second := c.Snapshot()
+	for key := uint16(100); key <= 150; key++ {
+		c.Insert(createBigEndianData(key), createData(key))
+	}
+	for key := uint16(1); key <= 50; key += 2 {
+		assert.True(t, c.Delete(createBigEndianData(key)))
+	}
+	assert.Empty(t, c.Validate())
+
+	assert.Equal(t, keyRange(1, 50, 1), snapshotKeys(t, first))
+	assert.Equal(t, keyRange(1, 50, 2), snapshotKeys(t, second))
+	_, found := first.Search(createBigEndianData(2))
+	assert.True(t, found)
+	_, found = second.Search(createBigEndianData(2))
+	assert.False(t, found)
+	_, found = second.Search(createBigEndianData(100))
+	assert.False(t, found)
+
+	// pages deleted by merges are kept while a snapshot can read them
+	assert.Greater(t, store.len(), countNodes(c.tree, c.tree.Root))
+	first.Release()
+	assert.Equal(t, keyRange(1, 50, 2), snapshotKeys(t, second))
+	second.Release()
+	second.Release()
+	assert.Equal(t, countNodes(c.tree, c.tree.Root), store.len())
+	assert.Empty(t, c.versions.versions)
+	assert.Empty(t, c.versions.deferred)
+
+	// without snapshot, pages are deleted at once
+	for key := uint16(100); key <= 150; key++ {
+		assert.True(t, c.Delete(createBigEndianData(key)))
+	}
+	assert.Equal(t, 0, store.len())
+}
+
+func TestSnapshotWithConcurrentWriters(t *testing.T) {
+	c := NewConcurrentBTree(newSyncTree(4))
+	for key := uint16(0); key < 1000; key += 2 {
+		c.Insert(createBigEndianData(key), createData(key))
+	}
+	snapshot := c.Snapshot()
+	defer snapshot.Release()
+
+	var wg sync.WaitGroup
+	for worker := uint16(0); worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker uint16) {
+			defer wg.Done()
+			for key := worker; key < 1000; key += 4 {
+				if key%2 == 0 {
+					c.Delete(createBigEndianData(key))
+				} else {
+					c.Insert(createBigEndianData(key), createData(key))
+				}
+			}
+		}(worker)
+	}
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.Equal(t, keyRange(0, 998, 2), snapshotKeys(t, snapshot))
+		}()
+	}
+	wg.Wait()
+
+	assert.Empty(t, c.Validate())
+	keys := []uint16{}
+	c.Scan(nil, nil, func(key Data, value Data) bool {
+		keys = append(keys, uint16(key[0])<<8|uint16(key[1]))
+		return true
+	})
+	assert.Equal(t, keyRange(1, 999, 2), keys)
+}
@@ -0,0 +1,213 @@
+package bplustree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+*
+A value of `TTLStore` is stored in leaf `Values` with its expiry in front of it:
+
+| expiry | value
+| 8B     |
+
+expiry: unix time in nanoseconds, BigEndian, TTL_NO_EXPIRY if the key never expires
+Every key which expires has an entry `expiry + key` with empty value in the expiry index, so the index walks keys
+in ascending order of expiry
+*
+*/
+
+const (
+	TTL_NO_EXPIRY   uint64 = 0
+	TTL_EXPIRY_SIZE        = 8
+)
+
+// Clock tells the current time, `TTLStore` reads time only from it, so tests can move time forward
+type Clock func() time.Time
+
+func encodeTTLValue(expiry uint64, value Data) Data {
+	return append(binary.BigEndian.AppendUint64(make(Data, 0, TTL_EXPIRY_SIZE+len(value)), expiry), value...)
+}
+
+// Returns:
+//
+//	uint64: expiry, TTL_NO_EXPIRY if value is too short to have one
+//	Data: value
+func decodeTTLValue(stored Data) (uint64, Data) {
+	if len(stored) < TTL_EXPIRY_SIZE {
+		return TTL_NO_EXPIRY, stored
+	}
+	return binary.BigEndian.Uint64(stored), stored[TTL_EXPIRY_SIZE:]
+}
+
+func expiryIndexKey(expiry uint64, key Data) Data {
+	return append(binary.BigEndian.AppendUint64(make(Data, 0, TTL_EXPIRY_SIZE+len(key)), expiry), key...)
+}
+
+// TTLStore wraps a `BTree` whose keys can expire: an expired key is absent for `Search`, and it is removed by `Reap`,
+// or by the reaper goroutine started by `StartReaper`. Expiring keys are also kept in an expiry index tree, so
+// `Reap` only visits expired keys. Both trees must be changed only through `TTLStore`, which is safe for concurrent use
+type TTLStore struct {
+	mu    sync.Mutex
+	tree  *BTree
+	index *BTree
+	clock Clock
+	stop  chan struct{} // closed to stop the reaper
+	done  chan struct{} // closed when the reaper returns
+}
+
+// Create a store over `tree` with expiry index `index`, nil `clock` uses `time.Now`
+func NewTTLStore(tree *BTree, index *BTree, clock Clock) *TTLStore {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &TTLStore{tree: tree, index: index, clock: clock}
+}
+
+func (s *TTLStore) now() uint64 {
+	return uint64(s.clock().UnixNano())
+}
+
+// Set `value` of `key` which never expires
+func (s *TTLStore) Insert(key Data, value Data) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, TTL_NO_EXPIRY)
+}
+
+// Set `value` of `key` which expires after `ttl` from now
+func (s *TTLStore) InsertWithTTL(key Data, value Data, ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value, uint64(s.clock().Add(ttl).UnixNano()))
+}
+
+// Replace value of `key` in a single descent, and move its entry in expiry index
+func (s *TTLStore) put(key Data, value Data, expiry uint64) {
+	s.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		if exists {
+			if oldExpiry, _ := decodeTTLValue(old); oldExpiry != TTL_NO_EXPIRY {
+				s.index.Delete(expiryIndexKey(oldExpiry, key))
+			}
+		}
+		return encodeTTLValue(expiry, value), true
+	})
+	if expiry != TTL_NO_EXPIRY {
+		s.index.Insert(expiryIndexKey(expiry, key), Data{})
+	}
+}
+
+// Value of `key`, an expired key is absent even if it is not reaped yet
+func (s *TTLStore) Search(key Data) (Data, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stored, found := s.tree.Search(key)
+	if !found {
+		return nil, false
+	}
+	expiry, value := decodeTTLValue(stored)
+	if expiry != TTL_NO_EXPIRY && expiry <= s.now() {
+		return nil, false
+	}
+	return value, true
+}
+
+func (s *TTLStore) Delete(key Data) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	deleted := false
+	s.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+		if exists {
+			if expiry, _ := decodeTTLValue(old); expiry != TTL_NO_EXPIRY {
+				s.index.Delete(expiryIndexKey(expiry, key))
+			}
+		}
+		deleted = exists
+		return nil, false
+	})
+	return deleted
+}
+
+// Remove at most `limit` expired keys, in ascending order of expiry.
+// Returns:
+//
+//	int: number of removed keys, less than `limit` if there is no more expired key
+func (s *TTLStore) Reap(limit int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// every index entry less than it has expired
+	end := binary.BigEndian.AppendUint64(nil, s.now()+1)
+	reaped := 0
+	for cursor := s.index.Cursor(nil, end); reaped < limit && cursor.Next(); {
+		// an index key has the same layout as a stored value, expiry followed by key
+		expiry, key := decodeTTLValue(cursor.Key())
+		cursor.Delete()
+		s.tree.Update(key, func(old Data, exists bool) (Data, bool) {
+			// key is only deleted if it still expires at the time of its index entry
+			if exists {
+				if current, _ := decodeTTLValue(old); current != expiry {
+					return old, true
+				}
+			}
+			return nil, false
+		})
+		reaped += 1
+	}
+	return reaped
+}
+
+// Start a goroutine which reaps expired keys every `interval`, `batchSize` keys at a time, so writers can go
+// between batches. Call `Stop` to stop it, it does nothing if the reaper is already running.
+// Returns:
+//
+//	error: if `interval` or `batchSize` is not positive
+func (s *TTLStore) StartReaper(interval time.Duration, batchSize int) error {
+	if interval <= 0 || batchSize <= 0 {
+		return fmt.Errorf("reaper interval = %v and batch size = %d must be positive", interval, batchSize)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stop != nil {
+		return nil
+	}
+	s.stop = make(chan struct{})
+	s.done = make(chan struct{})
+	go s.reap(interval, batchSize, s.stop, s.done)
+	return nil
+}
+
+func (s *TTLStore) reap(interval time.Duration, batchSize int, stop chan struct{}, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		for s.Reap(batchSize) == batchSize {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Stop the reaper and wait until it returns, a batch being reaped is finished first
+func (s *TTLStore) Stop() {
+	s.mu.Lock()
+	stop, done := s.stop, s.done
+	s.stop, s.done = nil, nil
+	s.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
+}
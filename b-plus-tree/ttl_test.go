@@ -0,0 +1,115 @@
+package bplustree
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Clock which only moves when the test moves it, safe to read from the reaper goroutine
+type testClock struct {
+	now atomic.Int64
+}
+
+func (c *testClock) Now() time.Time {
+	return time.Unix(0, c.now.Load())
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now.Add(int64(d))
+}
+
+func newTestTTLStore(t *testing.T) (*TTLStore, *C, *C, *testClock) {
+	clock := &testClock{}
+	clock.now.Store(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano())
+	tree, index := newC(t, 4), newC(t, 4)
+	return NewTTLStore(&tree.tree, &index.tree, clock.Now), tree, index, clock
+}
+
+func TestTTLStore(t *testing.T) {
+	store, tree, index, clock := newTestTTLStore(t)
+	store.Insert(createBigEndianData(1), createData(1))
+	store.InsertWithTTL(createBigEndianData(2), createData(2), time.Minute)
+	store.InsertWithTTL(createBigEndianData(3), createData(3), time.Hour)
+
+	value, found := store.Search(createBigEndianData(2))
+	assert.True(t, found)
+	assert.EqualValues(t, createData(2), value)
+
+	clock.Advance(time.Minute)
+	_, found = store.Search(createBigEndianData(2))
+	assert.False(t, found)
+	value, found = store.Search(createBigEndianData(1))
+	assert.True(t, found)
+	assert.EqualValues(t, createData(1), value)
+
+	// replacing a key moves its expiry
+	store.InsertWithTTL(createBigEndianData(3), createData(30), time.Second)
+	store.Insert(createBigEndianData(1), createData(10))
+	assert.Equal(t, uint64(2), index.tree.Len())
+
+	assert.Equal(t, 1, store.Reap(10))
+	assert.Equal(t, uint64(2), tree.tree.Len())
+	clock.Advance(time.Second)
+	assert.Equal(t, 1, store.Reap(10))
+	assert.Equal(t, uint64(1), tree.tree.Len())
+	assert.Equal(t, uint64(0), index.tree.Len())
+	value, found = store.Search(createBigEndianData(1))
+	assert.True(t, found)
+	assert.EqualValues(t, createData(10), value)
+
+	store.InsertWithTTL(createBigEndianData(4), createData(4), time.Second)
+	assert.True(t, store.Delete(createBigEndianData(4)))
+	assert.False(t, store.Delete(createBigEndianData(4)))
+	assert.Equal(t, uint64(0), index.tree.Len())
+}
+
+func TestTTLStoreReapInBatches(t *testing.T) {
+	store, tree, index, clock := newTestTTLStore(t)
+	for i := uint16(0); i < 100; i++ {
+		store.InsertWithTTL(createBigEndianData(i), createData(i), time.Duration(i+1)*time.Second)
+	}
+	clock.Advance(50 * time.Second)
+	assert.Equal(t, 20, store.Reap(20))
+	assert.Equal(t, 20, store.Reap(20))
+	assert.Equal(t, 10, store.Reap(20))
+	assert.Equal(t, 0, store.Reap(20))
+	assert.Empty(t, tree.tree.Validate())
+	assert.Empty(t, index.tree.Validate())
+
+	// keys expire in ascending order of expiry
+	keys := []uint16{}
+	tree.tree.Scan(nil, nil, func(key Data, value Data) bool {
+		keys = append(keys, uint16(key[0])<<8|uint16(key[1]))
+		return true
+	})
+	assert.Equal(t, keyRange(50, 99, 1), keys)
+}
+
+func TestTTLStoreReaper(t *testing.T) {
+	store, tree, _, clock := newTestTTLStore(t)
+	for i := uint16(0); i < 100; i++ {
+		store.InsertWithTTL(createBigEndianData(i), createData(i), time.Second)
+	}
+	// a batch without keys would never end
+	assert.NotNil(t, store.StartReaper(time.Millisecond, 0))
+	assert.NotNil(t, store.StartReaper(0, 7))
+	assert.Nil(t, store.StartReaper(time.Millisecond, 7))
+	assert.Nil(t, store.StartReaper(time.Millisecond, 7))
+	clock.Advance(time.Second)
+	assert.Eventually(t, func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return tree.tree.Root == 0
+	}, 5*time.Second, time.Millisecond)
+	store.Stop()
+	store.Stop()
+
+	// nothing is reaped after the reaper is stopped
+	store.InsertWithTTL(createBigEndianData(1), createData(1), time.Second)
+	clock.Advance(time.Second)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, uint64(1), tree.tree.Len())
+}
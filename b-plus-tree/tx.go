@@ -0,0 +1,401 @@
+package bplustree
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	ErrTxDone     = errors.New("transaction has already been committed or rolled back")
+	ErrTxReadOnly = errors.New("transaction is read-only")
+	ErrTxBuckets  = errors.New("tree of transaction stores buckets, it is only written through buckets")
+)
+
+// DB stores a tree in a page file, it is read and changed by transactions.
+// Many read-only transactions run at the same time, a writable transaction runs alone.
+type DB struct {
+	mu     sync.RWMutex // held by a transaction until it ends
+	pageMu sync.Mutex   // `Pager` loads pages into its cache, so concurrent readers take turns
+	pager  *Pager
+}
+
+// Open a page file at `path` as a `DB`, `cipher` encrypts every page, nil to store pages in plain
+func OpenDB(path string, cipher *PageCipher) (*DB, error) {
+	pager, err := OpenPager(path, cipher)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{pager: pager}, nil
+}
+
+// Close file after running transactions end
+func (db *DB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.pager.Close()
+}
+
+const (
+	UNDO_CHANGED uint8 = iota // a node is changed in place
+	UNDO_CREATED              // a node is allocated
+	UNDO_DELETED              // a node allocated by the transaction is deleted
+	UNDO_FREED                // a node which existed before the transaction is deleted, it is returned to pager on commit
+)
+
+// A change of a writable transaction, changes are undone in reverse order on rollback
+type undoRecord struct {
+	kind uint8
+	ptr  uint64
+	node *BNode // content before change, or the deleted node
+	prev int    // index of previous record of `ptr` with the same kind, -1 if none
+}
+
+// A point of a transaction which it can roll back to
+type savepoint struct {
+	name   string
+	logLen int // number of undo records when it was created
+	root   uint64
+	trees  map[string]uint64 // roots of named trees opened by transaction
+}
+
+// Tx is a transaction of a `DB`, it sees its own writes, and nothing of other transactions until they commit.
+// A writable transaction logs content of a node before its first change since the latest savepoint,
+// so it can be rolled back as a whole, or to a savepoint. Named trees of `OpenTree` and `CreateTree` are changed
+// with the pages of the transaction, so they are committed and rolled back with its tree.
+// It must be ended by `Commit` or `Rollback`, and it is not safe for concurrent use.
+type Tx struct {
+	db         *DB
+	writable   bool
+	done       bool
+	tree       BTree
+	root       uint64 // root when transaction began
+	log        []undoRecord
+	changed    map[uint64]int    // index of latest `UNDO_CHANGED` record of a node
+	created    map[uint64]int    // index of latest `UNDO_CREATED` record of a node
+	freed      map[uint64]*BNode // nodes deleted by transaction, returned to pager on commit
+	savepoints []savepoint
+	buckets    *Bucket // root bucket, with handles of sub-buckets opened by the transaction
+	trees      map[string]*BTree
+	newTrees   map[string]bool // named trees created by transaction
+}
+
+// Begin a transaction, it waits until a running writable transaction ends,
+// or until every running transaction ends if `writable` is true
+func (db *DB) Begin(writable bool) *Tx {
+	if writable {
+		db.mu.Lock()
+	} else {
+		db.mu.RLock()
+	}
+	tx := &Tx{db: db, writable: writable, root: db.pager.root, trees: map[string]*BTree{}, newTrees: map[string]bool{}}
+	if writable {
+		tx.changed = map[uint64]int{}
+		tx.created = map[uint64]int{}
+		tx.freed = map[uint64]*BNode{}
+	}
+	tx.tree = *tx.newTree(db.pager.root, ORDER)
+	return tx
+}
+
+// Tree at `root` whose pages are read, and changed if the transaction is writable, through the transaction
+func (tx *Tx) newTree(root uint64, order uint8) *BTree {
+	tree := &BTree{
+		Root:   root,
+		Order:  order,
+		MinKey: (order+1)/2 - 1,
+		Get:    tx.db.get,
+	}
+	if tx.writable {
+		tree.New = tx.new
+		tree.Del = tx.del
+		tree.Dirty = tx.dirty
+	}
+	return tree
+}
+
+// Named tree of `name` in the page file, seen and changed by the transaction. Every call returns the same tree
+// until the transaction ends
+func (tx *Tx) OpenTree(name string) (*BTree, error) {
+	if tx.done {
+		return nil, ErrTxDone
+	}
+	if tree, ok := tx.trees[name]; ok {
+		return tree, nil
+	}
+	entry, exists := tx.db.pager.catalog[name]
+	if !exists {
+		return nil, fmt.Errorf("tree %q does not exist", name)
+	}
+	tx.trees[name] = tx.newTree(entry.root, entry.order)
+	return tx.trees[name], nil
+}
+
+// Create an empty named tree of `name`, it exists in the page file once the transaction commits
+func (tx *Tx) CreateTree(name string, order uint8) (*BTree, error) {
+	if err := tx.writeCheck(); err != nil {
+		return nil, err
+	}
+	if _, err := tx.db.pager.CreateTree(name, order); err != nil {
+		return nil, err
+	}
+	tx.newTrees[name] = true
+	tx.trees[name] = tx.newTree(0, order)
+	return tx.trees[name], nil
+}
+
+// Set roots of named trees opened by the transaction to `roots`. A tree which is not in `roots` is set to its
+// committed root, or removed from catalog if the transaction created it
+func (tx *Tx) restoreTrees(roots map[string]uint64) {
+	catalog := tx.db.pager.catalog
+	for name, tree := range tx.trees {
+		if root, ok := roots[name]; ok {
+			tree.Root = root
+		} else if tx.newTrees[name] {
+			tree.Root = 0
+			delete(catalog, name)
+			delete(tx.newTrees, name)
+			delete(tx.trees, name)
+		} else {
+			tree.Root = catalog[name].root
+		}
+	}
+}
+
+func (db *DB) get(ptr uint64) *BNode {
+	db.pageMu.Lock()
+	defer db.pageMu.Unlock()
+	return db.pager.Get(ptr)
+}
+
+// Append a record of `ptr` to undo log, `records` tracks index of its latest record of the same kind
+func (tx *Tx) logUndo(kind uint8, ptr uint64, node *BNode, records map[uint64]int) {
+	record := undoRecord{kind: kind, ptr: ptr, node: node, prev: -1}
+	if records != nil {
+		if prev, ok := records[ptr]; ok {
+			record.prev = prev
+		}
+		records[ptr] = len(tx.log)
+	}
+	tx.log = append(tx.log, record)
+}
+
+// Number of undo records when the latest savepoint was created
+func (tx *Tx) savepointStart() int {
+	if total := len(tx.savepoints); total > 0 {
+		return tx.savepoints[total-1].logLen
+	}
+	return 0
+}
+
+func (tx *Tx) new(node *BNode) uint64 {
+	ptr := tx.db.pager.New(node)
+	tx.logUndo(UNDO_CREATED, ptr, nil, tx.created)
+	return ptr
+}
+
+// A node which existed before the transaction is only deleted on commit, so it can be restored on rollback
+func (tx *Tx) del(ptr uint64) {
+	node := tx.db.pager.Get(ptr)
+	if _, ok := tx.created[ptr]; ok {
+		tx.db.pager.Del(ptr)
+		tx.logUndo(UNDO_DELETED, ptr, node, nil)
+		return
+	}
+	tx.freed[ptr] = node
+	tx.logUndo(UNDO_FREED, ptr, node, nil)
+}
+
+// Log content of a node before its first change since the latest savepoint, unless it is allocated after that
+func (tx *Tx) dirty(ptr uint64) {
+	start := tx.savepointStart()
+	if index, ok := tx.created[ptr]; ok && index >= start {
+		return
+	}
+	if index, ok := tx.changed[ptr]; !ok || index < start {
+		tx.logUndo(UNDO_CHANGED, ptr, cloneNode(tx.db.pager.Get(ptr)), tx.changed)
+	}
+	tx.db.pager.Dirty(ptr)
+}
+
+// Undo changes in reverse order, until only `logLen` records are left
+func (tx *Tx) undoTo(logLen int) {
+	pager := tx.db.pager
+	for i := len(tx.log) - 1; i >= logLen; i-- {
+		record := tx.log[i]
+		var records map[uint64]int
+		switch record.kind {
+		case UNDO_CHANGED:
+			*pager.Get(record.ptr) = *record.node
+			records = tx.changed
+		case UNDO_CREATED:
+			pager.Del(record.ptr)
+			records = tx.created
+		case UNDO_DELETED:
+			pager.undelete(record.ptr, record.node)
+		case UNDO_FREED:
+			delete(tx.freed, record.ptr)
+		}
+		if records == nil {
+			continue
+		}
+		if record.prev < 0 {
+			delete(records, record.ptr)
+		} else {
+			records[record.ptr] = record.prev
+		}
+	}
+	tx.log = tx.log[:logLen]
+}
+
+// Check whether the transaction can change the tree
+func (tx *Tx) writeCheck() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		return ErrTxReadOnly
+	}
+	return nil
+}
+
+func (tx *Tx) Search(key Data) (Data, bool) {
+	if tx.done {
+		return nil, false
+	}
+	return tx.tree.Search(key)
+}
+
+func (tx *Tx) Scan(start Data, end Data, fn func(key Data, value Data) bool) {
+	if tx.done {
+		return
+	}
+	tx.tree.Scan(start, end, fn)
+}
+
+// A pair written by `Insert`, `Delete`, `ApplyBatch` or a cursor is not a value of a bucket, so it is rejected once
+// the tree stores buckets
+func (tx *Tx) pairsCheck() error {
+	if err := tx.writeCheck(); err != nil {
+		return err
+	}
+	if tx.rootBucket().isMarked() {
+		return ErrTxBuckets
+	}
+	return nil
+}
+
+// Check a pair write of `key`, the empty key is reserved for the marker of buckets
+func (tx *Tx) pairWriteCheck(key Data) error {
+	if err := tx.pairsCheck(); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("empty key is reserved for buckets")
+	}
+	return nil
+}
+
+func (tx *Tx) Insert(key Data, value Data) error {
+	if err := tx.pairWriteCheck(key); err != nil {
+		return err
+	}
+	tx.tree.Insert(key, value)
+	return nil
+}
+
+func (tx *Tx) Delete(key Data) (bool, error) {
+	if err := tx.pairWriteCheck(key); err != nil {
+		return false, err
+	}
+	return tx.tree.Delete(key), nil
+}
+
+// Write every change of the transaction to disk, then end it. If the commit fails, the transaction is rolled back
+func (tx *Tx) Commit() error {
+	if tx.done {
+		return ErrTxDone
+	}
+	if !tx.writable {
+		tx.end()
+		return nil
+	}
+	pager := tx.db.pager
+	for ptr := range tx.freed {
+		pager.Del(ptr)
+	}
+	// pager writes roots of trees of its catalog, their committed roots are kept in entries until commit succeeds
+	for name, tree := range tx.trees {
+		pager.namedTree(pager.catalog[name]).Root = tree.Root
+	}
+	if err := pager.Commit(tx.tree.Root); err != nil {
+		for ptr, node := range tx.freed {
+			pager.undelete(ptr, node)
+		}
+		for name := range tx.trees {
+			entry := pager.catalog[name]
+			entry.tree.Root = entry.root
+		}
+		tx.Rollback()
+		return err
+	}
+	tx.end()
+	return nil
+}
+
+// Restore every node changed by the transaction and root pointer, then end it
+func (tx *Tx) Rollback() {
+	if tx.done {
+		return
+	}
+	if tx.writable {
+		tx.undoTo(0)
+		tx.tree.Root = tx.root
+		tx.restoreTrees(nil)
+	}
+	tx.end()
+}
+
+// Create a savepoint with `name`, the transaction can roll back to it later. A savepoint with the same name as an
+// older one hides it
+func (tx *Tx) Savepoint(name string) error {
+	if err := tx.writeCheck(); err != nil {
+		return err
+	}
+	roots := map[string]uint64{}
+	for treeName, tree := range tx.trees {
+		roots[treeName] = tree.Root
+	}
+	tx.savepoints = append(tx.savepoints, savepoint{name: name, logLen: len(tx.log), root: tx.tree.Root, trees: roots})
+	return nil
+}
+
+// Undo inserts and deletes since the latest savepoint with `name`. The savepoint is kept, and savepoints created
+// after it are removed
+func (tx *Tx) RollbackTo(name string) error {
+	if err := tx.writeCheck(); err != nil {
+		return err
+	}
+	for i := len(tx.savepoints) - 1; i >= 0; i-- {
+		if tx.savepoints[i].name != name {
+			continue
+		}
+		tx.undoTo(tx.savepoints[i].logLen)
+		tx.tree.Root = tx.savepoints[i].root
+		tx.restoreTrees(tx.savepoints[i].trees)
+		tx.savepoints = tx.savepoints[:i+1]
+		// handles may point to sub-buckets which are undone
+		tx.buckets = nil
+		return nil
+	}
+	return fmt.Errorf("savepoint %q does not exist", name)
+}
+
+func (tx *Tx) end() {
+	tx.done = true
+	if tx.writable {
+		tx.db.mu.Unlock()
+	} else {
+		tx.db.mu.RUnlock()
+	}
+}
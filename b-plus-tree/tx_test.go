@@ -0,0 +1,272 @@
+package bplustree
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestDB(t *testing.T, path string) *DB {
+	db, err := OpenDB(path, nil)
+	assert.Nil(t, err)
+	return db
+}
+
+func TestTxCommit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	for i := uint16(0); i < 100; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+		// read own writes
+		val, found := tx.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, createData(i))
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, ErrTxDone, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	for i := uint16(0); i < 100; i++ {
+		val, found := tx.Search(createBigEndianData(i))
+		assert.True(t, found)
+		assert.EqualValues(t, val, createData(i))
+	}
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func TestTxRollback(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	for i := uint16(0); i < 100; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Commit())
+	pageCount := db.pager.pageCount
+
+	tx = db.Begin(true)
+	for i := uint16(0); i < 100; i += 2 {
+		deleted, err := tx.Delete(createBigEndianData(i))
+		assert.Nil(t, err)
+		assert.True(t, deleted)
+	}
+	for i := uint16(100); i < 300; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	_, found := tx.Search(createBigEndianData(0))
+	assert.False(t, found)
+	assert.Empty(t, tx.tree.Validate())
+	tx.Rollback()
+	tx.Rollback()
+	assert.Equal(t, ErrTxDone, tx.Insert(createBigEndianData(1), createData(1)))
+
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(0, 99, 1), txKeys(tx))
+	assert.Nil(t, tx.Commit())
+
+	// pages allocated by rolled back transaction are free, and reused
+	assert.Equal(t, int(db.pager.pageCount-pageCount), len(db.pager.free))
+	tx = db.Begin(true)
+	assert.Nil(t, tx.Insert(createBigEndianData(100), createData(100)))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	_, found = tx.Search(createBigEndianData(100))
+	assert.True(t, found)
+	_, found = tx.Search(createBigEndianData(101))
+	assert.False(t, found)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func txKeys(tx *Tx) []uint16 {
+	keys := []uint16{}
+	tx.Scan(nil, nil, func(key Data, value Data) bool {
+		keys = append(keys, uint16(key[0])<<8|uint16(key[1]))
+		return true
+	})
+	return keys
+}
+
+func TestTxSavepoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	for i := uint16(0); i < 100; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Commit())
+
+	tx = db.Begin(true)
+	for i := uint16(100); i < 150; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Savepoint("batch"))
+	for i := uint16(0); i < 150; i += 3 {
+		_, err := tx.Delete(createBigEndianData(i))
+		assert.Nil(t, err)
+	}
+	for i := uint16(150); i < 300; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.RollbackTo("batch"))
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(0, 149, 1), txKeys(tx))
+
+	// savepoint is kept, so a batch is retried and rolled back again
+	for i := uint16(0); i < 300; i += 2 {
+		_, err := tx.Delete(createBigEndianData(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tx.RollbackTo("batch"))
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(0, 149, 1), txKeys(tx))
+
+	// nested savepoints, rolling back to outer one removes inner one
+	assert.Nil(t, tx.Insert(createBigEndianData(150), createData(150)))
+	assert.Nil(t, tx.Savepoint("inner"))
+	for i := uint16(0); i < 150; i++ {
+		_, err := tx.Delete(createBigEndianData(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tx.RollbackTo("inner"))
+	assert.Equal(t, keyRange(0, 150, 1), txKeys(tx))
+	assert.Nil(t, tx.Savepoint("inner"))
+	assert.Nil(t, tx.Insert(createBigEndianData(151), createData(151)))
+	assert.Nil(t, tx.RollbackTo("batch"))
+	assert.Equal(t, keyRange(0, 149, 1), txKeys(tx))
+	assert.NotNil(t, tx.RollbackTo("inner"))
+
+	assert.Nil(t, tx.Insert(createBigEndianData(200), createData(200)))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, append(keyRange(0, 149, 1), 200), txKeys(tx))
+	assert.Equal(t, ErrTxReadOnly, tx.Savepoint("batch"))
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
+
+func TestTxRollbackAfterSavepoint(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "db"))
+	tx := db.Begin(true)
+	for i := uint16(0); i < 100; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Commit())
+	pageCount := db.pager.pageCount
+
+	tx = db.Begin(true)
+	for i := uint16(100); i < 200; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	assert.Nil(t, tx.Savepoint("first"))
+	for i := uint16(50); i < 200; i++ {
+		_, err := tx.Delete(createBigEndianData(i))
+		assert.Nil(t, err)
+	}
+	assert.Nil(t, tx.Savepoint("second"))
+	for i := uint16(300); i < 400; i++ {
+		assert.Nil(t, tx.Insert(createBigEndianData(i), createData(i)))
+	}
+	tx.Rollback()
+	assert.Equal(t, ErrTxDone, tx.RollbackTo("first"))
+
+	tx = db.Begin(false)
+	assert.Empty(t, tx.tree.Validate())
+	assert.Equal(t, keyRange(0, 99, 1), txKeys(tx))
+	assert.Nil(t, tx.Commit())
+	assert.Equal(t, int(db.pager.pageCount-pageCount), len(db.pager.free))
+	assert.Nil(t, db.Close())
+}
+
+func TestTxReadOnly(t *testing.T) {
+	db := openTestDB(t, filepath.Join(t.TempDir(), "db"))
+	tx := db.Begin(true)
+	assert.Nil(t, tx.Insert(createBigEndianData(1), createData(1)))
+	assert.Nil(t, tx.Commit())
+
+	// read-only transactions run at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tx := db.Begin(false)
+			defer tx.Rollback()
+			val, found := tx.Search(createBigEndianData(1))
+			assert.True(t, found)
+			assert.EqualValues(t, val, createData(1))
+			assert.Equal(t, ErrTxReadOnly, tx.Insert(createBigEndianData(2), createData(2)))
+			_, err := tx.Delete(createBigEndianData(1))
+			assert.Equal(t, ErrTxReadOnly, err)
+		}()
+	}
+	wg.Wait()
+	assert.Nil(t, db.Close())
+}
+
+func TestTxNamedTrees(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db")
+	db := openTestDB(t, path)
+	tx := db.Begin(true)
+	users, err := tx.CreateTree("users", 4)
+	assert.Nil(t, err)
+	users.Insert(createBigEndianData(1), createData(1))
+	tx.Rollback()
+
+	// a tree created by a rolled back transaction does not exist
+	tx = db.Begin(true)
+	_, err = tx.OpenTree("users")
+	assert.NotNil(t, err)
+	users, err = tx.CreateTree("users", 4)
+	assert.Nil(t, err)
+	for i := uint16(0); i < 100; i++ {
+		users.Insert(createBigEndianData(i), createData(i))
+	}
+	assert.Nil(t, tx.Savepoint("batch"))
+	orders, err := tx.CreateTree("orders", 4)
+	assert.Nil(t, err)
+	orders.Insert(createBigEndianData(1), createData(1))
+	for i := uint16(0); i < 100; i += 2 {
+		assert.True(t, users.Delete(createBigEndianData(i)))
+	}
+	assert.Nil(t, tx.RollbackTo("batch"))
+	assert.Equal(t, uint64(100), users.Len())
+	_, err = tx.OpenTree("orders")
+	assert.NotNil(t, err)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+
+	db = openTestDB(t, path)
+	tx = db.Begin(true)
+	users, err = tx.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Empty(t, users.Validate())
+	assert.Equal(t, uint64(100), users.Len())
+	for i := uint16(0); i < 50; i++ {
+		assert.True(t, users.Delete(createBigEndianData(i)))
+	}
+	tx.Rollback()
+	tx = db.Begin(false)
+	users, err = tx.OpenTree("users")
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(100), users.Len())
+	_, err = tx.CreateTree("orders", 4)
+	assert.Equal(t, ErrTxReadOnly, err)
+	assert.Nil(t, tx.Commit())
+	assert.Nil(t, db.Close())
+}
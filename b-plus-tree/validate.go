@@ -0,0 +1,209 @@
+package bplustree
+
+import (
+	"fmt"
+)
+
+type ViolationKind uint8
+
+const (
+	VIOLATION_MISSING_PAGE      ViolationKind = iota // a pointer refers to a page which does not exist
+	VIOLATION_DUPLICATED_PAGE                        // a page is reachable more than once
+	VIOLATION_UNORDERED_KEYS                         // keys inside a node are not in ascending order
+	VIOLATION_SEPARATOR_BOUNDS                       // a key is outside of bounds given by separators of ancestors
+	VIOLATION_UNEVEN_LEAF_DEPTH                      // leaves are not at the same depth
+	VIOLATION_UNDERFLOW                              // a non-root node has less than `MinKey` keys, or root is empty
+	VIOLATION_LEAF_CHAIN                             // `Next` pointers do not visit every leaf exactly once in order
+	VIOLATION_RIGHT_LINK                             // `Next` pointers do not visit every internal node of a level in order
+	VIOLATION_HIGH_KEY                               // `HighKey` of a node is not the separator bound given by its ancestors
+	VIOLATION_COUNT                                  // key count of a child in an internal node is not the number of keys in its sub-tree
+	VIOLATION_SUMMARY                                // summary of a child in an internal node is not the summary of its sub-tree
+)
+
+func (k ViolationKind) String() string {
+	switch k {
+	case VIOLATION_MISSING_PAGE:
+		return "missing page"
+	case VIOLATION_DUPLICATED_PAGE:
+		return "duplicated page"
+	case VIOLATION_UNORDERED_KEYS:
+		return "unordered keys"
+	case VIOLATION_SEPARATOR_BOUNDS:
+		return "separator bounds"
+	case VIOLATION_UNEVEN_LEAF_DEPTH:
+		return "uneven leaf depth"
+	case VIOLATION_UNDERFLOW:
+		return "underflow"
+	case VIOLATION_LEAF_CHAIN:
+		return "leaf chain"
+	case VIOLATION_RIGHT_LINK:
+		return "right link"
+	case VIOLATION_HIGH_KEY:
+		return "high key"
+	case VIOLATION_COUNT:
+		return "count"
+	case VIOLATION_SUMMARY:
+		return "summary"
+	}
+	return fmt.Sprintf("violation %d", uint8(k))
+}
+
+// A structural problem found in a tree
+type Violation struct {
+	Kind   ViolationKind
+	Page   uint64 // pointer of the node where the problem is found
+	Detail string
+}
+
+func (v Violation) Error() string {
+	return fmt.Sprintf("page %d: %s: %s", v.Page, v.Kind, v.Detail)
+}
+
+// state shared while walking a tree in `Validate`
+type validation struct {
+	tree       *BTree
+	visited    map[uint64]bool
+	levels     [][]uint64 // nodes of every depth in order of keys, leaves are the last level
+	leafDepth  int
+	violations []Violation
+}
+
+func (v *validation) report(kind ViolationKind, page uint64, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Kind: kind, Page: page, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Validate structure of the tree, returns every violation found, empty when the tree is sound:
+//
+//	keys inside a node are in ascending order, and inside bounds given by separators of ancestors
+//	every leaf is at the same depth
+//	every non-root node has at least `MinKey` keys
+//	`Next` pointers of each level visit every node of the level exactly once in order
+//	`HighKey` of a node, if it is known, is the upper bound given by separators of ancestors
+//	key count of every child of an internal node is the number of keys in its sub-tree
+//	summary of every child of an internal node is the summary of its sub-tree, if tree has `Monoid`
+//	no page is reachable twice
+func (t BTree) Validate() []Violation {
+	v := &validation{
+		tree:      &t,
+		visited:   map[uint64]bool{},
+		leafDepth: -1,
+	}
+	if t.Root == 0 {
+		return nil
+	}
+	if t.Get(t.Root) == nil {
+		v.report(VIOLATION_MISSING_PAGE, t.Root, "root does not exist")
+		return v.violations
+	}
+	v.validateNode(t.Root, 0, nil, nil)
+	for depth, level := range v.levels {
+		kind := VIOLATION_RIGHT_LINK
+		if depth == v.leafDepth {
+			kind = VIOLATION_LEAF_CHAIN
+		}
+		v.validateChain(level, kind)
+	}
+	return v.violations
+}
+
+// Validate a sub-tree at `nodePtr`, every key must be in range [`lower`, `upper`], nil means no bound.
+// `upper` is inclusive because duplicated keys equal to a separator may stay in the left child.
+// Returns:
+//
+//	uint64: number of keys in the sub-tree
+//	Data: summary of pairs in the sub-tree computed from its leaves, nil if tree has no `Monoid`
+func (v *validation) validateNode(nodePtr uint64, depth int, lower Data, upper Data) (uint64, Data) {
+	t := v.tree
+	if v.visited[nodePtr] {
+		v.report(VIOLATION_DUPLICATED_PAGE, nodePtr, "reachable more than once")
+		return 0, nil
+	}
+	v.visited[nodePtr] = true
+	node := t.Get(nodePtr)
+
+	if nodePtr == t.Root {
+		if node.NumKeys == 0 {
+			v.report(VIOLATION_UNDERFLOW, nodePtr, "root has no keys")
+		}
+	} else if node.NumKeys < t.MinKey {
+		v.report(VIOLATION_UNDERFLOW, nodePtr, "has %d keys, minimum is %d", node.NumKeys, t.MinKey)
+	}
+	if node.HighKey != nil && (upper == nil || !node.HighKey.eq(upper)) {
+		v.report(VIOLATION_HIGH_KEY, nodePtr, "high key is %v, expected %v", node.HighKey, upper)
+	}
+	if depth == len(v.levels) {
+		v.levels = append(v.levels, nil)
+	}
+	v.levels[depth] = append(v.levels[depth], nodePtr)
+
+	for i := uint8(0); i < node.NumKeys; i++ {
+		key := node.Keys[i]
+		if i > 0 && key.lt(node.Keys[i-1]) {
+			v.report(VIOLATION_UNORDERED_KEYS, nodePtr, "key %d %v is less than key %d %v", i, key, i-1, node.Keys[i-1])
+		}
+		if (lower != nil && key.lt(lower)) || (upper != nil && key.gt(upper)) {
+			v.report(VIOLATION_SEPARATOR_BOUNDS, nodePtr, "key %d %v is out of bounds [%v, %v]", i, key, lower, upper)
+		}
+	}
+
+	if node.IsLeaf {
+		if v.leafDepth < 0 {
+			v.leafDepth = depth
+		} else if v.leafDepth != depth {
+			v.report(VIOLATION_UNEVEN_LEAF_DEPTH, nodePtr, "leaf at depth %d, expected %d", depth, v.leafDepth)
+		}
+		var summary Data
+		if t.Monoid != nil {
+			summary = t.summarize(node)
+		}
+		return uint64(node.NumKeys), summary
+	}
+
+	var total uint64
+	var summary Data
+	if t.Monoid != nil {
+		summary = t.Monoid.Identity
+	}
+	for i := uint8(0); i <= node.NumKeys; i++ {
+		childPtr := node.Child[i]
+		if t.Get(childPtr) == nil {
+			v.report(VIOLATION_MISSING_PAGE, nodePtr, "child %d %d does not exist", i, childPtr)
+			continue
+		}
+		childLower, childUpper := lower, upper
+		if i > 0 {
+			childLower = node.Keys[i-1]
+		}
+		if i < node.NumKeys {
+			childUpper = node.Keys[i]
+		}
+		count, childSummary := v.validateNode(childPtr, depth+1, childLower, childUpper)
+		if node.Counts[i] != count {
+			v.report(VIOLATION_COUNT, nodePtr, "child %d has %d keys, counted %d", i, node.Counts[i], count)
+		}
+		total += count
+		if t.Monoid != nil {
+			if !node.Summaries[i].eq(childSummary) {
+				v.report(VIOLATION_SUMMARY, nodePtr, "child %d has summary %v, stored %v", i, childSummary, node.Summaries[i])
+			}
+			summary = t.Monoid.Combine(summary, childSummary)
+		}
+	}
+	return total, summary
+}
+
+// Follow `Next` pointers from the first node of a level, it must visit nodes in the same order as walking the tree
+func (v *validation) validateChain(level []uint64, kind ViolationKind) {
+	t := v.tree
+	cursorPtr := level[0]
+	for i := 0; i < len(level); i++ {
+		if cursorPtr != level[i] {
+			v.report(kind, level[i-1], "next node is %d, expected %d", cursorPtr, level[i])
+			return
+		}
+		cursorPtr = t.Get(cursorPtr).Next
+	}
+	if cursorPtr != 0 {
+		v.report(kind, level[len(level)-1], "last node points to %d", cursorPtr)
+	}
+}
@@ -0,0 +1,161 @@
+package bplustree
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// build a tree of order 4 with leaves [10 20] [30 40] [50 60] under one root
+func newValidateC(t *testing.T) *C {
+	c := newC(t, 4)
+	for _, key := range []int{10, 20, 30, 40, 50, 60} {
+		c.tree.Insert(createData(uint16(key)), createData(uint16(key)))
+	}
+	return c
+}
+
+func violationKinds(violations []Violation) []ViolationKind {
+	kinds := []ViolationKind{}
+	for _, violation := range violations {
+		kinds = append(kinds, violation.Kind)
+	}
+	return kinds
+}
+
+func TestValidateSoundTree(t *testing.T) {
+	c := newC(t, 4)
+	assert.Empty(t, c.tree.Validate())
+
+	c = newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	assert.False(t, root.IsLeaf)
+	assert.Empty(t, c.tree.Validate())
+}
+
+func TestValidateUnorderedKeys(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	leaf := c.tree.Get(root.Child[1])
+	leaf.Keys[0], leaf.Keys[1] = leaf.Keys[1], leaf.Keys[0]
+
+	violations := c.tree.Validate()
+	assert.Contains(t, violationKinds(violations), VIOLATION_UNORDERED_KEYS)
+	assert.Equal(t, violations[0].Page, root.Child[1])
+}
+
+func TestValidateSeparatorBounds(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	leaf := c.tree.Get(root.Child[0])
+	leaf.Keys[1] = createData(uint16(45))
+
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_SEPARATOR_BOUNDS})
+}
+
+func TestValidateUnderflow(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	leaf := c.tree.Get(root.Child[2])
+	leaf.NumKeys = 0
+	root.Counts[2] = 0
+
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_UNDERFLOW})
+}
+
+func TestValidateCount(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	assert.Equal(t, []uint64{2, 2, 2, 0}, root.Counts)
+	root.Counts[1] = 3
+
+	violations := c.tree.Validate()
+	assert.Equal(t, violationKinds(violations), []ViolationKind{VIOLATION_COUNT})
+	assert.Equal(t, violations[0].Page, c.tree.Root)
+	assert.Equal(t, "child 1 has 3 keys, counted 2", violations[0].Detail)
+}
+
+func TestValidateLeafChain(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	c.tree.Get(root.Child[0]).Next = root.Child[2]
+
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_LEAF_CHAIN})
+
+	c = newValidateC(t)
+	root = c.tree.Get(c.tree.Root)
+	c.tree.Get(root.Child[2]).Next = root.Child[0]
+
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_LEAF_CHAIN})
+}
+
+func TestValidateHighKey(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	leaf := c.tree.Get(root.Child[1])
+	assert.Equal(t, leaf.HighKey, root.Keys[1])
+	assert.Nil(t, c.tree.Get(root.Child[2]).HighKey)
+
+	leaf.HighKey = createData(uint16(45))
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_HIGH_KEY})
+
+	// unknown high key is not a violation
+	leaf.HighKey = nil
+	assert.Empty(t, c.tree.Validate())
+}
+
+func TestValidateRightLink(t *testing.T) {
+	c := newC(t, 3)
+	for key := 1; key <= 10; key++ {
+		c.add(createData(uint16(key)), createData(uint16(key)))
+	}
+	root := c.tree.Get(c.tree.Root)
+	left := c.tree.Get(root.Child[0])
+	assert.False(t, left.IsLeaf)
+	assert.Equal(t, left.Next, root.Child[1])
+
+	left.Next = 0
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_RIGHT_LINK})
+}
+
+func TestValidateDuplicatedPage(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	root.Child[2] = root.Child[1]
+
+	assert.Contains(t, violationKinds(c.tree.Validate()), VIOLATION_DUPLICATED_PAGE)
+}
+
+func TestValidateMissingPage(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+	c.tree.Del(root.Child[1])
+
+	assert.Contains(t, violationKinds(c.tree.Validate()), VIOLATION_MISSING_PAGE)
+
+	c.tree.Del(c.tree.Root)
+	assert.Equal(t, violationKinds(c.tree.Validate()), []ViolationKind{VIOLATION_MISSING_PAGE})
+}
+
+func TestValidateUnevenLeafDepth(t *testing.T) {
+	c := newValidateC(t)
+	root := c.tree.Get(c.tree.Root)
+
+	// replace last leaf with an internal node above it
+	node := newNode(4)
+	node.Keys[0] = createData(uint16(60))
+	node.NumKeys = 1
+	node.Child[0] = root.Child[2]
+	extraLeaf := newLeaf(4)
+	extraLeaf.insertToLeafNode(createData(uint16(60)), createData(uint16(60)))
+	node.Child[1] = c.tree.New(extraLeaf)
+	c.tree.Get(root.Child[2]).Next = node.Child[1]
+	root.Child[2] = c.tree.New(node)
+
+	assert.Contains(t, violationKinds(c.tree.Validate()), VIOLATION_UNEVEN_LEAF_DEPTH)
+}
+
+func TestViolationError(t *testing.T) {
+	violation := Violation{Kind: VIOLATION_UNDERFLOW, Page: 12, Detail: "has 0 keys, minimum is 1"}
+	assert.Equal(t, violation.Error(), "page 12: underflow: has 0 keys, minimum is 1")
+}
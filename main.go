@@ -1,5 +1,133 @@
 package main
 
+import (
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+
+	bplustree "mydb/m/b-plus-tree"
+)
+
+const usage = `usage: m [-key hex] <command> [arguments]
+
+commands:
+  fsck <file>            check every page and structure of every tree in <file>
+  salvage <src> <dst>    copy every intact key of every tree of <src> into a new file <dst>
+`
+
 func main() {
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	key := flag.String("key", "", "hex encoded AES key of an encrypted file")
+	flag.Parse()
+
+	var cipher *bplustree.PageCipher
+	if *key != "" {
+		rawKey, err := hex.DecodeString(*key)
+		if err == nil {
+			cipher, err = bplustree.NewPageCipher(rawKey)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "key:", err)
+			os.Exit(2)
+		}
+	}
+
+	args := flag.Args()
+	switch {
+	case len(args) == 2 && args[0] == "fsck":
+		if !fsck(args[1], cipher) {
+			os.Exit(1)
+		}
+	case len(args) == 3 && args[0] == "salvage":
+		if err := salvage(args[1], args[2], cipher); err != nil {
+			fmt.Fprintln(os.Stderr, "salvage:", err)
+			os.Exit(1)
+		}
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
+
+// Print lost pages and the key ranges they held
+func printScan(scan *bplustree.PageScan) {
+	fmt.Printf("pages: %d, nodes: %d, free: %d, lost: %d\n",
+		scan.TotalPages, len(scan.Nodes), len(scan.FreePages), len(scan.LostPages))
+	for _, lostPage := range scan.LostPages {
+		fmt.Printf("lost page %d: %v\n", lostPage.Page, lostPage.Err)
+	}
+	for _, lostRange := range scan.LostRanges() {
+		fmt.Printf("lost keys [%q, %q) of page %d, pointed by page %d\n",
+			lostRange.Start, lostRange.End, lostRange.Page, lostRange.Parent)
+	}
+	if scan.HotJournal {
+		fmt.Println("journal: a commit was interrupted, pages are read as they were before it")
+	}
+	if scan.MetaErr != nil {
+		fmt.Println("meta page:", scan.MetaErr)
+	}
+	// salvage only adds them to a file with a single tree without sub-buckets
+	if orphans := scan.OrphanLeaves(); len(orphans) > 0 {
+		fmt.Printf("leaves not reachable from a root of any tree: %v\n", orphans)
+	}
+}
+
+// Check every page, then structure of tree from its root, without changing the file or its journal.
+// Returns true if the file is sound
+func fsck(path string, cipher *bplustree.PageCipher) bool {
+	scan, err := bplustree.ScanPages(path, cipher)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "fsck:", err)
+		return false
+	}
+	printScan(scan)
+
+	pager, err := bplustree.OpenPagerReadOnly(path, cipher)
+	if err != nil {
+		fmt.Println("meta page:", err)
+		return false
+	}
+	defer pager.Close()
+	violations := pager.Tree().Validate()
+	for _, violation := range violations {
+		fmt.Println("violation", violation)
+	}
+	sound := len(scan.LostPages) == 0 && len(violations) == 0
+	for _, name := range pager.ListTrees() {
+		tree, err := pager.OpenTree(name)
+		if err != nil {
+			fmt.Println("tree", name, err)
+			return false
+		}
+		for _, violation := range tree.Validate() {
+			fmt.Printf("violation in tree %q %v\n", name, violation)
+			sound = false
+		}
+	}
+	return sound
+}
+
+// Recover intact keys of `srcPath` into a new file at `dstPath`
+func salvage(srcPath string, dstPath string, cipher *bplustree.PageCipher) error {
+	if _, err := os.Stat(dstPath); err == nil {
+		return fmt.Errorf("%s already exists", dstPath)
+	}
+	scan, err := bplustree.ScanPages(srcPath, cipher)
+	if err != nil {
+		return err
+	}
+	printScan(scan)
 
+	dst, err := bplustree.OpenPager(dstPath, cipher)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+	recovered, err := scan.Salvage(dst)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("recovered %d keys into %s\n", recovered, dstPath)
+	return nil
 }